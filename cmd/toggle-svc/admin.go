@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+
+	"github.com/s0rg/toggle-svc/pkg/api"
+	"github.com/s0rg/toggle-svc/pkg/health"
+	"github.com/s0rg/toggle-svc/pkg/metrics"
+)
+
+const (
+	expiryPageDefault = 100
+	expiryPageMax     = 1000
+)
+
+// withAdmin sets admin listener address.
+func (s *service) withAdmin(addr string) *service {
+	s.adminAddr = addr
+
+	return s
+}
+
+// adminMux serves management api, health probes, metrics, pprof, expvar and debug views.
+func (s *service) adminMux(h api.Muxer, hc *health.Checker) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", h.AdminMux())
+	mux.Handle("/healthz", hc.LiveHandler())
+	mux.Handle("/readyz", hc.ReadyHandler())
+	mux.Handle("/metrics", metrics.Handler())
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/expiry", s.debugExpiry)
+
+	return mux
+}
+
+// debugExpiry shows page (`offset` and `limit` query params) of client states, tracked by
+// expiry index, with their deadlines, earliest first.
+func (s *service) debugExpiry(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := page(r, expiryPageDefault, expiryPageMax)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	entries, err := s.rd.ExpiryEntries(r.Context(), offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err = json.NewEncoder(w).Encode(entries); err != nil {
+		log.Println("debug: expiry:", err)
+	}
+}
+
+// page parses `offset` and `limit` query params, limit is capped by max.
+func page(r *http.Request, limitDefault, limitMax int) (offset, limit int, err error) {
+	q := r.URL.Query()
+
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, errBadPage
+		}
+	}
+
+	limit = limitDefault
+
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, errBadPage
+		}
+	}
+
+	if limit > limitMax {
+		limit = limitMax
+	}
+
+	return offset, limit, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/mediocregopher/radix/v3"
+
+	"github.com/s0rg/toggle-svc/pkg/breaker"
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/health"
+)
+
+func dbCheck(conn *sql.DB) health.Check {
+	return health.Check{Name: "db", Do: conn.PingContext}
+}
+
+func migrationsCheck(conn *sql.DB) health.Check {
+	return health.Check{Name: "migrations", Do: func(ctx context.Context) error {
+		return db.CheckSchema(ctx, conn)
+	}}
+}
+
+func redisCheck(c radix.Client) health.Check {
+	return health.Check{Name: "redis", Do: func(context.Context) error {
+		return c.Do(radix.Cmd(nil, "PING"))
+	}}
+}
+
+// breakerCheck fails, while breaker rejects calls.
+func breakerCheck(b *breaker.Breaker) health.Check {
+	return health.Check{Name: b.Name() + "-breaker", Do: func(context.Context) error {
+		if st := b.State(); st != breaker.Closed {
+			return fmt.Errorf("%w: %s", breaker.ErrOpen, st)
+		}
+
+		return nil
+	}}
+}
+
+// degradable reports check failures as degraded, when service can work without dependency.
+func degradable(c health.Check, can func() bool) health.Check {
+	do := c.Do
+
+	c.Do = func(ctx context.Context) (err error) {
+		if err = do(ctx); err != nil && can() {
+			err = fmt.Errorf("%w: %v", health.ErrDegraded, err)
+		}
+
+		return err
+	}
+
+	return c
+}
+
+// workersCheck checks, that all background workers are running.
+func (s *service) workersCheck() health.Check {
+	return health.Check{Name: "workers", Live: true, Do: func(context.Context) error {
+		if n := atomic.LoadInt32(&s.running); n < s.spawned {
+			return fmt.Errorf("%d of %d background workers stopped", s.spawned-n, s.spawned)
+		}
+
+		return nil
+	}}
+}
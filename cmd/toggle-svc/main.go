@@ -1,27 +1,39 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/mediocregopher/radix/v3"
+	"github.com/rs/zerolog"
 
 	"github.com/s0rg/toggle-svc/pkg/app"
 	appDB "github.com/s0rg/toggle-svc/pkg/app/db"
+	appRedis "github.com/s0rg/toggle-svc/pkg/app/redis"
+	"github.com/s0rg/toggle-svc/pkg/breaker"
+	"github.com/s0rg/toggle-svc/pkg/certs"
 	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/health"
+	"github.com/s0rg/toggle-svc/pkg/metrics"
 	"github.com/s0rg/toggle-svc/pkg/redis"
 	"github.com/s0rg/toggle-svc/pkg/retry"
+	"github.com/s0rg/toggle-svc/pkg/tracing"
 )
 
 const (
 	appName       = "toggle-svc"
-	maxRetries    = 3
 	envKeysPrefix = "APP"
 	envDBKey      = "DB"
-	envAddr       = "ADDR"
 	envRedisKey   = "REDIS"
-	envExpiration = "EXPIRE"
 )
 
 var (
@@ -29,22 +41,118 @@ var (
 	BuildAt string
 )
 
-func run(app *app.App) (err error) {
-	var (
-		appAddr      = app.GetEnv(envAddr)
-		appRedisDSN  = app.GetEnv(envRedisKey)
-		appExpireStr = app.GetEnv(envExpiration)
-		expireVal    time.Duration
-		rdConn       radix.Client
-		dbConn       *sql.DB
-	)
+var (
+	// startupRetries waits for database and redis to come up, any error is retried.
+	startupRetries = retry.Policy{Base: time.Second, Max: 15 * time.Second, MaxElapsed: time.Minute}
+	// requestRetries hides short failovers and dropped connections from api clients.
+	requestRetries = retry.Policy{
+		Tries:      3,
+		Base:       20 * time.Millisecond,
+		Max:        200 * time.Millisecond,
+		MaxElapsed: time.Second,
+		Retryable:  retry.Transient,
+	}
+)
 
-	log.Println("build:", BuildAt, "starting")
+var (
+	errNoStores = errors.New("DB and REDIS keys are required, unless memory is set")
+	errTLSPair  = errors.New("TLS_CERT and TLS_KEY keys must be set together")
+	errKeyless  = errors.New("KEYLESS_ORG key requires database")
+)
+
+// config is a service configuration, see app.WithConfig.
+type config struct {
+	Addr        string        `env:"ADDR" default:":8080" usage:"client api listen address"`
+	AdminAddr   string        `env:"ADMIN_ADDR" default:"localhost:8081" usage:"management api, metrics and debug address"`
+	Expire      time.Duration `env:"EXPIRE" default:"5m" reload:"true" usage:"client state expiration"`
+	RootKey     string        `env:"ROOT_KEY" required:"true" secret:"true" usage:"root api key"`
+	DB          string        `env:"DB" secret:"true" usage:"database dsn"`
+	Redis       string        `env:"REDIS" secret:"true" usage:"redis dsn"`
+	Memory      bool          `env:"MEMORY" usage:"use in-memory stores, instead of postgres and redis"`
+	Snapshot    string        `env:"SNAPSHOT" usage:"path to configuration snapshot, served when database is down"`
+	Defaults    string        `env:"FALLBACK_KEYS" reload:"true" usage:"comma-separated toggles, used when redis is down"`
+	TLSCert     string        `env:"TLS_CERT" usage:"server certificate file, enables TLS"`
+	TLSKey      string        `env:"TLS_KEY" usage:"server private key file"`
+	TLSClientCA string        `env:"TLS_CLIENT_CA" usage:"client CAs file, enables mTLS for admin endpoints"`
+	KeylessOrg  string        `env:"KEYLESS_ORG" usage:"organization, that serves client api requests without api key"`
+	// Breaker is loaded as separate config.
+	Breaker breakerConfig
+}
+
+// breakerConfig holds thresholds of database and redis circuit breakers.
+type breakerConfig struct {
+	Failures int           `env:"BREAKER_FAILURES" default:"5" usage:"consecutive failed calls, that open breaker"`
+	Slow     time.Duration `env:"BREAKER_SLOW" default:"1s" usage:"calls, longer than that, are counted as failed"`
+	Cooldown time.Duration `env:"BREAKER_COOLDOWN" default:"10s" usage:"time before open breaker lets probe call"`
+}
+
+func newBreaker(name string, cfg *breakerConfig) *breaker.Breaker {
+	b := breaker.New(name, breaker.Config{
+		Failures: cfg.Failures,
+		Slow:     cfg.Slow,
+		Cooldown: cfg.Cooldown,
+		IsFailure: func(err error) bool {
+			return retry.Transient(err) || errors.Is(err, context.DeadlineExceeded)
+		},
+	})
+
+	metrics.WatchBreaker(name, func() int { return int(b.State()) })
+
+	return b
+}
+
+// serverTLS returns TLS config for api server, or nil if TLS is not configured.
+func serverTLS(cfg *config, logger zerolog.Logger) (tc *tls.Config, err error) {
+	if cfg.TLSCert == "" && cfg.TLSKey == "" {
+		return nil, nil
+	}
+
+	if cfg.TLSCert == "" || cfg.TLSKey == "" {
+		return nil, errTLSPair
+	}
+
+	var l *certs.Loader
 
-	if expireVal, err = time.ParseDuration(appExpireStr); err != nil {
+	if l, err = certs.NewLoader(cfg.TLSCert, cfg.TLSKey, logger); err != nil {
 		return
 	}
 
+	return certs.ServerConfig(l, cfg.TLSClientCA)
+}
+
+// deps holds service dependencies.
+type deps struct {
+	db         db.Store
+	rd         redis.Store
+	fb         *db.Fallback
+	checks     []health.Check
+	keylessOrg int64
+}
+
+// connect connects to database (behind cache and snapshot fallback) and redis, or creates
+// in-memory stores, if `Memory` is set.
+func connect(app *app.App, cfg *config) (d *deps, err error) {
+	if cfg.Memory {
+		if cfg.KeylessOrg != "" {
+			return nil, errKeyless
+		}
+
+		log.Println("using in-memory stores, all data will be lost on exit")
+
+		return &deps{db: tracing.DB(db.NewMemory()), rd: redis.NewMemory(cfg.Expire)}, nil
+	}
+
+	if cfg.DB == "" || cfg.Redis == "" {
+		return nil, errNoStores
+	}
+
+	var (
+		rdConn     radix.Client
+		dbConn     *sql.DB
+		cache      db.Store
+		keylessOrg int64
+	)
+
 	steps := []retry.Step{
 		{Name: "db", Do: func() (err error) {
 			dbConn, err = appDB.ForApp(app, envDBKey)
@@ -52,34 +160,144 @@ func run(app *app.App) (err error) {
 			return
 		}},
 		{Name: "redis", Do: func() (err error) {
-			rdConn, err = radix.Dial("tcp", appRedisDSN)
+			rdConn, err = appRedis.ForApp(app, envRedisKey)
 
 			return
 		}},
 	}
 
-	if err := retry.RunSteps(maxRetries, steps); err != nil {
-		return err
+	if err = retry.RunSteps(context.Background(), startupRetries, steps); err != nil {
+		return
+	}
+
+	if keylessOrg, err = findKeylessOrg(dbConn, cfg.KeylessOrg); err != nil {
+		return
+	}
+
+	dbBreaker, rdBreaker := newBreaker("db", &cfg.Breaker), newBreaker("redis", &cfg.Breaker)
+	store := retry.DB(breaker.DB(metrics.DB(tracing.DB(db.New(dbConn))), dbBreaker), requestRetries)
+
+	if cache, err = appDB.CacheForApp(app, envDBKey, dbConn, store); err != nil {
+		return
+	}
+
+	snapshot := cfg.Snapshot
+	if snapshot == "" {
+		snapshot = filepath.Join(os.TempDir(), appName+".snapshot.json")
+	}
+
+	fb := db.NewFallback(cache, snapshot)
+
+	if err = fb.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Println("snapshot: load error:", err)
 	}
 
-	app.DeferClose(rdConn)
+	hasSnapshot := func() bool { return !fb.TakenAt().IsZero() }
+	always := func() bool { return true }
 
-	s := newService(
-		appAddr,
-		db.New(dbConn),
-		redis.New(rdConn, expireVal),
+	return &deps{
+		db: fb,
+		rd: retry.Redis(breaker.Redis(metrics.Redis(redis.New(rdConn, cfg.Expire)), rdBreaker), requestRetries),
+		fb: fb,
+		checks: []health.Check{
+			degradable(dbCheck(dbConn), hasSnapshot),
+			migrationsCheck(dbConn),
+			degradable(redisCheck(rdConn), always),
+			degradable(breakerCheck(dbBreaker), hasSnapshot),
+			degradable(breakerCheck(rdBreaker), always),
+		},
+		keylessOrg: keylessOrg,
+	}, nil
+}
+
+// findKeylessOrg returns id of organization, that serves client requests without api key, or
+// zero, if name is not set.
+func findKeylessOrg(conn *sql.DB, name string) (id int64, err error) {
+	if name == "" {
+		return 0, nil
+	}
+
+	if id, err = db.GetOrgIDByName(context.Background(), conn, name); errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("%w: %s", errNoOrg, name)
+	}
+
+	return
+}
+
+func run(app *app.App, cfg *config) (err error) {
+	log.Println("build:", BuildAt, "starting")
+
+	var (
+		d  *deps
+		tc *tls.Config
 	)
 
-	log.Println("serving on:", appAddr)
+	if tc, err = serverTLS(cfg, app.Logger()); err != nil {
+		return
+	}
+
+	if d, err = connect(app, cfg); err != nil {
+		return
+	}
+
+	s := newService(cfg.Addr, cfg.RootKey, d.db, d.rd).
+		withChecks(d.checks...).
+		withLogger(app.Logger()).
+		withKeylessOrg(d.keylessOrg)
+
+	if d.fb != nil {
+		s.withFallback(d.fb, splitList(cfg.Defaults))
+		metrics.WatchFallback(d.fb.Degraded, d.fb.TakenAt)
+	}
+
+	if tc != nil {
+		s.withTLS(tc)
+	}
+
+	s.withAdmin(cfg.AdminAddr)
+
+	s.withReload(app.Reload)
+
+	app.OnReload(func() {
+		d.rd.SetExpiration(cfg.Expire)
+		s.setDefaults(splitList(cfg.Defaults))
+	})
+
+	log.Println("serving on:", cfg.Addr, "admin on:", cfg.AdminAddr)
 
 	return s.Serve()
 }
 
+func splitList(s string) (rv []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			rv = append(rv, v)
+		}
+	}
+
+	return rv
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		ok, err := runCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if ok {
+			return
+		}
+	}
+
+	var cfg config
+
 	app := app.New(appName).
 		WithGitInfo(GitHash).
 		WithEnvPrefix(envKeysPrefix).
-		WithEnvKeys(envDBKey, envRedisKey, envExpiration, envAddr)
+		WithConfig(&cfg, &cfg.Breaker)
+
+	flag.Parse()
 
 	if err := app.Init(); err != nil {
 		log.Fatal(err)
@@ -87,7 +305,7 @@ func main() {
 
 	defer app.Close()
 
-	if err := run(app); err != nil {
+	if err := run(app, &cfg); err != nil {
 		log.Println("app error:", err)
 	}
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/s0rg/toggle-svc/pkg/api"
+	"github.com/s0rg/toggle-svc/pkg/app"
+	appDB "github.com/s0rg/toggle-svc/pkg/app/db"
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+const (
+	cmdExport = "export"
+	cmdImport = "import"
+	cmdOrgKey = "org-key"
+	envAPIKey = "API_KEY"
+)
+
+var (
+	errNoOrgName = errors.New("organization name required")
+	errNoOrg     = errors.New("organization not found")
+)
+
+func exportManifest(ctx context.Context, dbs db.Store, orgID int64) (m *manifest.Manifest, err error) {
+	var (
+		apps     []string
+		segments []toggle.Segment
+		toggles  []toggle.Toggle
+		appID    int64
+	)
+
+	if apps, err = dbs.GetApps(ctx, orgID); err != nil {
+		return
+	}
+
+	keys := make(map[string][]toggle.KeyInfo, len(apps))
+
+	for _, name := range apps {
+		if appID, err = dbs.GetAppID(ctx, orgID, name); err != nil {
+			return
+		}
+
+		if keys[name], err = dbs.GetAppKeys(ctx, orgID, appID, toggle.KeyFilter{}); err != nil {
+			return
+		}
+	}
+
+	if segments, err = dbs.GetSegments(ctx, orgID); err != nil {
+		return
+	}
+
+	if toggles, err = dbs.GetToggles(ctx, orgID); err != nil {
+		return
+	}
+
+	return manifest.Build(apps, keys, segments, toggles), nil
+}
+
+func importManifest(
+	ctx context.Context,
+	dbs db.Store,
+	orgID int64,
+	want *manifest.Manifest,
+	dryRun bool,
+) (changes []manifest.Change, err error) {
+	var have *manifest.Manifest
+
+	if err = want.Validate(); err != nil {
+		return
+	}
+
+	if have, err = exportManifest(ctx, dbs, orgID); err != nil {
+		return
+	}
+
+	changes = manifest.Diff(have, want)
+
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+
+	if err = dbs.ApplyChanges(ctx, orgID, changes); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// Export returns toggles configuration of organization.
+func (s *service) Export(ctx context.Context, orgID int64) (*manifest.Manifest, error) {
+	return exportManifest(ctx, s.db, orgID)
+}
+
+// Import reconciles toggles configuration of organization to given manifest,
+// returns applied (or, in dry-run mode - pending) changes.
+func (s *service) Import(
+	ctx context.Context,
+	orgID int64,
+	m *manifest.Manifest,
+	dryRun bool,
+) ([]manifest.Change, error) {
+	return importManifest(ctx, s.db, orgID, m, dryRun)
+}
+
+// runCommand runs export/import/org-key sub-command, it returns false if cmd is unknown.
+func runCommand(cmd string, args []string) (ok bool, err error) {
+	if cmd != cmdExport && cmd != cmdImport && cmd != cmdOrgKey {
+		return false, nil
+	}
+
+	var (
+		fs     = flag.NewFlagSet(cmd, flag.ExitOnError)
+		format = fs.String("format", manifest.FormatYAML, "manifest format: yaml or json")
+		dryRun = fs.Bool("dry-run", false, "import: only print changes")
+		out    = fs.String("out", "-", "export: output file")
+	)
+
+	if err = fs.Parse(args); err != nil {
+		return true, err
+	}
+
+	envKeys := []string{envDBKey, envAPIKey}
+	if cmd == cmdOrgKey {
+		envKeys = envKeys[:1]
+	}
+
+	a := app.New(appName).
+		WithGitInfo(GitHash).
+		WithEnvPrefix(envKeysPrefix).
+		WithEnvKeys(envKeys...)
+
+	if err = a.Init(); err != nil {
+		return true, err
+	}
+
+	defer a.Close()
+
+	dbConn, err := appDB.ForApp(a, envDBKey)
+	if err != nil {
+		return true, err
+	}
+
+	ctx := context.Background()
+
+	if cmd == cmdOrgKey {
+		return true, runOrgKey(ctx, dbConn, appDB.NotifierForApp(a, envDBKey, dbConn), fs.Arg(0))
+	}
+
+	var (
+		// import goes through cache, so running replicas are notified about changes.
+		dbs   = db.NewCache(db.New(dbConn), appDB.NotifierForApp(a, envDBKey, dbConn))
+		orgID int64
+	)
+
+	if orgID, err = dbs.GetOrgID(ctx, a.GetEnv(envAPIKey)); err != nil {
+		return true, err
+	}
+
+	if cmd == cmdExport {
+		return true, runExport(ctx, dbs, orgID, *out, *format)
+	}
+
+	return true, runImport(ctx, dbs, orgID, fs.Arg(0), *format, *dryRun)
+}
+
+func runExport(ctx context.Context, dbs db.Store, orgID int64, out, format string) (err error) {
+	var m *manifest.Manifest
+
+	if m, err = exportManifest(ctx, dbs, orgID); err != nil {
+		return
+	}
+
+	var w io.Writer = os.Stdout
+
+	if out != "-" {
+		var fd *os.File
+
+		if fd, err = os.Create(out); err != nil {
+			return
+		}
+
+		defer fd.Close()
+
+		w = fd
+	}
+
+	return m.Encode(w, format)
+}
+
+func runImport(ctx context.Context, dbs db.Store, orgID int64, in, format string, dryRun bool) (err error) {
+	var r io.Reader = os.Stdin
+
+	if in != "" && in != "-" {
+		var fd *os.File
+
+		if fd, err = os.Open(in); err != nil {
+			return
+		}
+
+		defer fd.Close()
+
+		r = fd
+	}
+
+	var (
+		m       *manifest.Manifest
+		changes []manifest.Change
+	)
+
+	if m, err = manifest.Decode(r, format); err != nil {
+		return
+	}
+
+	if changes, err = importManifest(ctx, dbs, orgID, m, dryRun); err != nil {
+		return
+	}
+
+	return manifest.EncodeChanges(os.Stdout, changes, format)
+}
+
+// runOrgKey sets new api key of organization and prints it, i.e. for "default" one,
+// that holds apps of databases, created before organizations were added. Running replicas
+// are notified, as they cache organizations by their keys.
+func runOrgKey(ctx context.Context, conn *sql.DB, notify db.Notifier, name string) (err error) {
+	var (
+		key   string
+		orgID int64
+	)
+
+	if name == "" {
+		return errNoOrgName
+	}
+
+	if key, err = api.NewAPIKey(); err != nil {
+		return
+	}
+
+	if err = db.SetOrgKey(ctx, conn, name, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("%w: %s", errNoOrg, name)
+		}
+
+		return
+	}
+
+	// key is already set, so it is printed even if replicas are not notified.
+	if _, err = fmt.Fprintln(os.Stdout, key); err != nil || notify == nil {
+		return
+	}
+
+	if orgID, err = db.New(conn).GetOrgID(ctx, key); err != nil {
+		return
+	}
+
+	return notify(ctx, orgID)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/metrics"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+const (
+	metricsPeriod  = time.Minute
+	metricsTimeout = time.Minute
+)
+
+// collectMetrics exports expiry index stats and rollouts of all toggles, from redis counters.
+func (s *service) collectMetrics() (err error) {
+	var (
+		tracked, overdue int64
+		orgs             []int64
+		toggles          []toggle.Toggle
+	)
+
+	ctx, cancel := s.workContext(metricsTimeout)
+	defer cancel()
+
+	if tracked, overdue, err = s.rd.ExpiryStats(ctx, time.Now()); err != nil {
+		return
+	}
+
+	metrics.SetExpiry(tracked, overdue)
+
+	if orgs, err = s.db.GetOrgs(ctx); err != nil {
+		return
+	}
+
+	var (
+		states   int64
+		rollouts []metrics.Rollout
+	)
+
+	for _, orgID := range orgs {
+		if toggles, err = s.db.GetToggles(ctx, orgID); err != nil {
+			return
+		}
+
+		segments := make(map[toggle.Segment][]*toggle.Toggle)
+
+		for i := 0; i < len(toggles); i++ {
+			t := &toggles[i]
+			seg := t.Segment(orgID)
+			segments[seg] = append(segments[seg], t)
+		}
+
+		for seg, ts := range segments {
+			var (
+				total  int64
+				counts []int64
+				ids    = make([]int64, len(ts))
+			)
+
+			for i, t := range ts {
+				ids[i] = t.ID
+			}
+
+			if total, err = s.rd.ClientsCount(ctx, seg); err != nil {
+				return
+			}
+
+			if counts, err = s.rd.TogglesCount(ctx, seg, ids); err != nil {
+				return
+			}
+
+			states += total
+
+			for i, t := range ts {
+				rollouts = append(rollouts, metrics.Rollout{Segment: seg, Key: t.Key, Enabled: counts[i], Total: total})
+			}
+		}
+	}
+
+	metrics.SetRollouts(states, rollouts)
+
+	return nil
+}
+
+func (s *service) metricsCollector() {
+	t := time.NewTicker(metricsPeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := s.collectMetrics(); err != nil {
+				log.Println("metrics: collect error:", err)
+			}
+		case <-s.qch:
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/redis"
+)
+
+const (
+	reconcilePeriod  = 6 * time.Hour
+	reconcileTimeout = time.Hour
+)
+
+// Reconcile recomputes redis counters from client states, reporting (and, if fix is set - repairing) discrepancies.
+func (s *service) Reconcile(ctx context.Context, fix bool) (*redis.Report, error) {
+	return s.rd.Reconcile(ctx, fix)
+}
+
+func (s *service) reconciler() {
+	t := time.NewTicker(reconcilePeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			ctx, cancel := s.workContext(reconcileTimeout)
+			rep, err := s.rd.Reconcile(ctx, true)
+
+			cancel()
+
+			if err != nil {
+				log.Println("reconcile: error:", err)
+
+				continue
+			}
+
+			if len(rep.Counters) > 0 || rep.Untracked > 0 {
+				log.Println("reconcile: states:", rep.States, "untracked:", rep.Untracked, "counters:", len(rep.Counters))
+			}
+		case <-s.qch:
+			return
+		}
+	}
+}
@@ -2,86 +2,159 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/rs/zerolog"
+
 	"github.com/s0rg/toggle-svc/pkg/api"
+	"github.com/s0rg/toggle-svc/pkg/app"
 	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/health"
+	"github.com/s0rg/toggle-svc/pkg/metrics"
 	"github.com/s0rg/toggle-svc/pkg/redis"
 	"github.com/s0rg/toggle-svc/pkg/toggle"
 )
 
 const (
-	waiterBufLen = 128
-	waiterPeriod = time.Minute
-	waiterWait   = time.Second / 2
+	reaperPeriod    = time.Minute
+	reaperBatch     = 128
+	drainDelay      = 5 * time.Second
+	shutdownTimeout = 20 * time.Second
+	writeTimeout    = 5 * time.Second
+	// profiles and traces are written for 30 seconds by default.
+	adminWriteTimeout = time.Minute
 )
 
 var (
-	errWaiterOverflow = errors.New("waiter overflow")
 	errClientNotAlive = errors.New("not alive")
+	errNoReload       = errors.New("reload is not supported")
+	errBadPage        = errors.New("bad offset or limit")
 )
 
+type fallback interface {
+	Refresh(context.Context) error
+	Changes() <-chan struct{}
+}
+
 type service struct {
-	addr string
-	db   db.Store
-	rd   redis.Store
-	wch  chan string
-	qch  chan struct{}
+	addr      string
+	adminAddr string
+	rootKey   string
+	db        db.Store
+	rd        redis.Store
+	fb        fallback
+	defaults  atomic.Value
+	reload    func() ([]app.Change, error)
+	checks    []health.Check
+	logger    zerolog.Logger
+	keyless   int64
+	tls       *tls.Config
+	spawned   int32
+	running   int32
+	qch       chan struct{}
+	wg        sync.WaitGroup
 }
 
-func newService(addr string, dbs db.Store, rds redis.Store) *service {
+func newService(addr, rootKey string, dbs db.Store, rds redis.Store) *service {
 	return &service{
-		addr: addr,
-		db:   dbs,
-		rd:   rds,
-		wch:  make(chan string, waiterBufLen),
-		qch:  make(chan struct{}),
+		addr:    addr,
+		rootKey: rootKey,
+		db:      dbs,
+		rd:      rds,
+		qch:     make(chan struct{}),
 	}
 }
 
-func (s *service) dropIfDead(k string) (ok bool, err error) {
-	if ok, err = s.rd.IsAlive(k); err != nil {
-		return
+// withChecks adds dependencies checks for health probes.
+func (s *service) withChecks(checks ...health.Check) *service {
+	s.checks = append(s.checks, checks...)
+
+	return s
+}
+
+// withLogger sets logger for api requests.
+func (s *service) withLogger(l zerolog.Logger) *service {
+	s.logger = l
+
+	return s
+}
+
+// withKeylessOrg sets organization, that serves client api requests without api key, zero disables them.
+func (s *service) withKeylessOrg(id int64) *service {
+	s.keyless = id
+
+	return s
+}
+
+// withTLS enables TLS, api requires client certificates for admin and mutating endpoints,
+// if tc verifies them.
+func (s *service) withTLS(tc *tls.Config) *service {
+	s.tls = tc
+
+	return s
+}
+
+// withReload sets config reload function.
+func (s *service) withReload(fn func() ([]app.Change, error)) *service {
+	s.reload = fn
+
+	return s
+}
+
+// Reload reloads runtime configuration.
+func (s *service) Reload(_ context.Context) ([]app.Change, error) {
+	if s.reload == nil {
+		return nil, errNoReload
 	}
 
-	if ok {
-		return
+	return s.reload()
+}
+
+// reap drops all expired client states, in batches.
+func (s *service) reap() (err error) {
+	var claimed, dropped, total int
+
+	ctx, cancel := s.workContext(reaperPeriod)
+	defer cancel()
+
+	for {
+		if claimed, dropped, err = s.rd.ReapExpired(ctx, time.Now(), reaperBatch); err != nil {
+			return
+		}
+
+		total += dropped
+
+		if claimed < reaperBatch {
+			break
+		}
 	}
 
-	err = s.rd.DropState(k)
+	if total > 0 {
+		metrics.AddReaped(total)
+		log.Println("reaper: dropped:", total)
+	}
 
-	return ok, err
+	return nil
 }
 
-func (s *service) watcher() {
-	state := make(map[string]struct{})
-
-	t := time.NewTicker(waiterPeriod)
+func (s *service) reaper() {
+	t := time.NewTicker(reaperPeriod)
 	defer t.Stop()
 
-	defer close(s.wch)
-
 	for {
 		select {
-		case key := <-s.wch:
-			state[key] = struct{}{}
 		case <-t.C:
-			for k := range state {
-				exists, err := s.dropIfDead(k)
-
-				switch {
-				case err != nil:
-					log.Println("waiter: drop error:", err)
-
-					fallthrough
-				case exists:
-					continue
-				default:
-					delete(state, k)
-				}
+			if err := s.reap(); err != nil {
+				log.Println("reaper: error:", err)
 			}
 		case <-s.qch:
 			return
@@ -89,84 +162,164 @@ func (s *service) watcher() {
 	}
 }
 
-func (s *service) watchKey(k string) (err error) {
-	t := time.NewTimer(waiterWait)
-	defer t.Stop()
+// Serve serves client api (and management api on admin address) until SIGINT or SIGTERM (SIGHUP reloads config),
+// then it fails readiness probe for drainDelay, stops accepting new connections, waits (up to shutdownTimeout) for
+// in-flight requests and stops background workers.
+func (s *service) Serve() (err error) {
+	h := api.New(s, s.db, s.rootKey, s.logger, s.tls != nil && s.tls.ClientCAs != nil, s.keyless)
+	hc := health.New(append(s.checks, s.workersCheck())...)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", h.Mux())
+	mux.Handle("/healthz", hc.LiveHandler())
+	mux.Handle("/readyz", hc.ReadyHandler())
 
-	select {
-	case s.wch <- k:
-	case <-t.C:
-		err = errWaiterOverflow
+	servers := []*http.Server{
+		s.server(s.addr, mux, writeTimeout),
+		s.server(s.adminAddr, s.adminMux(h, hc), adminWriteTimeout),
 	}
 
-	return err
-}
+	s.spawn(s.reaper, s.staleWatcher, s.reconciler, s.metricsCollector)
 
-func (s *service) Serve() (err error) {
-	h := api.New(s, s.db)
+	if s.fb != nil {
+		s.spawn(s.snapshotter)
+	}
 
-	srv := &http.Server{
-		Addr:           s.addr,
-		Handler:        h.Mux(),
-		ReadTimeout:    5 * time.Second,
-		WriteTimeout:   5 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	defer signal.Stop(sig)
+
+	errc := make(chan error, len(servers))
+
+	for _, srv := range servers {
+		go func(srv *http.Server) { errc <- s.listen(srv) }(srv)
 	}
 
-	go s.watcher()
+	var v os.Signal
 
-	err = srv.ListenAndServe()
+	if v, err = s.waitShutdown(errc, sig); err == nil {
+		log.Println("shutdown: signal:", v)
+
+		hc.Drain()
+		time.Sleep(drainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		for _, srv := range servers {
+			if serr := srv.Shutdown(ctx); serr != nil && err == nil {
+				err = serr
+			}
+		}
+	} else {
+		for _, srv := range servers {
+			_ = srv.Close()
+		}
+	}
 
 	close(s.qch)
-	<-s.wch
+	s.wg.Wait()
+
+	log.Println("shutdown: done")
 
 	return err
 }
 
-func (s *service) loadState(key string, keys toggle.Keys) (found bool, err error) {
-	var keyIDs []int64
+func (s *service) server(addr string, h http.Handler, wt time.Duration) *http.Server {
+	return &http.Server{
+		Addr:           addr,
+		Handler:        h,
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   wt,
+		MaxHeaderBytes: 1 << 20,
+		TLSConfig:      s.tls,
+	}
+}
 
-	if keyIDs, found, err = s.rd.GetState(key); err != nil || !found {
-		return
+func (s *service) listen(srv *http.Server) error {
+	if s.tls != nil {
+		// certificates are provided by TLSConfig.
+		return srv.ListenAndServeTLS("", "")
 	}
 
-	if err = s.rd.MarkAlive(key); err != nil {
-		return
+	return srv.ListenAndServe()
+}
+
+// waitShutdown waits for server error or shutdown signal, config is reloaded on SIGHUP.
+func (s *service) waitShutdown(errc <-chan error, sig <-chan os.Signal) (os.Signal, error) {
+	for {
+		select {
+		case err := <-errc:
+			return nil, err
+		case v := <-sig:
+			if v != syscall.SIGHUP {
+				return v, nil
+			}
+
+			if _, err := s.Reload(context.Background()); err != nil {
+				log.Println("reload: error:", err)
+			}
+		}
 	}
+}
 
-	keys.EnableByID(keyIDs)
+// workContext returns context for background work, it is canceled upon shutdown.
+func (s *service) workContext(d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
 
-	return
+	go func() {
+		select {
+		case <-s.qch:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
 }
 
-func (s *service) makeState(app, version, platform string, keys toggle.Keys) (key string, err error) {
-	var (
-		total  int64
-		counts []int64
-	)
+// spawn runs background workers, they must return after s.qch is closed.
+func (s *service) spawn(workers ...func()) {
+	for _, w := range workers {
+		s.wg.Add(1)
 
-	if counts, err = s.rd.TogglesGet(app, version, platform, keys); err != nil {
-		return
+		s.spawned++
+		atomic.AddInt32(&s.running, 1)
+
+		go func(w func()) {
+			defer s.wg.Done()
+			defer atomic.AddInt32(&s.running, -1)
+
+			w()
+		}(w)
 	}
+}
+
+func (s *service) loadState(ctx context.Context, org int64, key string, keys toggle.Keys) (found bool, err error) {
+	var keyIDs []int64
 
-	if total, err = s.rd.ClientsInc(app, version, platform); err != nil {
+	if keyIDs, found, err = s.rd.GetState(ctx, org, key); err != nil || !found {
 		return
 	}
 
-	keys.DisableByRate(total, counts)
-
-	if key, err = s.rd.TogglesIncr(app, version, platform, keys); err != nil {
+	if err = s.rd.MarkAlive(ctx, org, key); err != nil {
 		return
 	}
 
-	err = s.watchKey(key)
+	keys.EnableByID(keyIDs)
 
-	return key, err
+	return
+}
+
+func (s *service) makeState(ctx context.Context, seg toggle.Segment, keys toggle.Keys) (key string, err error) {
+	return s.rd.TogglesAssign(ctx, seg, keys)
 }
 
 func (s *service) CodeToggles(
 	ctx context.Context,
-	app, version, platform, toggleID string,
+	seg toggle.Segment,
+	toggleID string,
 ) (
 	clientID string,
 	keys toggle.Keys,
@@ -177,33 +330,35 @@ func (s *service) CodeToggles(
 		found bool
 	)
 
-	if appID, err = s.db.GetAppID(ctx, app); err != nil {
+	if appID, err = s.db.GetAppID(ctx, seg.Org, seg.App); err != nil {
 		return
 	}
 
-	if keys, err = s.db.GetAppFeatures(ctx, appID, version, platform); err != nil {
+	if keys, err = s.db.GetAppFeatures(ctx, seg.Org, appID, seg.Env, seg.Version, seg.Platform); err != nil {
 		return
 	}
 
 	if toggleID != "" {
-		if found, err = s.loadState(toggleID, keys); err != nil {
-			return
+		if found, err = s.loadState(ctx, seg.Org, toggleID, keys); err != nil {
+			return s.fallbackToggles(ctx, toggleID, keys, err)
 		}
 	}
 
 	clientID = toggleID
 
 	if !found {
-		clientID, err = s.makeState(app, version, platform, keys)
+		if clientID, err = s.makeState(ctx, seg, keys); err != nil {
+			return s.fallbackToggles(ctx, toggleID, keys, err)
+		}
 	}
 
-	return clientID, keys, err
+	return clientID, keys, nil
 }
 
-func (s *service) MarkAlive(_ context.Context, clientID string) (err error) {
+func (s *service) MarkAlive(ctx context.Context, orgID int64, clientID string) (err error) {
 	var alive bool
 
-	if alive, err = s.rd.IsAlive(clientID); err != nil {
+	if alive, err = s.rd.IsAlive(ctx, orgID, clientID); err != nil {
 		return
 	}
 
@@ -211,5 +366,5 @@ func (s *service) MarkAlive(_ context.Context, clientID string) (err error) {
 		return errClientNotAlive
 	}
 
-	return s.rd.MarkAlive(clientID)
+	return s.rd.MarkAlive(ctx, orgID, clientID)
 }
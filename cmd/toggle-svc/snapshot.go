@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+const (
+	snapshotPeriod  = time.Minute
+	snapshotTimeout = time.Minute
+)
+
+// withFallback enables degraded mode: clients are served from db snapshot, when database
+// fails, and get default toggles (named ones or, if there are none, all of them), when redis does.
+func (s *service) withFallback(fb fallback, defaults []string) *service {
+	s.fb = fb
+	s.setDefaults(defaults)
+
+	return s
+}
+
+// setDefaults replaces default toggles, it is safe to call it while serving.
+func (s *service) setDefaults(defaults []string) {
+	m := make(map[string]struct{}, len(defaults))
+
+	for _, k := range defaults {
+		m[k] = struct{}{}
+	}
+
+	s.defaults.Store(m)
+}
+
+// fallbackToggles enables default toggles, when client state can not be loaded or saved, without
+// degraded mode - it returns `err` as-is.
+func (s *service) fallbackToggles(
+	ctx context.Context,
+	clientID string,
+	keys toggle.Keys,
+	err error,
+) (string, toggle.Keys, error) {
+	if s.fb == nil {
+		return clientID, keys, err
+	}
+
+	zerolog.Ctx(ctx).Warn().Err(err).Msg("redis: error, serving default toggles")
+
+	defaults, _ := s.defaults.Load().(map[string]struct{})
+
+	for i := 0; i < len(keys); i++ {
+		k := &keys[i]
+
+		k.Rate = 1
+
+		if _, ok := defaults[k.Name]; len(defaults) > 0 && !ok {
+			k.Rate = 0
+		}
+	}
+
+	return clientID, keys, nil
+}
+
+func (s *service) refreshSnapshot() {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	if err := s.fb.Refresh(ctx); err != nil {
+		log.Println("snapshot: refresh error:", err)
+	}
+}
+
+// snapshotter refreshes db snapshot periodically and after changes.
+func (s *service) snapshotter() {
+	t := time.NewTicker(snapshotPeriod)
+	defer t.Stop()
+
+	s.refreshSnapshot()
+
+	for {
+		select {
+		case <-t.C:
+			s.refreshSnapshot()
+		case <-s.fb.Changes():
+			s.refreshSnapshot()
+		case <-s.qch:
+			// flush changes, made by drained requests.
+			select {
+			case <-s.fb.Changes():
+				s.refreshSnapshot()
+			default:
+			}
+
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+const (
+	staleMaxAge  = 30 * 24 * time.Hour
+	stalePeriod  = time.Hour
+	staleTimeout = time.Minute
+)
+
+// StaleToggles returns report on stale toggles of organization, toggle is untouched
+// if it was not updated for maxAge (if zero - default will be used).
+func (s *service) StaleToggles(
+	ctx context.Context,
+	orgID int64,
+	maxAge time.Duration,
+) (rv []toggle.Stale, err error) {
+	var toggles []toggle.Toggle
+
+	if maxAge <= 0 {
+		maxAge = staleMaxAge
+	}
+
+	if toggles, err = s.db.GetToggles(ctx, orgID); err != nil {
+		return
+	}
+
+	var (
+		now     = time.Now()
+		clients = make(map[toggle.Segment]int64)
+	)
+
+	for i := 0; i < len(toggles); i++ {
+		t := &toggles[i]
+		seg := t.Segment(orgID)
+
+		count, ok := clients[seg]
+		if !ok {
+			if count, err = s.rd.ClientsCount(ctx, seg); err != nil {
+				return
+			}
+
+			clients[seg] = count
+		}
+
+		if reasons := t.StaleReasons(now, maxAge, count); len(reasons) > 0 {
+			rv = append(rv, toggle.Stale{Toggle: *t, Reasons: reasons})
+		}
+	}
+
+	return rv, nil
+}
+
+// ArchiveToggles removes given toggles of organization, only if they are still
+// stale and archivable (see toggle.Stale.Archivable), returns archived ids.
+func (s *service) ArchiveToggles(
+	ctx context.Context,
+	orgID int64,
+	maxAge time.Duration,
+	ids []int64,
+) (rv []int64, err error) {
+	var stale []toggle.Stale
+
+	if stale, err = s.StaleToggles(ctx, orgID, maxAge); err != nil {
+		return
+	}
+
+	want := make(map[int64]struct{}, len(ids))
+
+	for _, id := range ids {
+		want[id] = struct{}{}
+	}
+
+	for i := 0; i < len(stale); i++ {
+		st := &stale[i]
+
+		if _, ok := want[st.ID]; ok && st.Archivable() {
+			rv = append(rv, st.ID)
+		}
+	}
+
+	if len(rv) == 0 {
+		return
+	}
+
+	if _, err = s.db.ArchiveToggles(ctx, orgID, rv); err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+func (s *service) markStale() (err error) {
+	var (
+		orgs  []int64
+		stale []toggle.Stale
+	)
+
+	ctx, cancel := s.workContext(staleTimeout)
+	defer cancel()
+
+	if orgs, err = s.db.GetOrgs(ctx); err != nil {
+		return
+	}
+
+	for _, orgID := range orgs {
+		if stale, err = s.StaleToggles(ctx, orgID, 0); err != nil {
+			return
+		}
+
+		ids := make([]int64, len(stale))
+
+		for i := 0; i < len(stale); i++ {
+			ids[i] = stale[i].ID
+		}
+
+		if err = s.db.MarkStale(ctx, orgID, ids); err != nil {
+			return
+		}
+
+		if len(ids) > 0 {
+			log.Println("stale: org:", orgID, "toggles:", len(ids))
+		}
+	}
+
+	return nil
+}
+
+func (s *service) staleWatcher() {
+	t := time.NewTicker(stalePeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := s.markStale(); err != nil {
+				log.Println("stale: mark error:", err)
+			}
+		case <-s.qch:
+			return
+		}
+	}
+}
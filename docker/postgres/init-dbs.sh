@@ -7,5 +7,4 @@ for cred in $INIT_DBS; do
 
     psql -c "CREATE ROLE $name WITH LOGIN PASSWORD '$pass';"
     psql -c "CREATE DATABASE $dbname WITH OWNER $name;"
-    psql -U "$name" -d "$dbname" < "/usr/local/share/db-init/${name}.sql"
 done
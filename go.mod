@@ -3,9 +3,17 @@ module github.com/s0rg/toggle-svc
 go 1.15
 
 require (
-	github.com/fxamacker/cbor/v2 v2.2.0
+	github.com/alicebob/miniredis/v2 v2.16.0
 	github.com/google/uuid v1.1.2
 	github.com/lib/pq v1.8.0
 	github.com/mediocregopher/radix/v3 v3.5.2
+	github.com/prometheus/client_golang v1.11.1
 	github.com/rs/zerolog v1.20.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.10.6
 )
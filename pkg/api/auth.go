@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	headerAPIKey = "X-API-Key"
+	apiKeyLen    = 32
+)
+
+var (
+	errUnauthorized = errors.New("unauthorized")
+	errNoClientCert = fmt.Errorf("%w: client certificate required", errUnauthorized)
+)
+
+type ctxOrgKey struct{}
+
+func withOrgID(ctx context.Context, orgID int64) context.Context {
+	return context.WithValue(ctx, ctxOrgKey{}, orgID)
+}
+
+func orgID(ctx context.Context) int64 {
+	id, _ := ctx.Value(ctxOrgKey{}).(int64)
+
+	return id
+}
+
+// withOrg resolves organization by api key from request headers.
+func (h *handlers) withOrg(next handler) handler {
+	return func(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+		var id int64
+
+		key := r.Header.Get(headerAPIKey)
+		if key == "" {
+			return errUnauthorized
+		}
+
+		if id, err = h.db.GetOrgID(ctx, key); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = errUnauthorized
+			}
+
+			return
+		}
+
+		zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+			return c.Int64("org", id)
+		})
+
+		return next(withOrgID(ctx, id), w, r)
+	}
+}
+
+// withClientOrg is a withOrg for client api, requests without api key are served by keyless
+// organization, if it is set.
+func (h *handlers) withClientOrg(next handler) handler {
+	withOrg := h.withOrg(next)
+
+	if h.keylessOrg == 0 {
+		return withOrg
+	}
+
+	return func(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+		if r.Header.Get(headerAPIKey) != "" {
+			return withOrg(ctx, w, r)
+		}
+
+		zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+			return c.Int64("org", h.keylessOrg).Bool("keyless", true)
+		})
+
+		return next(withOrgID(ctx, h.keylessOrg), w, r)
+	}
+}
+
+// withRoot allows only requests, that bear root api key.
+func (h *handlers) withRoot(next handler) handler {
+	return func(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+		key := r.Header.Get(headerAPIKey)
+
+		if h.rootKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(h.rootKey)) != 1 {
+			return errUnauthorized
+		}
+
+		return next(ctx, w, r)
+	}
+}
+
+// withCert allows only requests with verified client certificate, if mutual TLS is enabled.
+func (h *handlers) withCert(next handler) handler {
+	if !h.mtls {
+		return next
+	}
+
+	return func(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			return errNoClientCert
+		}
+
+		return next(ctx, w, r)
+	}
+}
+
+// NewAPIKey generates random organization api key.
+func NewAPIKey() (key string, err error) {
+	b := make([]byte, apiKeyLen)
+
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
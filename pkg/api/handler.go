@@ -2,64 +2,125 @@ package api
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/rs/zerolog"
+
+	"github.com/s0rg/toggle-svc/pkg/app"
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+	"github.com/s0rg/toggle-svc/pkg/redis"
 	"github.com/s0rg/toggle-svc/pkg/toggle"
 )
 
 var errBadRequest = errors.New("bad request")
 
 const (
-	headerToggleID = "X-CodeToggleID"
+	headerToggleID  = "X-CodeToggleID"
+	defaultEnv      = "production"
+	contentTypeYAML = "application/yaml"
 )
 
 type Muxer interface {
 	Mux() http.Handler
+	AdminMux() http.Handler
 }
 
 type service interface {
-	CodeToggles(ctx context.Context, app, version, platform, clientID string) (string, toggle.Keys, error)
-	MarkAlive(ctx context.Context, clientID string) error
+	CodeToggles(ctx context.Context, seg toggle.Segment, clientID string) (string, toggle.Keys, error)
+	MarkAlive(ctx context.Context, orgID int64, clientID string) error
+	StaleToggles(ctx context.Context, orgID int64, maxAge time.Duration) ([]toggle.Stale, error)
+	ArchiveToggles(ctx context.Context, orgID int64, maxAge time.Duration, ids []int64) ([]int64, error)
+	Export(ctx context.Context, orgID int64) (*manifest.Manifest, error)
+	Import(ctx context.Context, orgID int64, m *manifest.Manifest, dryRun bool) ([]manifest.Change, error)
+	Reconcile(ctx context.Context, fix bool) (*redis.Report, error)
+	Reload(ctx context.Context) ([]app.Change, error)
 }
 
 type store interface {
-	AddApps(context.Context, []string) error
-	GetApps(context.Context) ([]string, error)
-	GetAppID(context.Context, string) (int64, error)
-	AddAppFeatures(context.Context, int64, string, []string, toggle.Keys) error
-	EditAppFeature(context.Context, int64, string, string, string, float64) error
+	AddOrg(context.Context, string, string, int, int) error
+	GetOrgID(context.Context, string) (int64, error)
+	AddApps(context.Context, int64, []string) error
+	GetApps(context.Context, int64) ([]string, error)
+	GetAppID(context.Context, int64, string) (int64, error)
+	GetEnvs(context.Context) ([]string, error)
+	GetAppKeys(context.Context, int64, int64, toggle.KeyFilter) ([]toggle.KeyInfo, error)
+	EditAppKey(context.Context, int64, int64, string, *toggle.Meta) error
+	AddAppFeatures(context.Context, int64, int64, string, string, []string, toggle.Keys) error
+	EditAppFeature(context.Context, int64, int64, string, string, string, string, float64) error
+	PromoteAppFeatures(context.Context, int64, int64, string, string) error
 }
 
 type handlers struct {
-	srv service
-	db  store
+	srv        service
+	db         store
+	rootKey    string
+	log        zerolog.Logger
+	mtls       bool
+	keylessOrg int64
 }
 
-// New creates new api handlers.
+// New creates new api handlers, rootKey guards organizations management, requests are logged with l.
+// If mtls is set, admin and mutating endpoints also require verified client certificate. Non-zero
+// keylessOrg serves client api requests without api key, i.e. of clients, that were not updated yet.
 func New(
 	srv service,
 	db store,
+	rootKey string,
+	l zerolog.Logger,
+	mtls bool,
+	keylessOrg int64,
 ) Muxer {
-	return &handlers{srv: srv, db: db}
+	return &handlers{srv: srv, db: db, rootKey: rootKey, log: l, mtls: mtls, keylessOrg: keylessOrg}
 }
 
-// Mux constructs new http.Handler for api.
+// Mux constructs new http.Handler for client api.
 func (h *handlers) Mux() http.Handler {
 	var m http.ServeMux
 
-	m.HandleFunc("/client/code-toggles", wrapAPI("client-get-toggles", h.GetCodeToggles))
-	m.HandleFunc("/client/alive", wrapAPI("client-alive", h.Alive))
+	m.HandleFunc("/client/code-toggles", wrapAPI("client-get-toggles", h.withClientOrg(h.GetCodeToggles)))
+	m.HandleFunc("/client/alive", wrapAPI("client-alive", h.withClientOrg(h.Alive)))
+
+	return withRequestLog(h.log, &m)
+}
+
+// AdminMux constructs new http.Handler for management api.
+func (h *handlers) AdminMux() http.Handler {
+	var m http.ServeMux
+
+	m.HandleFunc("/orgs/add", wrapAPI("orgs-add", h.withCert(h.withRoot(h.AddOrg))))
+	m.HandleFunc("/admin/reconcile", wrapAPI("admin-reconcile", h.withCert(h.withRoot(h.Reconcile))))
+	m.HandleFunc("/admin/reload", wrapAPI("admin-reload", h.withCert(h.withRoot(h.Reload))))
 
-	m.HandleFunc("/apps", wrapAPI("apps-get", h.GetApps))
-	m.HandleFunc("/apps/add", wrapAPI("apps-add", h.AddApps))
+	m.HandleFunc("/apps", wrapAPI("apps-get", h.withOrg(h.GetApps)))
+	m.HandleFunc("/apps/add", wrapAPI("apps-add", h.withCert(h.withOrg(h.AddApps))))
 
-	m.HandleFunc("/toggles/add", wrapAPI("toggles-add", h.AddCodeToggles))
-	m.HandleFunc("/toggles/edit", wrapAPI("toggles-edit", h.EditCodeToggles))
+	m.HandleFunc("/envs", wrapAPI("envs-get", h.withOrg(h.GetEnvs)))
 
-	return &m
+	m.HandleFunc("/toggles/add", wrapAPI("toggles-add", h.withCert(h.withOrg(h.AddCodeToggles))))
+	m.HandleFunc("/toggles/edit", wrapAPI("toggles-edit", h.withCert(h.withOrg(h.EditCodeToggles))))
+	m.HandleFunc("/toggles/promote", wrapAPI("toggles-promote", h.withCert(h.withOrg(h.PromoteCodeToggles))))
+	m.HandleFunc("/toggles/keys", wrapAPI("toggles-keys", h.withOrg(h.GetKeys)))
+	m.HandleFunc("/toggles/meta", wrapAPI("toggles-meta", h.withCert(h.withOrg(h.EditKeyMeta))))
+	m.HandleFunc("/toggles/stale", wrapAPI("toggles-stale", h.withOrg(h.GetStale)))
+	m.HandleFunc("/toggles/archive", wrapAPI("toggles-archive", h.withCert(h.withOrg(h.ArchiveStale))))
+
+	m.HandleFunc("/config/export", wrapAPI("config-export", h.withOrg(h.ExportConfig)))
+	m.HandleFunc("/config/import", wrapAPI("config-import", h.withCert(h.withOrg(h.ImportConfig))))
+
+	return withRequestLog(h.log, &m)
+}
+
+// appID resolves app of request organization, app is added to access log.
+func (h *handlers) appID(ctx context.Context, name string) (int64, error) {
+	logStr(ctx, "app", name)
+
+	return h.db.GetAppID(ctx, orgID(ctx), name)
 }
 
 // GetCodeToggles returns enabled code toggles for client.
@@ -77,10 +138,22 @@ func (h *handlers) GetCodeToggles(ctx context.Context, w io.Writer, r *http.Requ
 
 	toggleID := r.Header.Get(headerToggleID)
 
-	if resp.ID, keys, err = h.srv.CodeToggles(ctx, req.App, req.Platform, req.Version, toggleID); err != nil {
+	logStr(ctx, "app", req.App)
+
+	seg := toggle.Segment{
+		Org:      orgID(ctx),
+		App:      req.App,
+		Env:      envOrDefault(req.Env),
+		Version:  req.Version,
+		Platform: req.Platform,
+	}
+
+	if resp.ID, keys, err = h.srv.CodeToggles(ctx, seg, toggleID); err != nil {
 		return
 	}
 
+	logStr(ctx, "client", resp.ID)
+
 	resp.Keys = keys.Names()
 
 	return json.NewEncoder(w).Encode(&resp)
@@ -96,7 +169,7 @@ func (h *handlers) AddCodeToggles(ctx context.Context, w io.Writer, r *http.Requ
 
 	var appID int64
 
-	if appID, err = h.db.GetAppID(ctx, req.App); err != nil {
+	if appID, err = h.appID(ctx, req.App); err != nil {
 		return
 	}
 
@@ -105,13 +178,27 @@ func (h *handlers) AddCodeToggles(ctx context.Context, w io.Writer, r *http.Requ
 	for i := 0; i < len(req.Keys); i++ {
 		k, rk := &keys[i], &req.Keys[i]
 
+		if rk.Stage != "" && !toggle.ValidStage(rk.Stage) {
+			return errBadRequest
+		}
+
 		k.Name = rk.Name
+		k.Meta = &toggle.Meta{
+			Description: rk.Description,
+			Owner:       rk.Owner,
+			Tags:        rk.Tags,
+			Link:        rk.Link,
+			Stage:       rk.Stage,
+			CreatedBy:   req.Author,
+			ExpiresAt:   rk.ExpiresAt,
+		}
+
 		if rk.Enabled {
 			k.Rate = 1.0
 		}
 	}
 
-	return h.db.AddAppFeatures(ctx, appID, req.Version, req.Platforms, keys)
+	return h.db.AddAppFeatures(ctx, orgID(ctx), appID, envOrDefault(req.Env), req.Version, req.Platforms, keys)
 }
 
 // AddApps adds new apps.
@@ -126,14 +213,14 @@ func (h *handlers) AddApps(ctx context.Context, w io.Writer, r *http.Request) (e
 		return errBadRequest
 	}
 
-	return h.db.AddApps(ctx, req.Apps)
+	return h.db.AddApps(ctx, orgID(ctx), req.Apps)
 }
 
 // GetApps returns slice of app names.
 func (h *handlers) GetApps(ctx context.Context, w io.Writer, _ *http.Request) (err error) {
 	var apps []string
 
-	if apps, err = h.db.GetApps(ctx); err != nil {
+	if apps, err = h.db.GetApps(ctx, orgID(ctx)); err != nil {
 		return
 	}
 
@@ -148,7 +235,9 @@ func (h *handlers) Alive(ctx context.Context, w io.Writer, r *http.Request) (err
 		return errBadRequest
 	}
 
-	return h.srv.MarkAlive(ctx, req.ID)
+	logStr(ctx, "client", req.ID)
+
+	return h.srv.MarkAlive(ctx, orgID(ctx), req.ID)
 }
 
 // EditCodeToggles allows to edit toggle rate for specified key.
@@ -162,9 +251,293 @@ func (h *handlers) EditCodeToggles(ctx context.Context, w io.Writer, r *http.Req
 		return errBadRequest
 	}
 
-	if appID, err = h.db.GetAppID(ctx, req.App); err != nil {
+	if appID, err = h.appID(ctx, req.App); err != nil {
+		return errBadRequest
+	}
+
+	return h.db.EditAppFeature(
+		ctx, orgID(ctx), appID, envOrDefault(req.Env), req.Version, req.Platform, req.Key, req.Rate,
+	)
+}
+
+// GetEnvs returns slice of environment names, in promotion order.
+func (h *handlers) GetEnvs(ctx context.Context, w io.Writer, _ *http.Request) (err error) {
+	var envs []string
+
+	if envs, err = h.db.GetEnvs(ctx); err != nil {
+		return
+	}
+
+	return json.NewEncoder(w).Encode(envs)
+}
+
+// PromoteCodeToggles copies app toggles from one environment to another,
+// if target is omitted - next environment in promotion order will be used.
+func (h *handlers) PromoteCodeToggles(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+	var (
+		appID int64
+		envs  []string
+		req   reqPromoteToggles
+	)
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errBadRequest
+	}
+
+	if appID, err = h.appID(ctx, req.App); err != nil {
+		return errBadRequest
+	}
+
+	if envs, err = h.db.GetEnvs(ctx); err != nil {
+		return
+	}
+
+	if req.To == "" {
+		req.To = nextEnv(envs, req.From)
+	}
+
+	if !hasEnv(envs, req.From) || !hasEnv(envs, req.To) || req.From == req.To {
 		return errBadRequest
 	}
 
-	return h.db.EditAppFeature(ctx, appID, req.Version, req.Platform, req.Key, req.Rate)
+	return h.db.PromoteAppFeatures(ctx, orgID(ctx), appID, req.From, req.To)
+}
+
+// AddOrg adds new organization and returns its api key.
+func (h *handlers) AddOrg(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+	var req reqAddOrg
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errBadRequest
+	}
+
+	if req.Name == "" || req.MaxApps < 0 || req.MaxKeys < 0 {
+		return errBadRequest
+	}
+
+	resp := respAddOrg{Name: req.Name}
+
+	if resp.Key, err = NewAPIKey(); err != nil {
+		return
+	}
+
+	if err = h.db.AddOrg(ctx, req.Name, resp.Key, req.MaxApps, req.MaxKeys); err != nil {
+		return
+	}
+
+	return json.NewEncoder(w).Encode(&resp)
+}
+
+// GetKeys returns app keys with their meta, optionally filtered by owner, tag or stage.
+func (h *handlers) GetKeys(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+	var (
+		appID int64
+		keys  []toggle.KeyInfo
+		req   reqGetKeys
+	)
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errBadRequest
+	}
+
+	if appID, err = h.appID(ctx, req.App); err != nil {
+		return errBadRequest
+	}
+
+	filter := toggle.KeyFilter{
+		Owner: req.Owner,
+		Tag:   strings.ToLower(req.Tag),
+		Stage: req.Stage,
+	}
+
+	if keys, err = h.db.GetAppKeys(ctx, orgID(ctx), appID, filter); err != nil {
+		return
+	}
+
+	return json.NewEncoder(w).Encode(keys)
+}
+
+// EditKeyMeta replaces meta of app key.
+func (h *handlers) EditKeyMeta(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+	var (
+		appID int64
+		req   reqEditKey
+	)
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errBadRequest
+	}
+
+	if req.Stage != "" && !toggle.ValidStage(req.Stage) {
+		return errBadRequest
+	}
+
+	if appID, err = h.appID(ctx, req.App); err != nil {
+		return errBadRequest
+	}
+
+	meta := toggle.Meta{
+		Description: req.Description,
+		Owner:       req.Owner,
+		Tags:        req.Tags,
+		Link:        req.Link,
+		Stage:       req.Stage,
+		ExpiresAt:   req.ExpiresAt,
+	}
+
+	if err = h.db.EditAppKey(ctx, orgID(ctx), appID, req.Key, &meta); errors.Is(err, sql.ErrNoRows) {
+		err = errBadRequest
+	}
+
+	return err
+}
+
+// GetStale returns report on stale toggles.
+func (h *handlers) GetStale(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+	var (
+		req   reqStale
+		stale []toggle.Stale
+	)
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil || req.Days < 0 {
+		return errBadRequest
+	}
+
+	if stale, err = h.srv.StaleToggles(ctx, orgID(ctx), days(req.Days)); err != nil {
+		return
+	}
+
+	return json.NewEncoder(w).Encode(stale)
+}
+
+// ArchiveStale archives given toggles, if they are (still) stale, returns archived ids.
+func (h *handlers) ArchiveStale(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+	var (
+		req  reqArchive
+		resp respArchive
+	)
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil || req.Days < 0 || len(req.IDs) == 0 {
+		return errBadRequest
+	}
+
+	if resp.IDs, err = h.srv.ArchiveToggles(ctx, orgID(ctx), days(req.Days), req.IDs); err != nil {
+		return
+	}
+
+	return json.NewEncoder(w).Encode(&resp)
+}
+
+// ExportConfig returns toggles configuration manifest, in format
+// from `format` query param (json by default).
+func (h *handlers) ExportConfig(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+	var m *manifest.Manifest
+
+	format := r.URL.Query().Get("format")
+
+	if m, err = h.srv.Export(ctx, orgID(ctx)); err != nil {
+		return
+	}
+
+	if format == manifest.FormatYAML {
+		setContentType(w, contentTypeYAML)
+	}
+
+	if err = m.Encode(w, format); err != nil {
+		return errBadRequest
+	}
+
+	return nil
+}
+
+// ImportConfig reconciles toggles configuration to manifest from request body, in format
+// from `format` query param (json by default), with `dry_run` query param set - changes
+// are only returned, not applied.
+func (h *handlers) ImportConfig(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+	var (
+		m       *manifest.Manifest
+		changes []manifest.Change
+		query   = r.URL.Query()
+	)
+
+	if m, err = manifest.Decode(r.Body, query.Get("format")); err != nil {
+		return errBadRequest
+	}
+
+	dryRun := query.Get("dry_run") != ""
+
+	if changes, err = h.srv.Import(ctx, orgID(ctx), m, dryRun); err != nil {
+		if errors.Is(err, manifest.ErrInvalid) {
+			err = errBadRequest
+		}
+
+		return
+	}
+
+	return json.NewEncoder(w).Encode(changes)
+}
+
+// Reconcile reports (and optionally repairs) redis counters discrepancies.
+func (h *handlers) Reconcile(ctx context.Context, w io.Writer, r *http.Request) (err error) {
+	var (
+		req reqReconcile
+		rep *redis.Report
+	)
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errBadRequest
+	}
+
+	if rep, err = h.srv.Reconcile(ctx, req.Repair); err != nil {
+		return
+	}
+
+	return json.NewEncoder(w).Encode(rep)
+}
+
+// Reload reloads runtime configuration, returns changed keys.
+func (h *handlers) Reload(ctx context.Context, w io.Writer, _ *http.Request) (err error) {
+	var changes []app.Change
+
+	if changes, err = h.srv.Reload(ctx); err != nil {
+		return
+	}
+
+	if changes == nil {
+		changes = []app.Change{}
+	}
+
+	return json.NewEncoder(w).Encode(changes)
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+func envOrDefault(env string) string {
+	if env == "" {
+		return defaultEnv
+	}
+
+	return env
+}
+
+func hasEnv(envs []string, env string) bool {
+	for _, e := range envs {
+		if e == env {
+			return true
+		}
+	}
+
+	return false
+}
+
+func nextEnv(envs []string, env string) string {
+	for i := 0; i < len(envs)-1; i++ {
+		if envs[i] == env {
+			return envs[i+1]
+		}
+	}
+
+	return ""
 }
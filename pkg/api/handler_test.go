@@ -0,0 +1,294 @@
+//nolint:testpackage
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/s0rg/toggle-svc/pkg/app"
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+	"github.com/s0rg/toggle-svc/pkg/redis"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+const testRootKey = "root-key"
+
+// stubService serves client api with toggles of store, all other calls do nothing.
+type stubService struct {
+	db db.Store
+}
+
+func (s *stubService) CodeToggles(ctx context.Context, seg toggle.Segment, _ string) (string, toggle.Keys, error) {
+	appID, err := s.db.GetAppID(ctx, seg.Org, seg.App)
+	if err != nil {
+		return "", nil, err
+	}
+
+	keys, err := s.db.GetAppFeatures(ctx, seg.Org, appID, seg.Env, seg.Version, seg.Platform)
+
+	return "client", keys, err
+}
+
+func (s *stubService) MarkAlive(context.Context, int64, string) error { return nil }
+
+func (s *stubService) StaleToggles(context.Context, int64, time.Duration) ([]toggle.Stale, error) {
+	return nil, nil
+}
+
+func (s *stubService) ArchiveToggles(context.Context, int64, time.Duration, []int64) ([]int64, error) {
+	return nil, nil
+}
+
+func (s *stubService) Export(context.Context, int64) (*manifest.Manifest, error) {
+	return &manifest.Manifest{}, nil
+}
+
+func (s *stubService) Import(context.Context, int64, *manifest.Manifest, bool) ([]manifest.Change, error) {
+	return nil, nil
+}
+
+func (s *stubService) Reconcile(context.Context, bool) (*redis.Report, error) {
+	return &redis.Report{}, nil
+}
+
+func (s *stubService) Reload(context.Context) ([]app.Change, error) { return nil, nil }
+
+type testAPI struct {
+	t     *testing.T
+	admin http.Handler
+	mux   http.Handler
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+
+	return newKeylessTestAPI(t, 0)
+}
+
+func newKeylessTestAPI(t *testing.T, keylessOrg int64) *testAPI {
+	t.Helper()
+
+	s := db.NewMemory()
+	h := New(&stubService{db: s}, s, testRootKey, zerolog.Nop(), false, keylessOrg)
+
+	return &testAPI{t: t, admin: h.AdminMux(), mux: h.Mux()}
+}
+
+// call posts body to admin (or, for /client/* paths - client) api, returns status code and
+// decodes response into rv, if it is not nil.
+func (a *testAPI) call(key, path, body string, rv interface{}) int {
+	a.t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(headerAPIKey, key)
+	}
+
+	h := a.admin
+	if strings.HasPrefix(path, "/client/") {
+		h = a.mux
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rv != nil && rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(rv); err != nil {
+			a.t.Fatalf("%s: decode: %v", path, err)
+		}
+	}
+
+	return rec.Code
+}
+
+func (a *testAPI) org(name string, maxApps, maxKeys int) string {
+	a.t.Helper()
+
+	var resp respAddOrg
+
+	body := `{"name": "` + name + `", "max_apps": ` + itoa(maxApps) + `, "max_keys": ` + itoa(maxKeys) + `}`
+
+	if code := a.call(testRootKey, "/orgs/add", body, &resp); code != http.StatusOK || resp.Key == "" {
+		a.t.Fatalf("add org: code = %d resp = %+v", code, resp)
+	}
+
+	return resp.Key
+}
+
+func (a *testAPI) expect(what string, code, want int) {
+	a.t.Helper()
+
+	if code != want {
+		a.t.Fatalf("%s: code = %d (want: %d)", what, code, want)
+	}
+}
+
+func itoa(n int) string {
+	b, _ := json.Marshal(n)
+
+	return string(b)
+}
+
+func TestAuth(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.org("alpha", 0, 0)
+
+	var table = []struct {
+		name, key, path string
+		code            int
+	}{
+		{"no key", "", "/apps", http.StatusUnauthorized},
+		{"wrong key", "wrong", "/apps", http.StatusUnauthorized},
+		{"root key is not org key", testRootKey, "/apps", http.StatusUnauthorized},
+		{"client without key", "", "/client/code-toggles", http.StatusUnauthorized},
+		{"client with wrong key", "wrong", "/client/alive", http.StatusUnauthorized},
+		{"org key is not root key", key, "/orgs/add", http.StatusUnauthorized},
+		{"reconcile without key", "", "/admin/reconcile", http.StatusUnauthorized},
+		{"org key", key, "/apps", http.StatusOK},
+	}
+
+	for _, tc := range table {
+		a.expect(tc.name, a.call(tc.key, tc.path, `{"name": "beta"}`, nil), tc.code)
+	}
+}
+
+func TestKeylessOrg(t *testing.T) {
+	// first organization gets id 1.
+	a := newKeylessTestAPI(t, 1)
+	key := a.org("default", 0, 0)
+
+	a.expect("app", a.call(key, "/apps/add", `{"apps": ["web"]}`, nil), http.StatusOK)
+	a.expect("toggles", a.call(key, "/toggles/add", `{
+		"app": "web", "version": "1.0", "platforms": ["ie6"], "keys": [{"name": "one", "enabled": true}]
+	}`, nil), http.StatusOK)
+
+	var resp respGetToggles
+
+	body := `{"app": "web", "version": "1.0", "platform": "ie6"}`
+
+	a.expect("client without key", a.call("", "/client/code-toggles", body, &resp), http.StatusOK)
+
+	if len(resp.Keys) != 1 {
+		t.Fatalf("keyless client toggles: %v", resp.Keys)
+	}
+
+	a.expect("client with wrong key", a.call("wrong", "/client/code-toggles", body, nil), http.StatusUnauthorized)
+	a.expect("admin without key", a.call("", "/apps", ``, nil), http.StatusUnauthorized)
+}
+
+func TestOrgsIsolation(t *testing.T) {
+	a := newTestAPI(t)
+	alpha, beta := a.org("alpha", 0, 0), a.org("beta", 0, 0)
+
+	a.expect("alpha app", a.call(alpha, "/apps/add", `{"apps": ["web"]}`, nil), http.StatusOK)
+	a.expect("beta app with same name", a.call(beta, "/apps/add", `{"apps": ["web"]}`, nil), http.StatusOK)
+
+	var apps []string
+
+	a.expect("beta apps", a.call(beta, "/apps", ``, &apps), http.StatusOK)
+
+	if len(apps) != 1 || apps[0] != "web" {
+		t.Fatalf("beta apps: %v", apps)
+	}
+
+	a.expect("duplicate org", a.call(testRootKey, "/orgs/add", `{"name": "Alpha"}`, nil), http.StatusConflict)
+}
+
+func TestQuotas(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.org("alpha", 2, 1)
+
+	a.expect("apps over quota", a.call(key, "/apps/add", `{"apps": ["a", "b", "c"]}`, nil), http.StatusForbidden)
+	a.expect("app", a.call(key, "/apps/add", `{"apps": ["a"]}`, nil), http.StatusOK)
+	a.expect("duplicate app", a.call(key, "/apps/add", `{"apps": ["A"]}`, nil), http.StatusConflict)
+	a.expect("apps", a.call(key, "/apps/add", `{"apps": ["b"]}`, nil), http.StatusOK)
+	a.expect("app over quota", a.call(key, "/apps/add", `{"apps": ["c"]}`, nil), http.StatusForbidden)
+
+	a.expect("keys over quota", a.call(key, "/toggles/add", `{
+		"app": "a", "version": "1.0", "platforms": ["ios"],
+		"keys": [{"name": "one", "enabled": true}, {"name": "two", "enabled": true}]
+	}`, nil), http.StatusForbidden)
+
+	a.expect("keys", a.call(key, "/toggles/add", `{
+		"app": "a", "version": "1.0", "platforms": ["ios"], "keys": [{"name": "one", "enabled": true}]
+	}`, nil), http.StatusOK)
+}
+
+func TestPromote(t *testing.T) {
+	a := newTestAPI(t)
+	key := a.org("alpha", 0, 0)
+
+	a.expect("app", a.call(key, "/apps/add", `{"apps": ["web"]}`, nil), http.StatusOK)
+	a.expect("toggles", a.call(key, "/toggles/add", `{
+		"app": "web", "env": "dev", "version": "1.0", "platforms": ["ie6"],
+		"keys": [{"name": "one", "enabled": true}, {"name": "two", "enabled": true}]
+	}`, nil), http.StatusOK)
+
+	enabled := func(env string) []string {
+		t.Helper()
+
+		var resp respGetToggles
+
+		body := `{"app": "web", "env": "` + env + `", "version": "1.0", "platform": "ie6"}`
+
+		a.expect(env+" toggles", a.call(key, "/client/code-toggles", body, &resp), http.StatusOK)
+
+		return resp.Keys
+	}
+
+	if keys := enabled("staging"); len(keys) != 0 {
+		t.Fatalf("staging before promotion: %v", keys)
+	}
+
+	a.expect("promote to next", a.call(key, "/toggles/promote", `{"app": "web", "from": "dev"}`, nil), http.StatusOK)
+
+	if keys := enabled("staging"); len(keys) != 2 {
+		t.Fatalf("staging after promotion: %v", keys)
+	}
+
+	if keys := enabled("production"); len(keys) != 0 {
+		t.Fatalf("production after promotion to staging: %v", keys)
+	}
+
+	a.expect("promote to production", a.call(key, "/toggles/promote", `{
+		"app": "web", "from": "dev", "to": "production"
+	}`, nil), http.StatusOK)
+
+	if keys := enabled("production"); len(keys) != 2 {
+		t.Fatalf("production after promotion: %v", keys)
+	}
+
+	var table = []struct {
+		name, body string
+	}{
+		{"no next env", `{"app": "web", "from": "production"}`},
+		{"same env", `{"app": "web", "from": "dev", "to": "dev"}`},
+		{"unknown env", `{"app": "web", "from": "dev", "to": "qa"}`},
+		{"unknown app", `{"app": "ios", "from": "dev"}`},
+		{"bad json", `{`},
+	}
+
+	for _, tc := range table {
+		a.expect(tc.name, a.call(key, "/toggles/promote", tc.body, nil), http.StatusBadRequest)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/toggles/promote", nil)
+	req.Header.Set(headerAPIKey, key)
+
+	rec := httptest.NewRecorder()
+	a.admin.ServeHTTP(rec, req)
+	a.expect("get", rec.Code, http.StatusMethodNotAllowed)
+
+	other := a.org("beta", 0, 0)
+
+	a.expect("other org app", a.call(other, "/toggles/promote", `{"app": "web", "from": "dev"}`, nil),
+		http.StatusBadRequest)
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+const (
+	headerRequestID = "X-Request-ID"
+	maxRequestIDLen = 128
+)
+
+// withRequestLog puts request-scoped logger (tagged with request id, taken from request
+// headers or generated) in request context, request id is sent back in response headers.
+func withRequestLog(l zerolog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(headerRequestID)
+		if id == "" || len(id) > maxRequestIDLen {
+			id = uuid.New().String()
+		}
+
+		w.Header().Set(headerRequestID, id)
+
+		rl := l.With().Str("request_id", id).Logger()
+
+		next.ServeHTTP(w, r.WithContext(rl.WithContext(r.Context())))
+	})
+}
+
+// logStr adds field to request-scoped logger, so it appears in access log.
+func logStr(ctx context.Context, key, val string) {
+	zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Str(key, val)
+	})
+}
@@ -1,13 +1,23 @@
 package api
 
+import "time"
+
 type (
 	key struct {
-		Name    string `json:"name"`
-		Enabled bool   `json:"enabled"`
+		Name        string     `json:"name"`
+		Enabled     bool       `json:"enabled"`
+		Description string     `json:"description"`
+		Owner       string     `json:"owner"`
+		Tags        []string   `json:"tags"`
+		Link        string     `json:"link"`
+		Stage       string     `json:"stage"`
+		ExpiresAt   *time.Time `json:"expires_at"`
 	}
 
 	reqAddToggles struct {
 		App       string   `json:"app"`
+		Author    string   `json:"author"`
+		Env       string   `json:"env"`
 		Version   string   `json:"version"`
 		Platforms []string `json:"platforms"`
 		Keys      []key    `json:"keys"`
@@ -15,12 +25,65 @@ type (
 
 	reqEditToggle struct {
 		App      string  `json:"app"`
+		Env      string  `json:"env"`
 		Version  string  `json:"version"`
 		Platform string  `json:"platform"`
 		Key      string  `json:"key"`
 		Rate     float64 `json:"rate"`
 	}
 
+	reqGetKeys struct {
+		App   string `json:"app"`
+		Owner string `json:"owner"`
+		Tag   string `json:"tag"`
+		Stage string `json:"stage"`
+	}
+
+	reqEditKey struct {
+		App         string     `json:"app"`
+		Key         string     `json:"key"`
+		Description string     `json:"description"`
+		Owner       string     `json:"owner"`
+		Tags        []string   `json:"tags"`
+		Link        string     `json:"link"`
+		Stage       string     `json:"stage"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+	}
+
+	reqStale struct {
+		Days int `json:"days"`
+	}
+
+	reqArchive struct {
+		Days int     `json:"days"`
+		IDs  []int64 `json:"ids"`
+	}
+
+	respArchive struct {
+		IDs []int64 `json:"ids"`
+	}
+
+	reqPromoteToggles struct {
+		App  string `json:"app"`
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	reqAddOrg struct {
+		Name    string `json:"name"`
+		MaxApps int    `json:"max_apps"`
+		MaxKeys int    `json:"max_keys"`
+	}
+
+	respAddOrg struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+	}
+
+	reqReconcile struct {
+		Repair bool `json:"repair"`
+	}
+
 	reqAddApp struct {
 		Apps []string `json:"apps"`
 	}
@@ -31,6 +94,7 @@ type (
 
 	reqGetToggles struct {
 		App      string `json:"app"`
+		Env      string `json:"env"`
 		Version  string `json:"version"`
 		Platform string `json:"platform"`
 	}
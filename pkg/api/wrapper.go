@@ -5,17 +5,51 @@ import (
 	"context"
 	"errors"
 	"io"
-	"log"
 	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/metrics"
+	"github.com/s0rg/toggle-svc/pkg/tracing"
 )
 
+const contentTypeJSON = "application/json"
+
 type handler func(ctx context.Context, w io.Writer, r *http.Request) error
 
+type response struct {
+	bytes.Buffer
+	contentType string
+}
+
+// setContentType overrides default (json) content type for handler response.
+func setContentType(w io.Writer, ct string) {
+	if r, ok := w.(*response); ok {
+		r.contentType = ct
+	}
+}
+
+// wrapAPI serves handler `name` for POST requests, writes access log and records metrics.
 func wrapAPI(name string, h handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var buf bytes.Buffer
+		buf := response{contentType: contentTypeJSON}
+
+		var (
+			code = http.StatusMethodNotAllowed
+			err  error
+		)
 
-		code := http.StatusMethodNotAllowed
+		ctx, end := tracing.Server(name, r)
+
+		defer func(start time.Time) {
+			latency := time.Since(start)
+
+			metrics.ObserveRequest(name, code, latency)
+			end(code)
+			accessLog(ctx, name, code, latency, err)
+		}(time.Now())
 
 		if r.Method != http.MethodPost {
 			http.Error(w, http.StatusText(code), code)
@@ -23,24 +57,46 @@ func wrapAPI(name string, h handler) http.HandlerFunc {
 			return
 		}
 
-		code = http.StatusBadRequest
-
-		if err := h(r.Context(), &buf, r); err != nil {
-			if !errors.Is(err, errBadRequest) {
-				code = http.StatusInternalServerError
-
-				log.Println("api:", name, "error:", err)
-			}
-
+		if err = h(ctx, &buf, r); err != nil {
+			code = errorCode(err)
 			http.Error(w, http.StatusText(code), code)
 
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
+		code = http.StatusOK
+
+		w.Header().Set("Content-Type", buf.contentType)
 
-		if _, err := buf.WriteTo(w); err != nil {
-			log.Println("api:", name, "response error:", err)
+		if _, err = buf.WriteTo(w); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("route", name).Msg("api: response error")
 		}
 	}
 }
+
+// accessLog writes request summary, internal errors are logged with error level.
+func accessLog(ctx context.Context, route string, code int, latency time.Duration, err error) {
+	l := zerolog.Ctx(ctx)
+
+	ev := l.Info()
+	if code == http.StatusInternalServerError {
+		ev = l.Error().Err(err)
+	}
+
+	ev.Str("route", route).Int("status", code).Dur("latency", latency).Msg("api: request")
+}
+
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, errBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, errUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, db.ErrQuotaExceeded):
+		return http.StatusForbidden
+	case errors.Is(err, db.ErrConflict):
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
@@ -6,8 +6,11 @@ import (
 	"io"
 	"log"
 	"os"
+	"sync"
 
 	"github.com/rs/zerolog"
+
+	"github.com/s0rg/toggle-svc/pkg/tracing"
 )
 
 // App base, nothing interesting.
@@ -20,11 +23,24 @@ type App struct {
 	envKeys   []string
 	closers   []io.Closer
 	env       map[string]string
+	opts      options
+	fields    []*field
+	cfgFile   *flagValue
+	cfgErr    error
+	hooks     []func()
+	sampler   sampler
+	logger    zerolog.Logger
+	mu        sync.Mutex
 }
 
 // New creates empty application with given name.
 func New(name string) *App {
-	return &App{Name: name}
+	app := &App{Name: name}
+
+	// options are well-formed, error is impossible here.
+	app.fields, _ = parseFields(&app.opts)
+
+	return app
 }
 
 // WithGitInfo sets 'git' tag in logger.
@@ -50,6 +66,11 @@ func (app *App) WithEnvKeys(keys ...string) *App {
 	return app
 }
 
+// Logger returns app-level logger, it is ready after app.Init.
+func (app *App) Logger() zerolog.Logger {
+	return app.logger
+}
+
 // ID returns string in {name}/{pid}@{host} format, where:
 // name - app name (see app.New)
 // pid  - process id
@@ -92,9 +113,10 @@ func (app *App) setUpEnv() (err error) {
 // Init bootstraps new app:
 //
 // - Obtains keys, listed with WithEnvKeys(), from env, if some missing or empty - produces error
+// - Loads configs, added with WithConfig(), and dumps them (with secrets masked) to log
 // - Setup app-level json logger (zerolog) as default (and transparent replacement for stdlib log)
-//and fills name, hostname, pid (and optionally git) tags
-// - (optionally) Setup app-level opentracing agent.
+//and fills name, hostname, pid (and optionally git) tags, level and sampling are set by LOG_LEVEL and LOG_SAMPLE keys
+// - (optionally) Setup app-level OpenTelemetry tracing, see tracing.Setup for configuration.
 func (app *App) Init() (err error) {
 	var host string
 
@@ -106,6 +128,10 @@ func (app *App) Init() (err error) {
 		return
 	}
 
+	if err = app.loadConfig(); err != nil {
+		return
+	}
+
 	pid := os.Getpid()
 
 	app.id = fmt.Sprintf("%s/%d@%s", app.Name, pid, host)
@@ -120,13 +146,31 @@ func (app *App) Init() (err error) {
 		zctx = zctx.Str("git", app.git)
 	}
 
+	if err = app.applyLogging(); err != nil {
+		return
+	}
+
+	app.logger = zctx.Logger().Sample(zerolog.LevelSampler{DebugSampler: &app.sampler, InfoSampler: &app.sampler})
+
 	log.SetFlags(0)
-	log.SetOutput(zctx.Logger())
+	log.SetOutput(app.logger)
+
+	app.logger.Log().Dict("config", zerolog.Dict().Fields(app.configDump())).Msg("app: config")
+
+	var tracer io.Closer
+
+	if tracer, err = tracing.Setup(app.Name); err != nil {
+		return
+	}
 
-	return err
+	if tracer != nil {
+		app.DeferClose(tracer)
+	}
+
+	return nil
 }
 
-// GetEnv get dependency, if key was not listed via WithEnvKeys() - does log.Fatal.
+// GetEnv get dependency, if key was not listed via WithEnvKeys() or WithConfig() - does log.Fatal.
 func (app *App) GetEnv(key string) string {
 	val, ok := app.env[key]
 	if !ok {
@@ -0,0 +1,371 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	keyConfig  = "CONFIG"
+	fileSuffix = "_FILE"
+	secretMask = "***"
+)
+
+var (
+	errNotStruct   = errors.New("app.config: pointer to struct expected")
+	durationType   = reflect.TypeOf(time.Duration(0))
+	errUnsupported = errors.New("app.config: unsupported field type")
+)
+
+// options are app own settings, they are always loaded.
+type options struct {
+	LogLevel  string `env:"LOG_LEVEL" default:"info" reload:"true" usage:"minimal log level: debug, info, warn or error"`
+	LogSample uint32 `env:"LOG_SAMPLE" default:"1" reload:"true" usage:"write only every n-th debug and info message"`
+}
+
+// field is a single config value.
+type field struct {
+	key      string
+	def      string
+	usage    string
+	required bool
+	secret   bool
+	reload   bool
+	val      reflect.Value
+	flag     *flagValue
+}
+
+// flagValue remembers, if flag was set explicitly.
+type flagValue struct {
+	val    string
+	set    bool
+	isBool bool
+}
+
+func (f *flagValue) String() string { return f.val }
+
+func (f *flagValue) Set(s string) error {
+	f.val, f.set = s, true
+
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
+
+// WithConfig adds typed configs, every cfg must be a pointer to struct, which fields are described by tags:
+//
+// - `env:"KEY"` - key name, value is taken from {prefix}_{KEY} env var (or from file, named by {prefix}_{KEY}_FILE),
+// from "key" in config file and from -key flag (lowercase, with "-" instead of "_"), fields without it are skipped
+// - `default:"value"` - default value
+// - `required:"true"` - value can not be empty
+// - `secret:"true"` - value is masked in config dump
+// - `reload:"true"` - value can be changed by Reload
+// - `usage:"text"` - flag usage.
+//
+// Sources are merged in order: defaults, config file (YAML, its path is taken from -config flag or
+// {prefix}_CONFIG env var), env, flags. Field types can be: string, bool, ints, uints, floats and
+// time.Duration. Flags are registered on flag.CommandLine, so WithConfig must be called before flag.Parse.
+// Config values are also available via GetEnv, as strings.
+func (app *App) WithConfig(cfgs ...interface{}) *App {
+	for _, c := range cfgs {
+		fields, err := parseFields(c)
+		if err != nil {
+			app.cfgErr = err
+
+			return app
+		}
+
+		app.fields = append(app.fields, fields...)
+	}
+
+	if app.cfgFile == nil {
+		app.cfgFile = &flagValue{}
+		flag.Var(app.cfgFile, flagName(keyConfig), "path to YAML config file")
+	}
+
+	for _, f := range app.fields {
+		if f.flag != nil {
+			continue
+		}
+
+		f.flag = &flagValue{val: f.def, isBool: f.val.Kind() == reflect.Bool}
+		flag.Var(f.flag, flagName(f.key), f.usage)
+	}
+
+	return app
+}
+
+func parseFields(cfg interface{}) (rv []*field, err error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, errNotStruct
+	}
+
+	v = v.Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		key, ok := sf.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+
+		f := &field{
+			key:      key,
+			def:      sf.Tag.Get("default"),
+			usage:    sf.Tag.Get("usage"),
+			required: sf.Tag.Get("required") == "true",
+			secret:   sf.Tag.Get("secret") == "true",
+			reload:   sf.Tag.Get("reload") == "true",
+			val:      v.Field(i),
+		}
+
+		// validate type and default early.
+		if err = setValue(f.val, f.def); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, key)
+		}
+
+		rv = append(rv, f)
+	}
+
+	return rv, nil
+}
+
+// loadConfig fills config fields from all sources.
+func (app *App) loadConfig() (err error) {
+	var vals map[string]string
+
+	if vals, err = app.resolveConfig(); err != nil {
+		return
+	}
+
+	for _, f := range app.fields {
+		// values are validated already.
+		_ = setValue(f.val, vals[f.key])
+		app.env[f.key] = vals[f.key]
+	}
+
+	return nil
+}
+
+// resolveConfig merges all sources, and validates resulting values.
+func (app *App) resolveConfig() (rv map[string]string, err error) {
+	if app.cfgErr != nil {
+		return nil, app.cfgErr
+	}
+
+	var file map[string]string
+
+	if file, err = app.readConfigFile(); err != nil {
+		return
+	}
+
+	rv = make(map[string]string, len(app.fields))
+
+	for _, f := range app.fields {
+		val := f.def
+
+		if v, ok := file[strings.ToLower(f.key)]; ok {
+			val = v
+		}
+
+		var (
+			v  string
+			ok bool
+		)
+
+		if v, ok, err = app.lookupEnv(f.key); err != nil {
+			return
+		}
+
+		if ok {
+			val = v
+		}
+
+		if f.flag != nil && f.flag.set {
+			val = f.flag.val
+		}
+
+		if f.required && val == "" {
+			return nil, fmt.Errorf("app.config: %s key '%s' is not set or empty", f.key, app.envKey(f.key))
+		}
+
+		if err = setValue(reflect.New(f.val.Type()).Elem(), val); err != nil {
+			return nil, fmt.Errorf("%w: %s: '%s'", err, f.key, val)
+		}
+
+		rv[f.key] = val
+	}
+
+	return rv, nil
+}
+
+func (app *App) readConfigFile() (rv map[string]string, err error) {
+	var (
+		path string
+		buf  []byte
+	)
+
+	if app.cfgFile != nil && app.cfgFile.set {
+		path = app.cfgFile.val
+	} else if path, _, err = app.lookupEnv(keyConfig); err != nil {
+		return
+	}
+
+	if path == "" {
+		return nil, nil
+	}
+
+	if buf, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+
+	if err = yaml.Unmarshal(buf, &rv); err != nil {
+		return nil, fmt.Errorf("app.config: %s: %w", path, err)
+	}
+
+	known := make(map[string]bool, len(app.fields))
+
+	for _, f := range app.fields {
+		known[strings.ToLower(f.key)] = true
+	}
+
+	for k := range rv {
+		if !known[k] {
+			return nil, fmt.Errorf("app.config: %s: unknown key '%s'", path, k)
+		}
+	}
+
+	return rv, nil
+}
+
+// lookupEnv returns non-empty value of env var for key, or content of file, named in {key}_FILE var.
+func (app *App) lookupEnv(key string) (val string, ok bool, err error) {
+	name := app.envKey(key)
+
+	if val = os.Getenv(name); val != "" {
+		return val, true, nil
+	}
+
+	path := os.Getenv(name + fileSuffix)
+	if path == "" {
+		return "", false, nil
+	}
+
+	var buf []byte
+
+	if buf, err = ioutil.ReadFile(path); err != nil {
+		return "", false, fmt.Errorf("app.config: %s%s: %w", name, fileSuffix, err)
+	}
+
+	return strings.TrimRight(string(buf), "\r\n"), true, nil
+}
+
+func (app *App) envKey(key string) string {
+	if app.envPrefix == "" {
+		return key
+	}
+
+	return app.envPrefix + "_" + key
+}
+
+// configDump returns effective config, with secrets masked.
+func (app *App) configDump() map[string]interface{} {
+	rv := make(map[string]interface{}, len(app.fields))
+
+	for _, f := range app.fields {
+		rv[strings.ToLower(f.key)] = f.mask(app.env[f.key])
+	}
+
+	return rv
+}
+
+// mask hides value of secret field.
+func (f *field) mask(val string) string {
+	if f.secret && val != "" {
+		return secretMask
+	}
+
+	return val
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+func setValue(v reflect.Value, s string) (err error) {
+	if v.Type() == durationType {
+		var d time.Duration
+
+		if s != "" {
+			if d, err = time.ParseDuration(s); err != nil {
+				return
+			}
+		}
+
+		v.SetInt(int64(d))
+
+		return nil
+	}
+
+	//nolint:exhaustive
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		var b bool
+
+		if s != "" {
+			if b, err = strconv.ParseBool(s); err != nil {
+				return
+			}
+		}
+
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+
+		if s != "" {
+			if n, err = strconv.ParseInt(s, 10, v.Type().Bits()); err != nil {
+				return
+			}
+		}
+
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+
+		if s != "" {
+			if n, err = strconv.ParseUint(s, 10, v.Type().Bits()); err != nil {
+				return
+			}
+		}
+
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		var n float64
+
+		if s != "" {
+			if n, err = strconv.ParseFloat(s, v.Type().Bits()); err != nil {
+				return
+			}
+		}
+
+		v.SetFloat(n)
+	default:
+		return errUnsupported
+	}
+
+	return nil
+}
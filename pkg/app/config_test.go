@@ -0,0 +1,215 @@
+//nolint:testpackage
+package app
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type testConfig struct {
+	Addr    string        `env:"ADDR" default:":8080"`
+	Expire  time.Duration `env:"EXPIRE" default:"1m"`
+	Workers int           `env:"WORKERS" default:"2"`
+	Rate    float64       `env:"RATE"`
+	Debug   bool          `env:"DEBUG"`
+	Key     string        `env:"KEY" required:"true" secret:"true"`
+	Skipped string
+}
+
+func setenv(t *testing.T, key, val string) {
+	t.Helper()
+
+	prev, ok := os.LookupEnv(key)
+
+	if err := os.Setenv(key, val); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv(key, prev)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
+
+func writeFile(t *testing.T, dir, name, body string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+
+	if err := ioutil.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// resetFlags drops flags, registered by previous tests.
+func resetFlags() {
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+}
+
+func TestConfig(t *testing.T) {
+	resetFlags()
+
+	dir := t.TempDir()
+
+	var cfg testConfig
+
+	app := New("test").WithEnvPrefix("TEST").WithConfig(&cfg)
+
+	setenv(t, "TEST_CONFIG", writeFile(t, dir, "cfg.yml", "addr: :9090\nworkers: 4\nrate: 0.5\nkey: file-key\n"))
+	setenv(t, "TEST_WORKERS", "8")
+	setenv(t, "TEST_KEY_FILE", writeFile(t, dir, "key", "secret-key\n"))
+
+	if err := flag.CommandLine.Set("debug", "true"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := flag.CommandLine.Set("addr", ":7070"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := testConfig{
+		Addr:    ":7070",
+		Expire:  time.Minute,
+		Workers: 8,
+		Rate:    0.5,
+		Debug:   true,
+		Key:     "secret-key",
+	}
+
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("config: %+v (want: %+v)", cfg, want)
+	}
+
+	if v := app.GetEnv("WORKERS"); v != "8" {
+		t.Fatalf("GetEnv: %s", v)
+	}
+
+	if d := app.configDump(); d["key"] != secretMask || d["addr"] != ":7070" || d["log_level"] != "info" {
+		t.Fatalf("dump: %v", d)
+	}
+
+	// required key.
+	setenv(t, "TEST_KEY_FILE", "")
+	setenv(t, "TEST_CONFIG", "")
+
+	if err := app.Init(); err == nil {
+		t.Fatal("no error for missing required key")
+	}
+
+	// unknown key in config file.
+	setenv(t, "TEST_CONFIG", writeFile(t, dir, "bad.yml", "adr: :9090\n"))
+
+	if err := app.Init(); err == nil {
+		t.Fatal("no error for unknown key")
+	}
+
+	// bad value.
+	setenv(t, "TEST_KEY", "key")
+	setenv(t, "TEST_CONFIG", "")
+	setenv(t, "TEST_EXPIRE", "soon")
+
+	if err := app.Init(); err == nil {
+		t.Fatal("no error for bad duration")
+	}
+}
+
+func TestConfigBadStruct(t *testing.T) {
+	var cfg struct {
+		Ch chan int `env:"CH"`
+	}
+
+	if _, err := parseFields(&cfg); err == nil {
+		t.Fatal("no error for unsupported type")
+	}
+
+	if _, err := parseFields(cfg); err == nil {
+		t.Fatal("no error for non-pointer")
+	}
+}
+
+func TestReload(t *testing.T) {
+	resetFlags()
+
+	var (
+		cfg struct {
+			TTL  time.Duration `env:"TTL" default:"1m" reload:"true"`
+			Port int           `env:"PORT" default:"80"`
+			Key  string        `env:"KEY" secret:"true" reload:"true"`
+		}
+		hooks int
+	)
+
+	app := New("test").WithEnvPrefix("RELOAD").WithConfig(&cfg)
+
+	app.OnReload(func() { hooks++ })
+
+	if err := app.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// no changes.
+	if changes, err := app.Reload(); err != nil || len(changes) != 0 || hooks != 0 {
+		t.Fatalf("reload: %v %v (hooks: %d)", changes, err, hooks)
+	}
+
+	setenv(t, "RELOAD_TTL", "2m")
+	setenv(t, "RELOAD_PORT", "81")
+	setenv(t, "RELOAD_KEY", "secret")
+	setenv(t, "RELOAD_LOG_LEVEL", "warn")
+
+	changes, err := app.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Change{
+		{Key: "log_level", Old: "info", New: "warn", Applied: true},
+		{Key: "ttl", Old: "1m", New: "2m", Applied: true},
+		{Key: "port", Old: "80", New: "81"},
+		{Key: "key", New: secretMask, Applied: true},
+	}
+
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes: %+v (want: %+v)", changes, want)
+	}
+
+	if cfg.TTL != 2*time.Minute || cfg.Port != 80 || cfg.Key != "secret" || hooks != 1 {
+		t.Fatalf("config: %+v (hooks: %d)", cfg, hooks)
+	}
+
+	if l := zerolog.GlobalLevel(); l != zerolog.WarnLevel {
+		t.Fatalf("log level: %v", l)
+	}
+
+	// bad values are not applied.
+	setenv(t, "RELOAD_TTL", "soon")
+
+	if _, err = app.Reload(); err == nil || cfg.TTL != 2*time.Minute {
+		t.Fatalf("bad value: %v, ttl: %v", err, cfg.TTL)
+	}
+
+	setenv(t, "RELOAD_TTL", "")
+	setenv(t, "RELOAD_LOG_LEVEL", "loud")
+
+	if _, err = app.Reload(); err == nil || zerolog.GlobalLevel() != zerolog.WarnLevel {
+		t.Fatal("bad log level applied")
+	}
+
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
+}
@@ -2,10 +2,26 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	// driver.
-	_ "github.com/lib/pq"
 	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	// driver.
+	_ "modernc.org/sqlite"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
+)
+
+const (
+	sqlitePrefix = "sqlite://"
+
+	listenerMinReconnect = 100 * time.Millisecond
+	listenerMaxReconnect = time.Minute
+	listenerPingPeriod   = time.Minute
 )
 
 type app interface {
@@ -13,22 +29,126 @@ type app interface {
 	DeferClose(io.Closer)
 }
 
-// ForApp return new *sql.DB (postgres driver)
+// ForApp return new *sql.DB with up-to-date schema, driver is chosen by dsn:
+//
+// "sqlite://{path}" (i.e. "sqlite:///var/lib/toggle.db" or "sqlite://:memory:") - embedded SQLite,
+// any other dsn is passed to postgres driver as-is.
 //
 // key is a dependency (see app.GetEnv), that holds connection dsn
 //
 // this *sql.DB will be closed upon app.Close() invocation.
 func ForApp(app app, key string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", app.GetEnv(key))
+	driver, dialect, dsn := parseDSN(app.GetEnv(key))
+
+	conn, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = db.Ping(); err != nil {
+	if dialect == db.DialectSQLite {
+		// sqlite allows only one writer, also every connection to ":memory:" opens new database.
+		conn.SetMaxOpenConns(1)
+
+		if _, err = conn.Exec(`PRAGMA foreign_keys = ON`); err != nil {
+			conn.Close()
+
+			return nil, err
+		}
+	}
+
+	if err = conn.Ping(); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	if err = db.Migrate(context.Background(), conn, dialect); err != nil {
+		conn.Close()
+
 		return nil, err
 	}
 
-	app.DeferClose(db)
+	app.DeferClose(conn)
+
+	return conn, nil
+}
+
+func parseDSN(dsn string) (driver, dialect, rest string) {
+	if strings.HasPrefix(dsn, sqlitePrefix) {
+		return "sqlite", db.DialectSQLite, strings.TrimPrefix(dsn, sqlitePrefix)
+	}
+
+	return "postgres", db.DialectPostgres, dsn
+}
 
-	return db, nil
+// NotifierForApp returns notifier of service replicas about changes for postgres, and nil for sqlite,
+// as its database belongs to single process.
+//
+// key is a dependency (see app.GetEnv), that holds connection dsn.
+func NotifierForApp(app app, key string, conn *sql.DB) db.Notifier {
+	if _, dialect, _ := parseDSN(app.GetEnv(key)); dialect != db.DialectPostgres {
+		return nil
+	}
+
+	return db.PgNotifier(conn)
+}
+
+// CacheForApp wraps store with cache, for postgres it also notifies other replicas about changes
+// and listens for theirs, sqlite database belongs to single process, so its cache is local.
+//
+// key is a dependency (see app.GetEnv), that holds connection dsn
+//
+// listener will be closed upon app.Close() invocation.
+func CacheForApp(app app, key string, conn *sql.DB, s db.Store) (*db.Cache, error) {
+	_, dialect, dsn := parseDSN(app.GetEnv(key))
+	if dialect != db.DialectPostgres {
+		return db.NewCache(s, nil), nil
+	}
+
+	c := db.NewCache(s, NotifierForApp(app, key, conn))
+
+	l := pq.NewListener(dsn, listenerMinReconnect, listenerMaxReconnect, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("db: listener:", ev, err)
+		}
+	})
+
+	if err := l.Listen(db.NotifyChannel); err != nil {
+		l.Close()
+
+		return nil, err
+	}
+
+	go listen(l, c)
+
+	app.DeferClose(l)
+
+	return c, nil
+}
+
+// listen handles notifications until listener is closed, cache is reset after re-connects,
+// as notifications may be lost.
+func listen(l *pq.Listener, c *db.Cache) {
+	t := time.NewTicker(listenerPingPeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case n, ok := <-l.Notify:
+			if !ok {
+				return
+			}
+
+			if n == nil {
+				c.Reset()
+
+				continue
+			}
+
+			c.OnNotify(n.Extra)
+		case <-t.C:
+			// ping detects broken connections, listener re-connects them itself.
+			go func() { _ = l.Ping() }()
+		}
+	}
 }
@@ -0,0 +1,198 @@
+package redis
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/radix/v3"
+
+	"github.com/s0rg/toggle-svc/pkg/certs"
+)
+
+const (
+	schemeRedis     = "redis"
+	schemeSentinel  = "redis-sentinel"
+	schemeCluster   = "redis-cluster"
+	defaultPoolSize = 10
+)
+
+var errBadDSN = errors.New("bad redis dsn")
+
+type app interface {
+	GetEnv(string) string
+	DeferClose(io.Closer)
+}
+
+type config struct {
+	Scheme  string
+	Addrs   []string
+	Master  string
+	Pool    int
+	User    string
+	Pass    string
+	DB      int
+	TLS     bool
+	TLSCA   string
+	TLSCert string
+	TLSKey  string
+	tls     *tls.Config
+}
+
+// ForApp return new radix.Client, kind of client depends on dsn, that can be:
+//
+// "host:port" or "redis://[user:pass@]host:port[/db][?pool=N]" - connection pool to single node,
+//
+// "redis-sentinel://[user:pass@]host:port,host:port/master[?db=N&pool=N]" - pool to current
+// master, discovered and failed over by sentinels,
+//
+// "redis-cluster://[user:pass@]host:port,host:port[?pool=N]" - Redis Cluster, with pool for
+// every node.
+//
+// Any url dsn may have "tls=true" param, that enables TLS for all connections, servers are verified
+// by system CAs or ones from "tls_ca" file, client certificate is taken from "tls_cert" and "tls_key" files.
+//
+// key is a dependency (see app.GetEnv), that holds dsn
+//
+// this client will be closed upon app.Close() invocation.
+func ForApp(app app, key string) (rv radix.Client, err error) {
+	var cfg config
+
+	if cfg, err = parseDSN(app.GetEnv(key)); err != nil {
+		return
+	}
+
+	if cfg.TLS {
+		if cfg.tls, err = certs.ClientConfig(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey); err != nil {
+			return
+		}
+	}
+
+	if rv, err = cfg.client(); err != nil {
+		return
+	}
+
+	app.DeferClose(rv)
+
+	return rv, nil
+}
+
+func (c *config) client() (radix.Client, error) {
+	pool := func(network, addr string) (radix.Client, error) {
+		return radix.NewPool(network, addr, c.Pool, radix.PoolConnFunc(c.dial))
+	}
+
+	switch c.Scheme {
+	case schemeSentinel:
+		sentinel := func(network, addr string) (radix.Conn, error) {
+			return radix.Dial(network, addr, c.tlsOpts()...)
+		}
+
+		return radix.NewSentinel(c.Master, c.Addrs, radix.SentinelPoolFunc(pool), radix.SentinelConnFunc(sentinel))
+	case schemeCluster:
+		return radix.NewCluster(c.Addrs, radix.ClusterPoolFunc(pool))
+	}
+
+	return pool("tcp", c.Addrs[0])
+}
+
+func (c *config) tlsOpts() []radix.DialOpt {
+	if c.tls == nil {
+		return nil
+	}
+
+	return []radix.DialOpt{radix.DialUseTLS(c.tls)}
+}
+
+func (c *config) dial(network, addr string) (radix.Conn, error) {
+	opts := c.tlsOpts()
+
+	if c.Pass != "" {
+		if c.User != "" {
+			opts = append(opts, radix.DialAuthUser(c.User, c.Pass))
+		} else {
+			opts = append(opts, radix.DialAuthPass(c.Pass))
+		}
+	}
+
+	if c.DB != 0 {
+		opts = append(opts, radix.DialSelectDB(c.DB))
+	}
+
+	return radix.Dial(network, addr, opts...)
+}
+
+func parseDSN(dsn string) (rv config, err error) {
+	rv.Pool = defaultPoolSize
+
+	if !strings.Contains(dsn, "://") {
+		rv.Scheme, rv.Addrs = schemeRedis, []string{dsn}
+
+		return rv, nil
+	}
+
+	var u *url.URL
+
+	if u, err = url.Parse(dsn); err != nil {
+		return
+	}
+
+	rv.Scheme = u.Scheme
+	rv.Addrs = strings.Split(u.Host, ",")
+	rv.User = u.User.Username()
+	rv.Pass, _ = u.User.Password()
+
+	q := u.Query()
+	path := strings.Trim(u.Path, "/")
+
+	switch rv.Scheme {
+	case schemeRedis:
+		if len(rv.Addrs) != 1 {
+			return rv, errBadDSN
+		}
+
+		if path != "" {
+			q.Set("db", path)
+		}
+	case schemeSentinel:
+		if rv.Master = path; rv.Master == "" {
+			return rv, errBadDSN
+		}
+	case schemeCluster:
+		if q.Get("db") != "" {
+			return rv, errBadDSN
+		}
+	default:
+		return rv, errBadDSN
+	}
+
+	if rv.DB, err = intParam(q, "db", 0); err != nil {
+		return
+	}
+
+	if rv.Pool, err = intParam(q, "pool", defaultPoolSize); err != nil {
+		return
+	}
+
+	if v := q.Get("tls"); v != "" {
+		if rv.TLS, err = strconv.ParseBool(v); err != nil {
+			return
+		}
+	}
+
+	rv.TLSCA, rv.TLSCert, rv.TLSKey = q.Get("tls_ca"), q.Get("tls_cert"), q.Get("tls_key")
+
+	return rv, nil
+}
+
+func intParam(q url.Values, name string, def int) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(v)
+}
@@ -0,0 +1,67 @@
+//nolint:testpackage
+package redis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want config
+		err  bool
+	}{
+		{"redis:6379", config{Scheme: schemeRedis, Addrs: []string{"redis:6379"}, Pool: defaultPoolSize}, false},
+		{
+			"redis://:secret@redis:6379/2?pool=4",
+			config{Scheme: schemeRedis, Addrs: []string{"redis:6379"}, Pool: 4, Pass: "secret", DB: 2},
+			false,
+		},
+		{
+			"redis-sentinel://s1:26379,s2:26379/mymaster?db=1",
+			config{Scheme: schemeSentinel, Addrs: []string{"s1:26379", "s2:26379"}, Master: "mymaster", Pool: defaultPoolSize, DB: 1},
+			false,
+		},
+		{
+			"redis-cluster://user:pass@c1:7000,c2:7000",
+			config{Scheme: schemeCluster, Addrs: []string{"c1:7000", "c2:7000"}, Pool: defaultPoolSize, User: "user", Pass: "pass"},
+			false,
+		},
+		{
+			"redis://redis:6380?tls=true&tls_ca=/ca.pem&tls_cert=/cert.pem&tls_key=/key.pem",
+			config{
+				Scheme: schemeRedis, Addrs: []string{"redis:6380"}, Pool: defaultPoolSize,
+				TLS: true, TLSCA: "/ca.pem", TLSCert: "/cert.pem", TLSKey: "/key.pem",
+			},
+			false,
+		},
+		{"redis-sentinel://s1:26379", config{}, true},
+		{"redis-cluster://c1:7000/?db=1", config{}, true},
+		{"redis://a:1,b:2", config{}, true},
+		{"memcache://a:1", config{}, true},
+		{"redis://a:1?pool=x", config{}, true},
+		{"redis://a:1?tls=maybe", config{}, true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseDSN(tc.dsn)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: no error", tc.dsn)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", tc.dsn, err)
+
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v (want: %+v)", tc.dsn, got, tc.want)
+		}
+	}
+}
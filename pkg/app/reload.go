@@ -0,0 +1,126 @@
+package app
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+const keyLogLevel = "LOG_LEVEL"
+
+// Change is a single config key change.
+type Change struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+	// Applied is false for keys, that can not be changed without restart.
+	Applied bool `json:"applied"`
+}
+
+// sampler passes every n-th message, n can be changed at any time.
+type sampler struct {
+	n     uint32
+	count uint32
+}
+
+// Sample implements zerolog.Sampler.
+func (s *sampler) Sample(zerolog.Level) bool {
+	n := atomic.LoadUint32(&s.n)
+	if n <= 1 {
+		return true
+	}
+
+	return atomic.AddUint32(&s.count, 1)%n == 1
+}
+
+// OnReload adds hook, that is called after successful reload, that changed some keys, so
+// new values of `reload:"true"` config fields can be applied.
+func (app *App) OnReload(fn func()) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	app.hooks = append(app.hooks, fn)
+}
+
+// Reload reloads configs (see WithConfig) from all sources, only fields, tagged with `reload:"true"`
+// are changed, changes of other fields are reported as not applied. Log level and sampling are
+// applied by app itself, then hooks (see OnReload) are called. Reload is logged with changes,
+// secrets are masked. On error nothing is changed.
+func (app *App) Reload() (changes []Change, err error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	var vals map[string]string
+
+	if vals, err = app.resolveConfig(); err != nil {
+		return
+	}
+
+	if _, err = parseLevel(vals[keyLogLevel]); err != nil {
+		return
+	}
+
+	var applied bool
+
+	for _, f := range app.fields {
+		old, val := app.env[f.key], vals[f.key]
+		if old == val {
+			continue
+		}
+
+		changes = append(changes, Change{
+			Key:     strings.ToLower(f.key),
+			Old:     f.mask(old),
+			New:     f.mask(val),
+			Applied: f.reload,
+		})
+
+		if !f.reload {
+			continue
+		}
+
+		// values are validated already.
+		_ = setValue(f.val, val)
+		app.env[f.key] = val
+		applied = true
+	}
+
+	if applied {
+		_ = app.applyLogging()
+
+		for _, fn := range app.hooks {
+			fn()
+		}
+	}
+
+	app.logger.Log().Interface("changes", changes).Msg("app: config reloaded")
+
+	return changes, nil
+}
+
+// applyLogging applies log level and sampling options.
+func (app *App) applyLogging() (err error) {
+	var level zerolog.Level
+
+	if level, err = parseLevel(app.opts.LogLevel); err != nil {
+		return
+	}
+
+	zerolog.SetGlobalLevel(level)
+	atomic.StoreUint32(&app.sampler.n, app.opts.LogSample)
+
+	return nil
+}
+
+func parseLevel(s string) (level zerolog.Level, err error) {
+	if level, err = zerolog.ParseLevel(s); err != nil {
+		return
+	}
+
+	if level == zerolog.NoLevel {
+		level = zerolog.InfoLevel
+	}
+
+	return level, nil
+}
@@ -0,0 +1,178 @@
+// Package breaker implements circuit breaker, that makes calls to failing dependency fail fast.
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Breaker states.
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+// ErrOpen is returned (wrapped) for calls, rejected by open breaker.
+var ErrOpen = errors.New("circuit breaker is open")
+
+type (
+	// State is a breaker state: closed breaker passes calls, open one rejects them, and
+	// half-open one passes single probe call, which result closes or opens it again.
+	State int
+
+	// Config holds breaker thresholds.
+	Config struct {
+		// Failures is a number of consecutive failed calls, that opens breaker.
+		Failures int
+		// Slow calls are counted as failed ones, even if they succeed, zero disables this.
+		Slow time.Duration
+		// Cooldown is a time, breaker stays open, before probe call is passed.
+		Cooldown time.Duration
+		// IsFailure classifies call errors, nil means that every error is a failure.
+		IsFailure func(error) bool
+	}
+
+	// Breaker guards calls to single dependency.
+	Breaker struct {
+		name     string
+		cfg      Config
+		now      func() time.Time
+		mu       sync.Mutex
+		state    State
+		failures int
+		openedAt time.Time
+		probing  bool
+	}
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+
+	return "unknown"
+}
+
+// New creates closed breaker, name is used in errors and logs.
+func New(name string, cfg Config) *Breaker {
+	return &Breaker{name: name, cfg: cfg, now: time.Now}
+}
+
+// Name returns breaker name.
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns current breaker state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && !b.probing && b.now().Sub(b.openedAt) >= b.cfg.Cooldown {
+		return HalfOpen
+	}
+
+	return b.state
+}
+
+// Do calls fn, if breaker allows it, and records result, rejected calls return ErrOpen.
+func (b *Breaker) Do(fn func() error) error {
+	return b.do(fn, true)
+}
+
+// DoBulk is a Do for bulk (i.e. background) calls, that are slow by nature: their duration
+// is not checked, only errors are counted.
+func (b *Breaker) DoBulk(fn func() error) error {
+	return b.do(fn, false)
+}
+
+func (b *Breaker) do(fn func() error, checkSlow bool) (err error) {
+	if err = b.allow(); err != nil {
+		return
+	}
+
+	start, panicked := b.now(), true
+
+	// result is recorded even if fn panics, otherwise probe would never end.
+	defer func() {
+		b.done(panicked || b.failed(err, checkSlow && b.now().Sub(start) > b.cfg.Slow))
+	}()
+
+	err = fn()
+	panicked = false
+
+	return err
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.state == Closed:
+		return nil
+	case b.probing, b.now().Sub(b.openedAt) < b.cfg.Cooldown:
+		return fmt.Errorf("%s: %w", b.name, ErrOpen)
+	}
+
+	b.probing = true
+	b.setState(HalfOpen)
+
+	return nil
+}
+
+func (b *Breaker) failed(err error, slow bool) bool {
+	return (err != nil && (b.cfg.IsFailure == nil || b.cfg.IsFailure(err))) || (b.cfg.Slow > 0 && slow)
+}
+
+func (b *Breaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.probing {
+		b.probing = false
+
+		if failed {
+			b.trip()
+		} else {
+			b.failures = 0
+			b.setState(Closed)
+		}
+
+		return
+	}
+
+	switch {
+	case !failed:
+		b.failures = 0
+	case b.state == Closed:
+		// calls, that were passed before breaker opened, do not prolong it.
+		if b.failures++; b.failures >= b.cfg.Failures {
+			b.trip()
+		}
+	}
+}
+
+func (b *Breaker) trip() {
+	b.openedAt = b.now()
+	b.setState(Open)
+}
+
+func (b *Breaker) setState(s State) {
+	if b.state == s {
+		return
+	}
+
+	log.Printf("breaker: %s: %s -> %s", b.name, b.state, s)
+
+	b.state = s
+}
@@ -0,0 +1,231 @@
+//nolint:testpackage
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/redis"
+)
+
+var (
+	errFail  = errors.New("test fail")
+	errFatal = errors.New("not a failure")
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) Now() time.Time { return c.t }
+
+func (c *clock) Add(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestBreaker(c *clock) *Breaker {
+	b := New("test", Config{
+		Failures:  2,
+		Slow:      time.Second,
+		Cooldown:  time.Minute,
+		IsFailure: func(err error) bool { return !errors.Is(err, errFatal) },
+	})
+
+	b.now = c.Now
+
+	return b
+}
+
+func TestBreaker(t *testing.T) {
+	c := &clock{t: time.Now()}
+	b := newTestBreaker(c)
+
+	var calls int
+
+	call := func(err error, took time.Duration) func() error {
+		return func() error {
+			calls++
+			c.Add(took)
+
+			return err
+		}
+	}
+
+	var table = []struct {
+		name      string
+		wait      time.Duration
+		fn        func() error
+		errExpect error
+		calls     int
+		state     State
+	}{
+		{"success", 0, call(nil, 0), nil, 1, Closed},
+		{"failure", 0, call(errFail, 0), errFail, 1, Closed},
+		{"non-failure error", 0, call(errFatal, 0), errFatal, 1, Closed},
+		{"failure after reset", 0, call(errFail, 0), errFail, 1, Closed},
+		{"slow call opens", 0, call(nil, 2*time.Second), nil, 1, Open},
+		{"rejected", 0, call(nil, 0), ErrOpen, 0, Open},
+		{"still rejected", 30 * time.Second, call(nil, 0), ErrOpen, 0, Open},
+		{"failed probe", 30 * time.Second, call(errFail, 0), errFail, 1, Open},
+		{"rejected after probe", 0, call(nil, 0), ErrOpen, 0, Open},
+		{"probe closes", time.Minute, call(nil, 0), nil, 1, Closed},
+		{"closed", 0, call(nil, 0), nil, 1, Closed},
+	}
+
+	for _, s := range table {
+		calls = 0
+
+		c.Add(s.wait)
+
+		if err := b.Do(s.fn); !errors.Is(err, s.errExpect) {
+			t.Fatalf("%s: err = %v (want: %v)", s.name, err, s.errExpect)
+		}
+
+		if calls != s.calls {
+			t.Fatalf("%s: calls = %d (want: %d)", s.name, calls, s.calls)
+		}
+
+		if st := b.State(); st != s.state {
+			t.Fatalf("%s: state = %s (want: %s)", s.name, st, s.state)
+		}
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	c := &clock{t: time.Now()}
+	b := newTestBreaker(c)
+
+	for i := 0; i < 2; i++ {
+		_ = b.Do(func() error { return errFail })
+	}
+
+	c.Add(time.Minute)
+
+	if st := b.State(); st != HalfOpen {
+		t.Fatalf("state = %s (want: %s)", st, HalfOpen)
+	}
+
+	err := b.Do(func() error {
+		// concurrent calls are rejected, while probe is running.
+		if err := b.Do(func() error { return nil }); !errors.Is(err, ErrOpen) {
+			t.Fatalf("concurrent call: err = %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st := b.State(); st != Closed {
+		t.Fatalf("state = %s (want: %s)", st, Closed)
+	}
+}
+
+func TestBreakerBulk(t *testing.T) {
+	c := &clock{t: time.Now()}
+	b := newTestBreaker(c)
+	b.cfg.Failures = 1
+
+	slow := func() error {
+		c.Add(time.Minute)
+
+		return nil
+	}
+
+	if err := b.DoBulk(slow); err != nil {
+		t.Fatal(err)
+	}
+
+	if st := b.State(); st != Closed {
+		t.Fatalf("slow bulk call: state = %s (want: %s)", st, Closed)
+	}
+
+	if err := b.DoBulk(func() error { return errFail }); !errors.Is(err, errFail) {
+		t.Fatalf("err = %v (want: %v)", err, errFail)
+	}
+
+	if st := b.State(); st != Open {
+		t.Fatalf("failed bulk call: state = %s (want: %s)", st, Open)
+	}
+}
+
+func TestBreakerPanic(t *testing.T) {
+	c := &clock{t: time.Now()}
+	b := newTestBreaker(c)
+	b.cfg.Failures = 1
+
+	_ = b.Do(func() error { return errFail })
+
+	c.Add(time.Minute)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic is not propagated")
+			}
+		}()
+
+		_ = b.Do(func() error { panic("probe") })
+	}()
+
+	if st := b.State(); st != Open {
+		t.Fatalf("after panic: state = %s (want: %s)", st, Open)
+	}
+
+	// panicked probe is over, so next one is let through after cooldown.
+	c.Add(time.Minute)
+
+	if err := b.Do(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if st := b.State(); st != Closed {
+		t.Fatalf("state = %s (want: %s)", st, Closed)
+	}
+}
+
+// slowReaper takes a minute to reap expired states.
+type slowReaper struct {
+	redis.Store
+	c *clock
+}
+
+func (s *slowReaper) ReapExpired(ctx context.Context, now time.Time, limit int) (claimed, dropped int, err error) {
+	s.c.Add(time.Minute)
+
+	return s.Store.ReapExpired(ctx, now, limit)
+}
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{t: time.Now()}
+	b := newTestBreaker(c)
+	b.cfg.Failures = 1
+
+	s := Redis(&slowReaper{Store: redis.NewMemory(time.Minute), c: c}, b)
+
+	if _, err := s.IsAlive(ctx, 1, "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	// reaper batches are bulk calls.
+	if _, _, err := s.ReapExpired(ctx, time.Now(), 128); err != nil {
+		t.Fatal(err)
+	}
+
+	if st := b.State(); st != Closed {
+		t.Fatalf("slow reap: state = %s (want: %s)", st, Closed)
+	}
+
+	_ = b.Do(func() error { return errFail })
+
+	if _, err := s.IsAlive(ctx, 1, "key"); !errors.Is(err, ErrOpen) {
+		t.Fatalf("err = %v (want: %v)", err, ErrOpen)
+	}
+
+	// reconcile is not guarded.
+	if _, err := s.Reconcile(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,315 @@
+package breaker
+
+import (
+	"context"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+	"github.com/s0rg/toggle-svc/pkg/redis"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+type (
+	dbStore struct {
+		s db.Store
+		b *Breaker
+	}
+
+	redisStore struct {
+		s redis.Store
+		b *Breaker
+	}
+)
+
+// DB guards store calls with breaker.
+func DB(s db.Store, b *Breaker) db.Store {
+	return &dbStore{s: s, b: b}
+}
+
+// Redis guards store calls with breaker.
+func Redis(s redis.Store, b *Breaker) redis.Store {
+	return &redisStore{s: s, b: b}
+}
+
+func (d *dbStore) AddOrg(ctx context.Context, name, apiKey string, maxApps, maxKeys int) error {
+	return d.b.Do(func() error {
+		return d.s.AddOrg(ctx, name, apiKey, maxApps, maxKeys)
+	})
+}
+
+func (d *dbStore) GetOrgID(ctx context.Context, apiKey string) (rv int64, err error) {
+	err = d.b.Do(func() (err error) {
+		rv, err = d.s.GetOrgID(ctx, apiKey)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetOrgKeys(ctx context.Context) (rv map[int64]string, err error) {
+	err = d.b.Do(func() (err error) {
+		rv, err = d.s.GetOrgKeys(ctx)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetApps(ctx context.Context, orgID int64) (rv []string, err error) {
+	err = d.b.Do(func() (err error) {
+		rv, err = d.s.GetApps(ctx, orgID)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetAppID(ctx context.Context, orgID int64, app string) (rv int64, err error) {
+	err = d.b.Do(func() (err error) {
+		rv, err = d.s.GetAppID(ctx, orgID, app)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetEnvs(ctx context.Context) (rv []string, err error) {
+	err = d.b.Do(func() (err error) {
+		rv, err = d.s.GetEnvs(ctx)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform string,
+) (rv toggle.Keys, err error) {
+	err = d.b.Do(func() (err error) {
+		rv, err = d.s.GetAppFeatures(ctx, orgID, appID, env, version, platform)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetAppKeys(
+	ctx context.Context,
+	orgID, appID int64,
+	filter toggle.KeyFilter,
+) (rv []toggle.KeyInfo, err error) {
+	err = d.b.Do(func() (err error) {
+		rv, err = d.s.GetAppKeys(ctx, orgID, appID, filter)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) EditAppKey(ctx context.Context, orgID, appID int64, key string, meta *toggle.Meta) error {
+	return d.b.Do(func() error {
+		return d.s.EditAppKey(ctx, orgID, appID, key, meta)
+	})
+}
+
+func (d *dbStore) AddApps(ctx context.Context, orgID int64, names []string) error {
+	return d.b.Do(func() error {
+		return d.s.AddApps(ctx, orgID, names)
+	})
+}
+
+func (d *dbStore) AddAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version string,
+	platforms []string,
+	features toggle.Keys,
+) error {
+	return d.b.Do(func() error {
+		return d.s.AddAppFeatures(ctx, orgID, appID, env, version, platforms, features)
+	})
+}
+
+func (d *dbStore) EditAppFeature(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform, key string,
+	rate float64,
+) error {
+	return d.b.Do(func() error {
+		return d.s.EditAppFeature(ctx, orgID, appID, env, version, platform, key, rate)
+	})
+}
+
+func (d *dbStore) PromoteAppFeatures(ctx context.Context, orgID, appID int64, from, to string) error {
+	return d.b.Do(func() error {
+		return d.s.PromoteAppFeatures(ctx, orgID, appID, from, to)
+	})
+}
+
+func (d *dbStore) GetOrgs(ctx context.Context) (rv []int64, err error) {
+	err = d.b.Do(func() (err error) {
+		rv, err = d.s.GetOrgs(ctx)
+
+		return
+	})
+
+	return
+}
+
+// GetToggles, GetSegments, MarkStale, ArchiveToggles and ApplyChanges are bulk calls of background
+// jobs and config import/export, so they are not counted as slow ones.
+func (d *dbStore) GetToggles(ctx context.Context, orgID int64) (rv []toggle.Toggle, err error) {
+	err = d.b.DoBulk(func() (err error) {
+		rv, err = d.s.GetToggles(ctx, orgID)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetSegments(ctx context.Context, orgID int64) (rv []toggle.Segment, err error) {
+	err = d.b.DoBulk(func() (err error) {
+		rv, err = d.s.GetSegments(ctx, orgID)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) MarkStale(ctx context.Context, orgID int64, ids []int64) error {
+	return d.b.DoBulk(func() error {
+		return d.s.MarkStale(ctx, orgID, ids)
+	})
+}
+
+func (d *dbStore) ArchiveToggles(ctx context.Context, orgID int64, ids []int64) (rv int64, err error) {
+	err = d.b.DoBulk(func() (err error) {
+		rv, err = d.s.ArchiveToggles(ctx, orgID, ids)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) ApplyChanges(ctx context.Context, orgID int64, changes []manifest.Change) error {
+	return d.b.DoBulk(func() error {
+		return d.s.ApplyChanges(ctx, orgID, changes)
+	})
+}
+
+func (r *redisStore) ClientsCount(ctx context.Context, seg toggle.Segment) (rv int64, err error) {
+	err = r.b.Do(func() (err error) {
+		rv, err = r.s.ClientsCount(ctx, seg)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) MarkAlive(ctx context.Context, org int64, key string) error {
+	return r.b.Do(func() error {
+		return r.s.MarkAlive(ctx, org, key)
+	})
+}
+
+func (r *redisStore) DropState(ctx context.Context, org int64, key string) error {
+	return r.b.Do(func() error {
+		return r.s.DropState(ctx, org, key)
+	})
+}
+
+func (r *redisStore) GetState(ctx context.Context, org int64, key string) (rv []int64, found bool, err error) {
+	err = r.b.Do(func() (err error) {
+		rv, found, err = r.s.GetState(ctx, org, key)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) IsAlive(ctx context.Context, org int64, key string) (rv bool, err error) {
+	err = r.b.Do(func() (err error) {
+		rv, err = r.s.IsAlive(ctx, org, key)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) TogglesAssign(ctx context.Context, seg toggle.Segment, keys toggle.Keys) (rv string, err error) {
+	err = r.b.Do(func() (err error) {
+		rv, err = r.s.TogglesAssign(ctx, seg, keys)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) TogglesCount(ctx context.Context, seg toggle.Segment, ids []int64) (rv []int64, err error) {
+	err = r.b.Do(func() (err error) {
+		rv, err = r.s.TogglesCount(ctx, seg, ids)
+
+		return
+	})
+
+	return
+}
+
+// ReapExpired, ExpiryStats and ExpiryEntries are bulk calls of background reaper and admin views,
+// so they are not counted as slow ones.
+func (r *redisStore) ReapExpired(ctx context.Context, now time.Time, limit int) (claimed, dropped int, err error) {
+	err = r.b.DoBulk(func() (err error) {
+		claimed, dropped, err = r.s.ReapExpired(ctx, now, limit)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) ExpiryStats(ctx context.Context, now time.Time) (tracked, overdue int64, err error) {
+	err = r.b.DoBulk(func() (err error) {
+		tracked, overdue, err = r.s.ExpiryStats(ctx, now)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) ExpiryEntries(ctx context.Context, offset, limit int) (rv []redis.Tracked, err error) {
+	err = r.b.DoBulk(func() (err error) {
+		rv, err = r.s.ExpiryEntries(ctx, offset, limit)
+
+		return
+	})
+
+	return
+}
+
+// Reconcile scans whole keyspace, so it is not guarded: its duration says nothing about redis health.
+func (r *redisStore) Reconcile(ctx context.Context, fix bool) (*redis.Report, error) {
+	return r.s.Reconcile(ctx, fix)
+}
+
+func (r *redisStore) SetExpiration(d time.Duration) {
+	r.s.SetExpiration(d)
+}
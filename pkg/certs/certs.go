@@ -0,0 +1,149 @@
+// Package certs loads TLS certificates, and reloads them, when they change on disk.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const checkPeriod = 10 * time.Second
+
+var errNoCerts = errors.New("certs: no certificates found")
+
+// Loader serves certificate from files, files are checked for changes at most once in
+// checkPeriod, if reload fails - previous certificate is served.
+type Loader struct {
+	certFile string
+	keyFile  string
+	period   time.Duration
+	log      zerolog.Logger
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+}
+
+// NewLoader loads certificate and key from given PEM files, reload errors are reported to log.
+func NewLoader(certFile, keyFile string, log zerolog.Logger) (l *Loader, err error) {
+	l = &Loader{certFile: certFile, keyFile: keyFile, period: checkPeriod, log: log}
+
+	if err = l.load(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// GetCertificate returns current certificate, it is suitable for tls.Config.
+func (l *Loader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now := time.Now(); now.Sub(l.checked) >= l.period {
+		l.checked = now
+
+		if l.changed() {
+			if err := l.load(); err != nil {
+				l.log.Error().Err(err).Str("cert", l.certFile).Msg("certs: reload error")
+			}
+		}
+	}
+
+	return l.cert, nil
+}
+
+// changed reports, if any of files was modified since last load.
+func (l *Loader) changed() bool {
+	return l.lastMod().After(l.modTime)
+}
+
+func (l *Loader) lastMod() (rv time.Time) {
+	for _, name := range []string{l.certFile, l.keyFile} {
+		if fi, err := os.Stat(name); err == nil && fi.ModTime().After(rv) {
+			rv = fi.ModTime()
+		}
+	}
+
+	return rv
+}
+
+func (l *Loader) load() (err error) {
+	mod := l.lastMod()
+
+	var cert tls.Certificate
+
+	if cert, err = tls.LoadX509KeyPair(l.certFile, l.keyFile); err != nil {
+		return
+	}
+
+	l.cert, l.modTime = &cert, mod
+
+	return nil
+}
+
+// Pool loads CA certificates from PEM file.
+func Pool(path string) (pool *x509.CertPool, err error) {
+	var buf []byte
+
+	if buf, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+
+	pool = x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, errNoCerts
+	}
+
+	return pool, nil
+}
+
+// ClientConfig creates client TLS config: servers are verified by CAs from `ca` file (or by
+// system ones, if it is empty), client certificate is loaded from `cert` and `key` files, if set.
+func ClientConfig(ca, cert, key string) (c *tls.Config, err error) {
+	c = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if ca != "" {
+		if c.RootCAs, err = Pool(ca); err != nil {
+			return nil, err
+		}
+	}
+
+	if cert != "" || key != "" {
+		var pair tls.Certificate
+
+		if pair, err = tls.LoadX509KeyPair(cert, key); err != nil {
+			return nil, err
+		}
+
+		c.Certificates = []tls.Certificate{pair}
+	}
+
+	return c, nil
+}
+
+// ServerConfig creates server TLS config with certificate from loader, with `clientCA` set - client
+// certificates are requested, and verified by CAs from it, if given.
+func ServerConfig(l *Loader, clientCA string) (c *tls.Config, err error) {
+	c = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: l.GetCertificate,
+	}
+
+	if clientCA != "" {
+		if c.ClientCAs, err = Pool(clientCA); err != nil {
+			return nil, err
+		}
+
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return c, nil
+}
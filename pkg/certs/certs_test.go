@@ -0,0 +1,162 @@
+//nolint:testpackage
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// writePair writes self-signed certificate for given name and its key.
+func writePair(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	for name, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: kder},
+	} {
+		if err = ioutil.WriteFile(name, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, l *Loader) string {
+	t.Helper()
+
+	c, err := l.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return x.Subject.CommonName
+}
+
+func TestLoaderReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writePair(t, dir, "first")
+
+	var logs bytes.Buffer
+
+	l, err := NewLoader(certFile, keyFile, zerolog.New(&logs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.period = 0
+
+	if cn := commonName(t, l); cn != "first" {
+		t.Fatalf("cn = %s (want: first)", cn)
+	}
+
+	writePair(t, dir, "second")
+
+	// make sure modification time changes.
+	future := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err = os.Chtimes(name, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if cn := commonName(t, l); cn != "second" {
+		t.Fatalf("cn = %s (want: second)", cn)
+	}
+
+	// broken files - previous certificate is served.
+	if err = ioutil.WriteFile(keyFile, []byte("junk"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	future = future.Add(time.Minute)
+	if err = os.Chtimes(keyFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	if cn := commonName(t, l); cn != "second" {
+		t.Fatalf("cn = %s (want: second)", cn)
+	}
+
+	if !bytes.Contains(logs.Bytes(), []byte("certs: reload error")) {
+		t.Fatalf("reload error is not logged: %q", logs.String())
+	}
+}
+
+func TestConfigs(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writePair(t, dir, "ca")
+
+	c, err := ClientConfig(certFile, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.RootCAs == nil || len(c.Certificates) != 1 {
+		t.Fatal("client config: no ca or certificate")
+	}
+
+	if _, err = ClientConfig(keyFile, "", ""); err == nil {
+		t.Fatal("no error for bad ca")
+	}
+
+	l, err := NewLoader(certFile, keyFile, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ServerConfig(l, certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.ClientCAs == nil || s.GetCertificate == nil {
+		t.Fatal("server config: no client ca or certificate")
+	}
+}
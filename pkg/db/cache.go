@@ -0,0 +1,264 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+// NotifyChannel is a postgres channel, that carries ids of organizations, changed by service replicas.
+const NotifyChannel = "toggle_svc_changes"
+
+type (
+	// Notifier tells other service replicas, that organization data was changed.
+	Notifier func(ctx context.Context, orgID int64) error
+
+	// Cache is a read-through cache of organization, app ids and features lookups in front of Store, all
+	// entries of organization are dropped on its writes, locally and, with notifier set, on other replicas.
+	Cache struct {
+		Store
+		notify   Notifier
+		mu       sync.RWMutex
+		gen      uint64
+		orgs     map[string]int64
+		apps     map[appKey]int64
+		features map[featuresKey]toggle.Keys
+	}
+
+	appKey struct {
+		org  int64
+		name string
+	}
+
+	featuresKey struct {
+		org      int64
+		app      int64
+		env      string
+		version  string
+		platform string
+	}
+)
+
+// NewCache creates cache for given store, notify may be nil, if there are no other replicas.
+func NewCache(s Store, notify Notifier) *Cache {
+	return &Cache{
+		Store:    s,
+		notify:   notify,
+		orgs:     make(map[string]int64),
+		apps:     make(map[appKey]int64),
+		features: make(map[featuresKey]toggle.Keys),
+	}
+}
+
+// PgNotifier returns notifier, that publishes changes to NotifyChannel, with organization id as payload.
+func PgNotifier(db *sql.DB) Notifier {
+	const query = `SELECT pg_notify($1, $2)`
+
+	return func(ctx context.Context, orgID int64) (err error) {
+		_, err = db.ExecContext(ctx, query, NotifyChannel, strconv.FormatInt(orgID, 10))
+
+		return
+	}
+}
+
+// Invalidate drops cached entries of given organization.
+func (c *Cache) Invalidate(orgID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+
+	for k, id := range c.orgs {
+		if id == orgID {
+			delete(c.orgs, k)
+		}
+	}
+
+	for k := range c.apps {
+		if k.org == orgID {
+			delete(c.apps, k)
+		}
+	}
+
+	for k := range c.features {
+		if k.org == orgID {
+			delete(c.features, k)
+		}
+	}
+}
+
+// Reset drops all cached entries, i.e. when notifications could be lost.
+func (c *Cache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.orgs = make(map[string]int64)
+	c.apps = make(map[appKey]int64)
+	c.features = make(map[featuresKey]toggle.Keys)
+}
+
+// OnNotify handles notification payload from other replica, malformed ones reset whole cache.
+func (c *Cache) OnNotify(payload string) {
+	orgID, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		c.Reset()
+
+		return
+	}
+
+	c.Invalidate(orgID)
+}
+
+// GetOrgID returns organization id for given api key, entries are kept by key hashes.
+func (c *Cache) GetOrgID(ctx context.Context, apiKey string) (id int64, err error) {
+	k := hashKey(apiKey)
+
+	c.mu.RLock()
+	id, ok := c.orgs[k]
+	gen := c.gen
+	c.mu.RUnlock()
+
+	if ok {
+		return id, nil
+	}
+
+	if id, err = c.Store.GetOrgID(ctx, apiKey); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	if c.gen == gen {
+		c.orgs[k] = id
+	}
+	c.mu.Unlock()
+
+	return id, nil
+}
+
+// GetAppID returns id for given app name.
+func (c *Cache) GetAppID(
+	ctx context.Context,
+	orgID int64,
+	app string,
+) (id int64, err error) {
+	k := appKey{org: orgID, name: strings.ToLower(app)}
+
+	c.mu.RLock()
+	id, ok := c.apps[k]
+	gen := c.gen
+	c.mu.RUnlock()
+
+	if ok {
+		return id, nil
+	}
+
+	if id, err = c.Store.GetAppID(ctx, orgID, app); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	// entry may be already invalidated, while we were querying store.
+	if c.gen == gen {
+		c.apps[k] = id
+	}
+	c.mu.Unlock()
+
+	return id, nil
+}
+
+// GetAppFeatures returns slice of toggled features for given params, callers own returned slice.
+func (c *Cache) GetAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform string,
+) (rv toggle.Keys, err error) {
+	k := featuresKey{org: orgID, app: appID, env: env, version: version, platform: platform}
+
+	c.mu.RLock()
+	rv, ok := c.features[k]
+	gen := c.gen
+	c.mu.RUnlock()
+
+	if ok {
+		return append(toggle.Keys(nil), rv...), nil
+	}
+
+	if rv, err = c.Store.GetAppFeatures(ctx, orgID, appID, env, version, platform); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	if c.gen == gen {
+		c.features[k] = append(toggle.Keys(nil), rv...)
+	}
+	c.mu.Unlock()
+
+	return rv, nil
+}
+
+// AddApps adds new app names.
+func (c *Cache) AddApps(ctx context.Context, orgID int64, names []string) error {
+	return c.changed(ctx, orgID, c.Store.AddApps(ctx, orgID, names))
+}
+
+// AddAppFeatures adds new version, platforms and toggles for given app and environment.
+func (c *Cache) AddAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version string,
+	platforms []string,
+	features toggle.Keys,
+) error {
+	return c.changed(ctx, orgID, c.Store.AddAppFeatures(ctx, orgID, appID, env, version, platforms, features))
+}
+
+// EditAppFeature modifies rate for selected key.
+func (c *Cache) EditAppFeature(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform, key string,
+	rate float64,
+) error {
+	return c.changed(ctx, orgID, c.Store.EditAppFeature(ctx, orgID, appID, env, version, platform, key, rate))
+}
+
+// PromoteAppFeatures copies versions, platforms and toggle rates of given app
+// from one environment to another, overwriting rates already present in target.
+func (c *Cache) PromoteAppFeatures(ctx context.Context, orgID, appID int64, from, to string) error {
+	return c.changed(ctx, orgID, c.Store.PromoteAppFeatures(ctx, orgID, appID, from, to))
+}
+
+// ArchiveToggles moves given toggles of organization to archive, returns number of archived ones.
+func (c *Cache) ArchiveToggles(ctx context.Context, orgID int64, ids []int64) (n int64, err error) {
+	n, err = c.Store.ArchiveToggles(ctx, orgID, ids)
+
+	return n, c.changed(ctx, orgID, err)
+}
+
+// ApplyChanges applies manifest changes for organization.
+func (c *Cache) ApplyChanges(ctx context.Context, orgID int64, changes []manifest.Change) error {
+	return c.changed(ctx, orgID, c.Store.ApplyChanges(ctx, orgID, changes))
+}
+
+// changed invalidates organization entries after write, that ended with `err`, notification
+// error is reported only for successful writes.
+func (c *Cache) changed(ctx context.Context, orgID int64, err error) error {
+	c.Invalidate(orgID)
+
+	if c.notify == nil {
+		return err
+	}
+
+	if nerr := c.notify(ctx, orgID); nerr != nil && err == nil {
+		return fmt.Errorf("cache: notify: %w", nerr)
+	}
+
+	return err
+}
@@ -0,0 +1,160 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+func TestCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	base := db.NewMemory()
+
+	// replica `b` receives notifications from `a`, as with postgres LISTEN/NOTIFY.
+	b := db.NewCache(base, nil)
+	a := db.NewCache(base, func(_ context.Context, orgID int64) error {
+		b.Invalidate(orgID)
+
+		return nil
+	})
+
+	must := func(err error) {
+		t.Helper()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rate := func(c *db.Cache, appID int64) float64 {
+		t.Helper()
+
+		keys, err := c.GetAppFeatures(ctx, 1, appID, "dev", "1.0", "ios")
+		must(err)
+
+		if len(keys) != 1 {
+			t.Fatalf("keys: %v", keys)
+		}
+
+		return keys[0].Rate
+	}
+
+	must(a.AddOrg(ctx, "acme", "acme-key", 0, 0))
+	must(a.AddApps(ctx, 1, []string{"app"}))
+
+	appID, err := b.GetAppID(ctx, 1, "app")
+	must(err)
+
+	must(a.AddAppFeatures(ctx, 1, appID, "dev", "1.0", []string{"ios"}, toggle.Keys{{Name: "key", Rate: 0.5}}))
+
+	if r := rate(b, appID); r != 0.5 {
+		t.Fatalf("rate = %v (want: 0.5)", r)
+	}
+
+	// callers may modify keys, cached ones must stay intact.
+	keys, err := b.GetAppFeatures(ctx, 1, appID, "dev", "1.0", "ios")
+	must(err)
+	keys.EnableByID(nil)
+
+	if r := rate(b, appID); r != 0.5 {
+		t.Fatalf("rate after modification = %v (want: 0.5)", r)
+	}
+
+	// write from other replica is seen after notification.
+	must(a.EditAppFeature(ctx, 1, appID, "dev", "1.0", "ios", "key", 0.25))
+
+	if r := rate(b, appID); r != 0.25 {
+		t.Fatalf("rate after notify = %v (want: 0.25)", r)
+	}
+
+	// writes, that bypass caches, are not seen until invalidation.
+	must(base.EditAppFeature(ctx, 1, appID, "dev", "1.0", "ios", "key", 1))
+
+	if r := rate(b, appID); r != 0.25 {
+		t.Fatalf("rate from cache = %v (want: 0.25)", r)
+	}
+
+	b.OnNotify("2")
+
+	if r := rate(b, appID); r != 0.25 {
+		t.Fatalf("rate after other org notify = %v (want: 0.25)", r)
+	}
+
+	b.OnNotify("garbage")
+
+	if r := rate(b, appID); r != 1 {
+		t.Fatalf("rate after reset = %v (want: 1)", r)
+	}
+}
+
+func TestCacheNotifyError(t *testing.T) {
+	ctx := context.Background()
+	errNotify := errors.New("notify")
+
+	c := db.NewCache(db.NewMemory(), func(context.Context, int64) error {
+		return errNotify
+	})
+
+	if err := c.AddOrg(ctx, "acme", "acme-key", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.AddApps(ctx, 1, []string{"app"}); !errors.Is(err, errNotify) {
+		t.Fatalf("err = %v (want: %v)", err, errNotify)
+	}
+
+	// failed writes report their own errors.
+	if err := c.AddApps(ctx, 1, []string{"app"}); err == nil || errors.Is(err, errNotify) {
+		t.Fatalf("duplicate app: err = %v", err)
+	}
+}
+
+// countingStore counts organization lookups.
+type countingStore struct {
+	db.Store
+	orgs int
+}
+
+func (s *countingStore) GetOrgID(ctx context.Context, apiKey string) (int64, error) {
+	s.orgs++
+
+	return s.Store.GetOrgID(ctx, apiKey)
+}
+
+func TestCacheOrgs(t *testing.T) {
+	ctx := context.Background()
+	base := &countingStore{Store: db.NewMemory()}
+	c := db.NewCache(base, nil)
+
+	if err := c.AddOrg(ctx, "acme", "acme-key", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(key string, calls int) {
+		t.Helper()
+
+		if _, err := c.GetOrgID(ctx, key); err != nil && key == "acme-key" {
+			t.Fatal(err)
+		}
+
+		if base.orgs != calls {
+			t.Fatalf("%s: store calls = %d (want: %d)", key, base.orgs, calls)
+		}
+	}
+
+	lookup("acme-key", 1)
+	lookup("acme-key", 1)
+
+	// unknown keys are not cached.
+	lookup("unknown", 2)
+	lookup("unknown", 3)
+
+	c.Invalidate(2)
+	lookup("acme-key", 3)
+
+	c.Invalidate(1)
+	lookup("acme-key", 4)
+}
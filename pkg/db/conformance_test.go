@@ -0,0 +1,123 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	// drivers.
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/db/dbtest"
+)
+
+// envPostgresDSN holds dsn of postgres database, used for tests, all its tables will be dropped.
+const envPostgresDSN = "TOGGLE_TEST_POSTGRES"
+
+func TestMemoryStore(t *testing.T) {
+	dbtest.Run(t, func(*testing.T) db.Store {
+		return db.NewMemory()
+	})
+}
+
+func TestCachedStore(t *testing.T) {
+	dbtest.Run(t, func(*testing.T) db.Store {
+		return db.NewCache(db.NewMemory(), nil)
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) db.Store {
+		conn, err := sql.Open("sqlite", ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// every connection to ":memory:" opens new database.
+		conn.SetMaxOpenConns(1)
+
+		t.Cleanup(func() { _ = conn.Close() })
+
+		return migrated(t, conn, db.DialectSQLite)
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv(envPostgresDSN)
+	if dsn == "" {
+		t.Skipf("%s is not set", envPostgresDSN)
+	}
+
+	dbtest.Run(t, func(t *testing.T) db.Store {
+		conn, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { _ = conn.Close() })
+
+		dropTables(t, conn)
+
+		return migrated(t, conn, db.DialectPostgres)
+	})
+}
+
+func migrated(t *testing.T, conn *sql.DB, dialect string) db.Store {
+	t.Helper()
+
+	if db.CheckSchema(context.Background(), conn) == nil {
+		t.Fatal("schema check passed before migrations")
+	}
+
+	// second run must be no-op.
+	for i := 0; i < 2; i++ {
+		if err := db.Migrate(context.Background(), conn, dialect); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.CheckSchema(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+
+	return db.New(conn)
+}
+
+func dropTables(t *testing.T, conn *sql.DB) {
+	t.Helper()
+
+	const query = `SELECT tablename FROM pg_tables WHERE schemaname = current_schema()`
+
+	rows, err := conn.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tables []string
+
+	for rows.Next() {
+		var name string
+
+		if err = rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+
+		tables = append(tables, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tables) == 0 {
+		return
+	}
+
+	if _, err = conn.Exec("DROP TABLE " + strings.Join(tables, ", ") + " CASCADE"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,639 @@
+// Package dbtest holds conformance test suite for db.Store implementations.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+// Factory returns new empty store, it is called once for every test.
+type Factory func(t *testing.T) db.Store
+
+type suite struct {
+	t   *testing.T
+	ctx context.Context
+	s   db.Store
+}
+
+// Run runs conformance suite against stores, created by given factory.
+func Run(t *testing.T, newStore Factory) {
+	t.Helper()
+
+	for _, tc := range []struct {
+		name string
+		fn   func(*suite)
+	}{
+		{"Orgs", testOrgs},
+		{"Apps", testApps},
+		{"Envs", testEnvs},
+		{"Features", testFeatures},
+		{"Edits", testEdits},
+		{"Keys", testKeys},
+		{"Promote", testPromote},
+		{"Stale", testStale},
+		{"Changes", testChanges},
+		{"Quotas", testQuotas},
+		{"Concurrent", testConcurrent},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(&suite{t: t, ctx: context.Background(), s: newStore(t)})
+		})
+	}
+}
+
+func (s *suite) must(err error) {
+	s.t.Helper()
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// org creates organization with given quotas, and returns its id.
+func (s *suite) org(name string, maxApps, maxKeys int) int64 {
+	s.t.Helper()
+
+	s.must(s.s.AddOrg(s.ctx, name, name+"-key", maxApps, maxKeys))
+
+	id, err := s.s.GetOrgID(s.ctx, name+"-key")
+	s.must(err)
+
+	return id
+}
+
+// app creates app in organization, and returns its id.
+func (s *suite) app(orgID int64, name string) int64 {
+	s.t.Helper()
+
+	s.must(s.s.AddApps(s.ctx, orgID, []string{name}))
+
+	id, err := s.s.GetAppID(s.ctx, orgID, name)
+	s.must(err)
+
+	return id
+}
+
+// features returns enabled features names and rates for segment.
+func (s *suite) features(orgID, appID int64, env, version, platform string) map[string]float64 {
+	s.t.Helper()
+
+	keys, err := s.s.GetAppFeatures(s.ctx, orgID, appID, env, version, platform)
+	s.must(err)
+
+	rv := make(map[string]float64)
+
+	for _, k := range keys {
+		rv[k.Name] = k.Rate
+	}
+
+	return rv
+}
+
+func (s *suite) expect(what string, got, want interface{}) {
+	s.t.Helper()
+
+	if !reflect.DeepEqual(got, want) {
+		s.t.Fatalf("%s: got %v (want: %v)", what, got, want)
+	}
+}
+
+func testOrgs(s *suite) {
+	a := s.org("Alpha", 0, 0)
+	b := s.org("beta", 0, 0)
+
+	if a == b {
+		s.t.Fatal("same ids for different orgs")
+	}
+
+	if err := s.s.AddOrg(s.ctx, "ALPHA", "other-key", 0, 0); !errors.Is(err, db.ErrConflict) {
+		s.t.Fatalf("duplicate org name: %v", err)
+	}
+
+	if err := s.s.AddOrg(s.ctx, "gamma", "beta-key", 0, 0); !errors.Is(err, db.ErrConflict) {
+		s.t.Fatalf("duplicate api key: %v", err)
+	}
+
+	if _, err := s.s.GetOrgID(s.ctx, "unknown"); !errors.Is(err, sql.ErrNoRows) {
+		s.t.Fatalf("unknown key: %v", err)
+	}
+
+	ids, err := s.s.GetOrgs(s.ctx)
+	s.must(err)
+
+	want := []int64{a, b}
+	if a > b {
+		want = []int64{b, a}
+	}
+
+	s.expect("orgs", ids, want)
+
+	hashes, err := s.s.GetOrgKeys(s.ctx)
+	s.must(err)
+
+	if len(hashes) != 2 || hashes[a] == "" || hashes[a] == hashes[b] || hashes[a] == "alpha-key" {
+		s.t.Fatalf("org keys: %v", hashes)
+	}
+}
+
+func testApps(s *suite) {
+	a := s.org("alpha", 0, 0)
+	b := s.org("beta", 0, 0)
+
+	s.must(s.s.AddApps(s.ctx, a, []string{"Web", "ios"}))
+	s.must(s.s.AddApps(s.ctx, b, []string{"web"}))
+
+	if err := s.s.AddApps(s.ctx, a, []string{"android", "WEB"}); !errors.Is(err, db.ErrConflict) {
+		s.t.Fatalf("duplicate app: %v", err)
+	}
+
+	apps, err := s.s.GetApps(s.ctx, a)
+	s.must(err)
+	sort.Strings(apps)
+	s.expect("apps", apps, []string{"ios", "web"})
+
+	apps, err = s.s.GetApps(s.ctx, b)
+	s.must(err)
+	s.expect("other org apps", apps, []string{"web"})
+
+	idA, err := s.s.GetAppID(s.ctx, a, "WEB")
+	s.must(err)
+
+	idB, err := s.s.GetAppID(s.ctx, b, "web")
+	s.must(err)
+
+	if idA == idB {
+		s.t.Fatal("same app ids in different orgs")
+	}
+
+	if _, err = s.s.GetAppID(s.ctx, b, "ios"); !errors.Is(err, sql.ErrNoRows) {
+		s.t.Fatalf("app of other org: %v", err)
+	}
+}
+
+func testEnvs(s *suite) {
+	envs, err := s.s.GetEnvs(s.ctx)
+	s.must(err)
+	s.expect("envs", envs, []string{"dev", "staging", "production"})
+}
+
+func testFeatures(s *suite) {
+	org := s.org("alpha", 0, 0)
+	other := s.org("beta", 0, 0)
+	app := s.app(org, "web")
+
+	keys := toggle.Keys{
+		{Name: "full", Rate: 1},
+		{Name: "part", Rate: 0.333},
+		{Name: "off", Rate: 0},
+	}
+
+	s.must(s.s.AddAppFeatures(s.ctx, org, app, "dev", "1.0", []string{"ie6", "chrome"}, keys))
+
+	want := map[string]float64{"full": 1, "part": 0.33}
+
+	s.expect("ie6 features", s.features(org, app, "dev", "1.0", "ie6"), want)
+	s.expect("chrome features", s.features(org, app, "dev", "1.0", "chrome"), want)
+	s.expect("other env", s.features(org, app, "staging", "1.0", "ie6"), map[string]float64{})
+	s.expect("other version", s.features(org, app, "dev", "2.0", "ie6"), map[string]float64{})
+	s.expect("other org", s.features(other, app, "dev", "1.0", "ie6"), map[string]float64{})
+
+	if s.s.AddAppFeatures(s.ctx, org, app, "dev", "1.0", []string{"ie6"}, keys) == nil {
+		s.t.Fatal("duplicate segment: no error")
+	}
+
+	if err := s.s.AddAppFeatures(s.ctx, other, app, "dev", "2.0", []string{"ie6"}, keys); !errors.Is(err, sql.ErrNoRows) {
+		s.t.Fatalf("app of other org: %v", err)
+	}
+
+	// keys are shared between versions.
+	s.must(s.s.AddAppFeatures(s.ctx, org, app, "dev", "2.0", []string{"ie6"}, toggle.Keys{{Name: "full", Rate: 0.5}}))
+	s.expect("new version", s.features(org, app, "dev", "2.0", "ie6"), map[string]float64{"full": 0.5})
+
+	info, err := s.s.GetAppKeys(s.ctx, org, app, toggle.KeyFilter{})
+	s.must(err)
+	s.expect("keys count", len(info), 3)
+}
+
+func testEdits(s *suite) {
+	org := s.org("alpha", 0, 0)
+	other := s.org("beta", 0, 0)
+	app := s.app(org, "web")
+
+	s.must(s.s.AddAppFeatures(s.ctx, org, app, "dev", "1.0", []string{"ie6", "chrome"}, toggle.Keys{
+		{Name: "one", Rate: 1},
+		{Name: "two", Rate: 0},
+	}))
+
+	s.must(s.s.EditAppFeature(s.ctx, org, app, "dev", "1.0", "ie6", "one", 0))
+	s.must(s.s.EditAppFeature(s.ctx, org, app, "dev", "1.0", "ie6", "two", 0.25))
+
+	s.expect("edited", s.features(org, app, "dev", "1.0", "ie6"), map[string]float64{"two": 0.25})
+	s.expect("untouched", s.features(org, app, "dev", "1.0", "chrome"), map[string]float64{"one": 1})
+
+	for _, tc := range []struct {
+		org                         int64
+		env, ver, platform, keyName string
+	}{
+		{org, "dev", "1.0", "ie6", "three"},
+		{org, "dev", "1.0", "safari", "one"},
+		{org, "staging", "1.0", "ie6", "one"},
+		{other, "dev", "1.0", "ie6", "one"},
+	} {
+		err := s.s.EditAppFeature(s.ctx, tc.org, app, tc.env, tc.ver, tc.platform, tc.keyName, 1)
+		if !errors.Is(err, sql.ErrNoRows) {
+			s.t.Fatalf("edit %+v: %v", tc, err)
+		}
+	}
+}
+
+func testKeys(s *suite) {
+	org := s.org("alpha", 0, 0)
+	other := s.org("beta", 0, 0)
+	app := s.app(org, "web")
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	s.must(s.s.AddAppFeatures(s.ctx, org, app, "dev", "1.0", []string{"ie6"}, toggle.Keys{
+		{Name: "b-key", Rate: 1, Meta: &toggle.Meta{
+			Description: "second",
+			Owner:       "team-a",
+			Tags:        []string{"UI", "checkout"},
+			Link:        "https://tracker/1",
+			Stage:       toggle.StageExperiment,
+			CreatedBy:   "bob",
+			ExpiresAt:   &expires,
+		}},
+		{Name: "a-key", Rate: 1, Meta: &toggle.Meta{Owner: "team-b", Tags: []string{"ui"}}},
+		{Name: "c-key", Rate: 1},
+	}))
+
+	all, err := s.s.GetAppKeys(s.ctx, org, app, toggle.KeyFilter{})
+	s.must(err)
+
+	names := make([]string, len(all))
+	for i := range all {
+		names[i] = all[i].Name
+	}
+
+	s.expect("keys order", names, []string{"a-key", "b-key", "c-key"})
+
+	b := all[1]
+	s.expect("description", b.Description, "second")
+	s.expect("owner", b.Owner, "team-a")
+	s.expect("tags", b.Tags, []string{"checkout", "ui"})
+	s.expect("link", b.Link, "https://tracker/1")
+	s.expect("stage", b.Stage, toggle.StageExperiment)
+	s.expect("author", b.CreatedBy, "bob")
+
+	if b.ExpiresAt == nil || !b.ExpiresAt.Equal(expires) {
+		s.t.Fatalf("expires_at: %v (want: %v)", b.ExpiresAt, expires)
+	}
+
+	if b.CreatedAt.IsZero() {
+		s.t.Fatal("created_at is not set")
+	}
+
+	s.expect("default stage", all[2].Stage, toggle.StageRelease)
+	s.expect("no tags", len(all[2].Tags), 0)
+
+	for _, tc := range []struct {
+		filter toggle.KeyFilter
+		want   int
+	}{
+		{toggle.KeyFilter{Tag: "ui"}, 2},
+		{toggle.KeyFilter{Tag: "ui", Owner: "team-a"}, 1},
+		{toggle.KeyFilter{Stage: toggle.StageRelease}, 2},
+		{toggle.KeyFilter{Owner: "nobody"}, 0},
+	} {
+		got, err := s.s.GetAppKeys(s.ctx, org, app, tc.filter)
+		s.must(err)
+		s.expect(fmt.Sprintf("filter %+v", tc.filter), len(got), tc.want)
+	}
+
+	s.must(s.s.EditAppKey(s.ctx, org, app, "b-key", &toggle.Meta{
+		Description: "edited",
+		Tags:        []string{"new"},
+		Stage:       toggle.StageOps,
+	}))
+
+	got, err := s.s.GetAppKeys(s.ctx, org, app, toggle.KeyFilter{Tag: "new"})
+	s.must(err)
+
+	if len(got) != 1 {
+		s.t.Fatalf("edited keys: %+v", got)
+	}
+
+	s.expect("edited description", got[0].Description, "edited")
+	s.expect("edited owner", got[0].Owner, "")
+	s.expect("edited tags", got[0].Tags, []string{"new"})
+	s.expect("edited stage", got[0].Stage, toggle.StageOps)
+	s.expect("kept author", got[0].CreatedBy, "bob")
+
+	if got[0].ExpiresAt != nil {
+		s.t.Fatalf("expires_at is not cleared: %v", got[0].ExpiresAt)
+	}
+
+	// features of new version do not change meta of existing keys.
+	s.must(s.s.AddAppFeatures(s.ctx, org, app, "dev", "2.0", []string{"ie6"}, toggle.Keys{
+		{Name: "b-key", Rate: 1, Meta: &toggle.Meta{Tags: []string{"other"}}},
+	}))
+
+	if got, _ = s.s.GetAppKeys(s.ctx, org, app, toggle.KeyFilter{Tag: "other"}); len(got) != 0 {
+		s.t.Fatalf("existing key tagged: %+v", got)
+	}
+
+	if err = s.s.EditAppKey(s.ctx, org, app, "d-key", nil); !errors.Is(err, sql.ErrNoRows) {
+		s.t.Fatalf("edit of missing key: %v", err)
+	}
+
+	if err = s.s.EditAppKey(s.ctx, other, app, "a-key", nil); !errors.Is(err, sql.ErrNoRows) {
+		s.t.Fatalf("edit of other org key: %v", err)
+	}
+
+	if got, _ = s.s.GetAppKeys(s.ctx, other, app, toggle.KeyFilter{}); len(got) != 0 {
+		s.t.Fatalf("keys of other org: %+v", got)
+	}
+}
+
+func testPromote(s *suite) {
+	org := s.org("alpha", 0, 0)
+	app := s.app(org, "web")
+
+	s.must(s.s.AddAppFeatures(s.ctx, org, app, "dev", "1.0", []string{"ie6", "chrome"}, toggle.Keys{
+		{Name: "one", Rate: 1},
+		{Name: "two", Rate: 0.5},
+	}))
+
+	s.must(s.s.AddAppFeatures(s.ctx, org, app, "staging", "1.0", []string{"ie6"}, toggle.Keys{
+		{Name: "one", Rate: 0.1},
+	}))
+
+	s.must(s.s.PromoteAppFeatures(s.ctx, org, app, "dev", "staging"))
+
+	want := map[string]float64{"one": 1, "two": 0.5}
+
+	s.expect("overwritten", s.features(org, app, "staging", "1.0", "ie6"), want)
+	s.expect("copied", s.features(org, app, "staging", "1.0", "chrome"), want)
+	s.expect("source", s.features(org, app, "dev", "1.0", "chrome"), want)
+
+	// promotion is idempotent.
+	s.must(s.s.PromoteAppFeatures(s.ctx, org, app, "dev", "staging"))
+	s.expect("repeated", s.features(org, app, "staging", "1.0", "chrome"), want)
+}
+
+func testStale(s *suite) {
+	org := s.org("alpha", 0, 0)
+	other := s.org("beta", 0, 0)
+	app := s.app(org, "web")
+
+	s.must(s.s.AddAppFeatures(s.ctx, org, app, "dev", "1.0", []string{"ie6"}, toggle.Keys{
+		{Name: "one", Rate: 1},
+		{Name: "two", Rate: 0},
+		{Name: "three", Rate: 0.5},
+	}))
+
+	all, err := s.s.GetToggles(s.ctx, org)
+	s.must(err)
+
+	if len(all) != 3 {
+		s.t.Fatalf("toggles: %+v", all)
+	}
+
+	for i, tg := range all {
+		if i > 0 && all[i-1].ID >= tg.ID {
+			s.t.Fatal("toggles are not ordered by id")
+		}
+
+		if tg.App != "web" || tg.Env != "dev" || tg.Version != "1.0" || tg.Platform != "ie6" {
+			s.t.Fatalf("toggle: %+v", tg)
+		}
+
+		if tg.UpdatedAt.IsZero() || tg.StaleAt != nil {
+			s.t.Fatalf("toggle times: %+v", tg)
+		}
+	}
+
+	if got, _ := s.s.GetToggles(s.ctx, other); len(got) != 0 {
+		s.t.Fatalf("toggles of other org: %+v", got)
+	}
+
+	s.must(s.s.MarkStale(s.ctx, org, []int64{all[0].ID, all[1].ID}))
+	s.must(s.s.MarkStale(s.ctx, other, nil))
+
+	stale, err := s.s.GetToggles(s.ctx, org)
+	s.must(err)
+
+	if stale[0].StaleAt == nil || stale[1].StaleAt == nil || stale[2].StaleAt != nil {
+		s.t.Fatalf("stale flags: %+v", stale)
+	}
+
+	since := *stale[1].StaleAt
+
+	s.must(s.s.MarkStale(s.ctx, org, []int64{all[1].ID}))
+
+	stale, err = s.s.GetToggles(s.ctx, org)
+	s.must(err)
+
+	// flag time is kept for toggles, that are still stale.
+	if stale[0].StaleAt != nil || stale[1].StaleAt == nil || !stale[1].StaleAt.Equal(since) {
+		s.t.Fatalf("stale flags after re-check: %+v", stale)
+	}
+
+	n, err := s.s.ArchiveToggles(s.ctx, other, []int64{all[0].ID})
+	s.must(err)
+	s.expect("archived by other org", n, int64(0))
+
+	n, err = s.s.ArchiveToggles(s.ctx, org, []int64{all[0].ID, all[1].ID, all[1].ID + 1000})
+	s.must(err)
+	s.expect("archived", n, int64(2))
+
+	left, err := s.s.GetToggles(s.ctx, org)
+	s.must(err)
+
+	if len(left) != 1 || left[0].ID != all[2].ID {
+		s.t.Fatalf("left toggles: %+v", left)
+	}
+
+	s.expect("features after archive", s.features(org, app, "dev", "1.0", "ie6"), map[string]float64{"three": 0.5})
+}
+
+func testChanges(s *suite) {
+	org := s.org("alpha", 0, 0)
+
+	s.must(s.s.ApplyChanges(s.ctx, org, []manifest.Change{
+		{Op: manifest.OpCreate, Kind: manifest.KindApp, App: "web"},
+		{Op: manifest.OpCreate, Kind: manifest.KindApp, App: "ios"},
+		{Op: manifest.OpCreate, Kind: manifest.KindKey, App: "web", Key: "one", Meta: &toggle.Meta{Owner: "team"}},
+		{Op: manifest.OpCreate, Kind: manifest.KindKey, App: "web", Key: "two"},
+		{Op: manifest.OpCreate, Kind: manifest.KindSegment, App: "web", Env: "dev", Version: "1.0", Platform: "ie6"},
+		{Op: manifest.OpCreate, Kind: manifest.KindToggle, App: "web", Key: "one", Env: "dev", Version: "1.0", Platform: "ie6", Rate: 1},
+		{Op: manifest.OpCreate, Kind: manifest.KindToggle, App: "web", Key: "two", Env: "dev", Version: "1.0", Platform: "ie6", Rate: 0.5},
+	}))
+
+	app, err := s.s.GetAppID(s.ctx, org, "web")
+	s.must(err)
+
+	s.expect("created", s.features(org, app, "dev", "1.0", "ie6"), map[string]float64{"one": 1, "two": 0.5})
+
+	// segments may have no toggles, so their creation is idempotent.
+	s.must(s.s.ApplyChanges(s.ctx, org, []manifest.Change{
+		{Op: manifest.OpCreate, Kind: manifest.KindSegment, App: "web", Env: "dev", Version: "1.0", Platform: "ie6"},
+		{Op: manifest.OpCreate, Kind: manifest.KindSegment, App: "web", Env: "dev", Version: "2.0", Platform: "ie6"},
+	}))
+
+	segs, err := s.s.GetSegments(s.ctx, org)
+	s.must(err)
+	s.expect("segments", segs, []toggle.Segment{
+		{Org: org, App: "web", Env: "dev", Version: "1.0", Platform: "ie6"},
+		{Org: org, App: "web", Env: "dev", Version: "2.0", Platform: "ie6"},
+	})
+
+	s.must(s.s.ApplyChanges(s.ctx, org, []manifest.Change{
+		{Op: manifest.OpDelete, Kind: manifest.KindApp, App: "ios"},
+		{Op: manifest.OpDelete, Kind: manifest.KindToggle, App: "web", Key: "two", Env: "dev", Version: "1.0", Platform: "ie6"},
+		{Op: manifest.OpUpdate, Kind: manifest.KindToggle, App: "web", Key: "one", Env: "dev", Version: "1.0", Platform: "ie6", Rate: 0.2},
+		{Op: manifest.OpUpdate, Kind: manifest.KindKey, App: "web", Key: "one", Meta: &toggle.Meta{
+			Owner:     "other",
+			CreatedBy: "carol",
+		}},
+	}))
+
+	s.expect("updated", s.features(org, app, "dev", "1.0", "ie6"), map[string]float64{"one": 0.2})
+
+	apps, err := s.s.GetApps(s.ctx, org)
+	s.must(err)
+	s.expect("apps", apps, []string{"web"})
+
+	keys, err := s.s.GetAppKeys(s.ctx, org, app, toggle.KeyFilter{Owner: "other"})
+	s.must(err)
+	s.expect("updated keys", len(keys), 1)
+	s.expect("updated author", keys[0].CreatedBy, "carol")
+
+	// failed change rolls back all previous ones.
+	err = s.s.ApplyChanges(s.ctx, org, []manifest.Change{
+		{Op: manifest.OpCreate, Kind: manifest.KindApp, App: "android"},
+		{Op: manifest.OpDelete, Kind: manifest.KindKey, App: "web", Key: "one"},
+		{Op: manifest.OpCreate, Kind: manifest.KindToggle, App: "web", Key: "one", Env: "dev", Version: "9", Platform: "x", Rate: 1},
+	})
+	if err == nil {
+		s.t.Fatal("toggle for missing segment: no error")
+	}
+
+	apps, err = s.s.GetApps(s.ctx, org)
+	s.must(err)
+	s.expect("apps after rollback", apps, []string{"web"})
+	s.expect("features after rollback", s.features(org, app, "dev", "1.0", "ie6"), map[string]float64{"one": 0.2})
+
+	s.must(s.s.ApplyChanges(s.ctx, org, []manifest.Change{
+		{Op: manifest.OpDelete, Kind: manifest.KindSegment, App: "web", Env: "dev", Version: "1.0", Platform: "ie6"},
+		{Op: manifest.OpDelete, Kind: manifest.KindKey, App: "web", Key: "one"},
+	}))
+
+	keys, err = s.s.GetAppKeys(s.ctx, org, app, toggle.KeyFilter{})
+	s.must(err)
+	s.expect("keys after delete", len(keys), 1)
+	s.expect("features after delete", s.features(org, app, "dev", "1.0", "ie6"), map[string]float64{})
+}
+
+func testQuotas(s *suite) {
+	org := s.org("alpha", 2, 2)
+
+	if err := s.s.AddApps(s.ctx, org, []string{"a", "b", "c"}); !errors.Is(err, db.ErrQuotaExceeded) {
+		s.t.Fatalf("apps quota: %v", err)
+	}
+
+	apps, err := s.s.GetApps(s.ctx, org)
+	s.must(err)
+	s.expect("apps after failed add", len(apps), 0)
+
+	app := s.app(org, "a")
+
+	s.must(s.s.AddAppFeatures(s.ctx, org, app, "dev", "1.0", []string{"ie6"}, toggle.Keys{{Name: "one", Rate: 1}}))
+
+	err = s.s.AddAppFeatures(s.ctx, org, app, "dev", "2.0", []string{"ie6"}, toggle.Keys{
+		{Name: "one", Rate: 1},
+		{Name: "two", Rate: 1},
+		{Name: "three", Rate: 1},
+	})
+	if !errors.Is(err, db.ErrQuotaExceeded) {
+		s.t.Fatalf("keys quota: %v", err)
+	}
+
+	keys, err := s.s.GetAppKeys(s.ctx, org, app, toggle.KeyFilter{})
+	s.must(err)
+	s.expect("keys after failed add", len(keys), 1)
+
+	err = s.s.ApplyChanges(s.ctx, org, []manifest.Change{
+		{Op: manifest.OpCreate, Kind: manifest.KindApp, App: "b"},
+		{Op: manifest.OpCreate, Kind: manifest.KindApp, App: "c"},
+	})
+	if !errors.Is(err, db.ErrQuotaExceeded) {
+		s.t.Fatalf("changes quota: %v", err)
+	}
+
+	// unlimited org.
+	free := s.org("beta", 0, 0)
+	s.must(s.s.AddApps(s.ctx, free, []string{"a", "b", "c", "d"}))
+}
+
+func testConcurrent(s *suite) {
+	const workers = 8
+
+	org := s.org("alpha", 0, 0)
+	app := s.app(org, "web")
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, workers*2)
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+
+			errs <- s.s.AddAppFeatures(s.ctx, org, app, "dev", fmt.Sprintf("1.%d", i), []string{"ie6"}, toggle.Keys{
+				{Name: "shared", Rate: 1},
+				{Name: fmt.Sprintf("own-%d", i), Rate: 1},
+			})
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := s.s.GetAppKeys(s.ctx, org, app, toggle.KeyFilter{})
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		s.must(err)
+	}
+
+	keys, err := s.s.GetAppKeys(s.ctx, org, app, toggle.KeyFilter{})
+	s.must(err)
+	s.expect("keys", len(keys), workers+1)
+
+	for i := 0; i < workers; i++ {
+		s.expect("features", s.features(org, app, "dev", fmt.Sprintf("1.%d", i), "ie6"), map[string]float64{
+			"shared":                 1,
+			fmt.Sprintf("own-%d", i): 1,
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+// GetAppKeys returns app keys with their meta, filtered by given filter.
+func (s *store) GetAppKeys(
+	ctx context.Context,
+	orgID, appID int64,
+	filter toggle.KeyFilter,
+) (rv []toggle.KeyInfo, err error) {
+	const (
+		queryHead = `
+SELECT
+	k.id, k.key, k.description, k.owner, k.link, k.stage, k.created_by, k.created_at, k.expires_at
+FROM
+	apps_features_keys k
+JOIN
+	apps a ON
+		a.id = k.app_id
+WHERE
+	a.org_id = $1
+	AND
+	k.app_id = $2
+`
+		queryTail = `
+ORDER BY
+	k.key
+`
+		getTags = `
+SELECT
+	g.key_id, g.tag
+FROM
+	apps_features_keys_tags g
+JOIN
+	apps_features_keys k ON
+		k.id = g.key_id
+WHERE
+	k.app_id = $1
+ORDER BY
+	g.tag
+`
+	)
+
+	var (
+		conds = []string{queryHead}
+		args  = []interface{}{orgID, appID}
+		rows  *sql.Rows
+	)
+
+	addCond := func(cond, val string) {
+		if val == "" {
+			return
+		}
+
+		args = append(args, val)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	addCond("AND k.owner = $%d", filter.Owner)
+	addCond("AND k.stage = $%d", filter.Stage)
+	addCond("AND EXISTS (SELECT 1 FROM apps_features_keys_tags g WHERE g.key_id = k.id AND g.tag = $%d)", filter.Tag)
+
+	query := strings.Join(append(conds, queryTail), "\n")
+
+	if rows, err = s.db.QueryContext(ctx, query, args...); err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	idx := make(map[int64]int)
+
+	for rows.Next() {
+		var k toggle.KeyInfo
+
+		if err = rows.Scan(
+			&k.ID, &k.Name, &k.Description, &k.Owner, &k.Link, &k.Stage, &k.CreatedBy, &k.CreatedAt, &k.ExpiresAt,
+		); err != nil {
+			return
+		}
+
+		idx[k.ID] = len(rv)
+		rv = append(rv, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	if len(rv) == 0 {
+		return
+	}
+
+	if rows, err = s.db.QueryContext(ctx, getTags, appID); err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	var (
+		keyID int64
+		tag   string
+	)
+
+	for rows.Next() {
+		if err = rows.Scan(&keyID, &tag); err != nil {
+			return
+		}
+
+		if i, ok := idx[keyID]; ok {
+			rv[i].Tags = append(rv[i].Tags, tag)
+		}
+	}
+
+	return rv, rows.Err()
+}
+
+// EditAppKey replaces editable meta (all but author) for given app key.
+func (s *store) EditAppKey(
+	ctx context.Context,
+	orgID, appID int64,
+	key string,
+	meta *toggle.Meta,
+) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err = checkApp(ctx, tx, orgID, appID); err != nil {
+		return err
+	}
+
+	if err = editKey(ctx, tx, appID, key, meta); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func editKey(
+	ctx context.Context,
+	tx *sql.Tx,
+	appID int64,
+	key string,
+	meta *toggle.Meta,
+) (err error) {
+	const (
+		setMeta = `
+UPDATE apps_features_keys
+SET description = $3, owner = $4, link = $5, stage = $6, expires_at = $7
+WHERE app_id = $1 AND key = $2
+RETURNING id`
+
+		dropTags = `DELETE FROM apps_features_keys_tags WHERE key_id = $1`
+	)
+
+	m := metaOrDefault(meta)
+
+	var keyID int64
+
+	if err = tx.QueryRowContext(
+		ctx, setMeta, appID, key, m.Description, m.Owner, m.Link, m.Stage, m.ExpiresAt,
+	).Scan(&keyID); err != nil {
+		return
+	}
+
+	if _, err = tx.ExecContext(ctx, dropTags, keyID); err != nil {
+		return
+	}
+
+	return addKeyTags(ctx, tx, keyID, m.Tags)
+}
+
+func addKeyTags(
+	ctx context.Context,
+	tx *sql.Tx,
+	keyID int64,
+	tags []string,
+) (err error) {
+	const query = `
+INSERT INTO apps_features_keys_tags
+	(key_id, tag)
+VALUES
+	($1, $2)
+ON CONFLICT DO NOTHING
+`
+
+	for _, t := range tags {
+		if _, err = tx.ExecContext(ctx, query, keyID, strings.ToLower(t)); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+func metaOrDefault(m *toggle.Meta) (rv toggle.Meta) {
+	if m != nil {
+		rv = *m
+	}
+
+	if rv.Stage == "" {
+		rv.Stage = toggle.StageRelease
+	}
+
+	return rv
+}
@@ -0,0 +1,295 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+var errUnknownChange = errors.New("unknown change")
+
+// GetSegments returns all app segments (versions and platforms of every env) of organization.
+func (s *store) GetSegments(
+	ctx context.Context,
+	orgID int64,
+) (rv []toggle.Segment, err error) {
+	const query = `
+SELECT
+	a.name, v.env, v.version, v.platform
+FROM
+	apps_versions v
+JOIN
+	apps a ON
+		a.id = v.app_id
+WHERE
+	a.org_id = $1
+ORDER BY
+	v.id
+`
+
+	var rows *sql.Rows
+
+	if rows, err = s.db.QueryContext(ctx, query, orgID); err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		seg := toggle.Segment{Org: orgID}
+
+		if err = rows.Scan(&seg.App, &seg.Env, &seg.Version, &seg.Platform); err != nil {
+			return
+		}
+
+		rv = append(rv, seg)
+	}
+
+	return rv, rows.Err()
+}
+
+// ApplyChanges applies manifest changes for organization in single transaction.
+func (s *store) ApplyChanges(
+	ctx context.Context,
+	orgID int64,
+	changes []manifest.Change,
+) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	a := applier{s: s, tx: tx, org: orgID, apps: make(map[string]int64)}
+
+	for i := 0; i < len(changes); i++ {
+		c := &changes[i]
+
+		if err = a.apply(ctx, c); err != nil {
+			return changeError(c, err)
+		}
+	}
+
+	if err = checkQuota(ctx, tx, appsQuota, orgID, 0); err != nil {
+		return err
+	}
+
+	if err = checkQuota(ctx, tx, keysQuota, orgID, 0); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func changeError(c *manifest.Change, err error) error {
+	return fmt.Errorf("%s %s '%s': %w", c.Op, c.Kind, c.App, err)
+}
+
+type applier struct {
+	s    *store
+	tx   *sql.Tx
+	org  int64
+	apps map[string]int64
+}
+
+func (a *applier) apply(ctx context.Context, c *manifest.Change) error {
+	if c.Kind == manifest.KindApp {
+		switch c.Op {
+		case manifest.OpCreate:
+			return a.createApp(ctx, c.App)
+		case manifest.OpDelete:
+			return a.deleteApp(ctx, c.App)
+		}
+	}
+
+	appID, err := a.appID(ctx, c.App)
+	if err != nil {
+		return err
+	}
+
+	switch c.Kind {
+	case manifest.KindKey:
+		return a.applyKey(ctx, appID, c)
+	case manifest.KindSegment:
+		return a.applySegment(ctx, appID, c)
+	case manifest.KindToggle:
+		return a.applyToggle(ctx, appID, c)
+	}
+
+	return errUnknownChange
+}
+
+func (a *applier) exec(ctx context.Context, queries []string, args ...interface{}) (err error) {
+	for _, q := range queries {
+		if _, err = a.tx.ExecContext(ctx, q, args...); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+func (a *applier) appID(ctx context.Context, name string) (id int64, err error) {
+	const query = `SELECT id FROM apps WHERE org_id = $1 AND name = $2`
+
+	if id, ok := a.apps[name]; ok {
+		return id, nil
+	}
+
+	if err = a.tx.QueryRowContext(ctx, query, a.org, name).Scan(&id); err != nil {
+		return
+	}
+
+	a.apps[name] = id
+
+	return id, nil
+}
+
+func (a *applier) createApp(ctx context.Context, name string) (err error) {
+	const query = `INSERT INTO apps(org_id, name) VALUES ($1, $2) RETURNING id`
+
+	var id int64
+
+	if err = a.tx.QueryRowContext(ctx, query, a.org, strings.ToLower(name)).Scan(&id); err != nil {
+		return
+	}
+
+	a.apps[name] = id
+
+	return nil
+}
+
+func (a *applier) deleteApp(ctx context.Context, name string) (err error) {
+	var id int64
+
+	if id, err = a.appID(ctx, name); err != nil {
+		return
+	}
+
+	delete(a.apps, name)
+
+	return a.exec(ctx, []string{
+		`DELETE FROM apps_features_toggles WHERE version_id IN (SELECT id FROM apps_versions WHERE app_id = $1)`,
+		`DELETE FROM apps_versions WHERE app_id = $1`,
+		`DELETE FROM apps_features_keys_tags WHERE key_id IN (SELECT id FROM apps_features_keys WHERE app_id = $1)`,
+		`DELETE FROM apps_features_keys WHERE app_id = $1`,
+		`DELETE FROM apps WHERE id = $1`,
+	}, id)
+}
+
+func (a *applier) applyKey(ctx context.Context, appID int64, c *manifest.Change) (err error) {
+	const setAuthor = `UPDATE apps_features_keys SET created_by = $3 WHERE app_id = $1 AND key = $2`
+
+	switch c.Op {
+	case manifest.OpCreate:
+		_, err = a.s.getOrCreateKeys(ctx, a.tx, appID, toggle.Keys{{Name: c.Key, Meta: c.Meta}})
+	case manifest.OpUpdate:
+		if err = editKey(ctx, a.tx, appID, c.Key, c.Meta); err != nil {
+			return
+		}
+
+		// unlike edit requests, manifest holds author as well.
+		_, err = a.tx.ExecContext(ctx, setAuthor, appID, c.Key, metaOrDefault(c.Meta).CreatedBy)
+	case manifest.OpDelete:
+		var keyID int64
+
+		if keyID, err = a.keyID(ctx, appID, c.Key); err != nil {
+			return
+		}
+
+		err = a.exec(ctx, []string{
+			`DELETE FROM apps_features_toggles WHERE key_id = $1`,
+			`DELETE FROM apps_features_keys_tags WHERE key_id = $1`,
+			`DELETE FROM apps_features_keys WHERE id = $1`,
+		}, keyID)
+	}
+
+	return err
+}
+
+func (a *applier) applySegment(ctx context.Context, appID int64, c *manifest.Change) (err error) {
+	// segments may exist without toggles, so creation of existing one is not an error.
+	const addVersion = `
+INSERT INTO apps_versions
+	(app_id, env, version, platform)
+VALUES
+	($1, $2, $3, $4)
+ON CONFLICT DO NOTHING
+`
+
+	switch c.Op {
+	case manifest.OpCreate:
+		_, err = a.tx.ExecContext(ctx, addVersion, appID, c.Env, c.Version, c.Platform)
+	case manifest.OpDelete:
+		var versionID int64
+
+		if versionID, err = a.versionID(ctx, appID, c); err != nil {
+			return
+		}
+
+		err = a.exec(ctx, []string{
+			`DELETE FROM apps_features_toggles WHERE version_id = $1`,
+			`DELETE FROM apps_versions WHERE id = $1`,
+		}, versionID)
+	}
+
+	return err
+}
+
+func (a *applier) applyToggle(ctx context.Context, appID int64, c *manifest.Change) (err error) {
+	const (
+		setRate = `
+INSERT INTO apps_features_toggles
+	(version_id, key_id, rate)
+VALUES
+	($1, $2, $3)
+ON CONFLICT (version_id, key_id) DO UPDATE
+SET rate = EXCLUDED.rate, updated_at = CURRENT_TIMESTAMP
+`
+
+		dropToggle = `DELETE FROM apps_features_toggles WHERE version_id = $1 AND key_id = $2`
+	)
+
+	var versionID, keyID int64
+
+	if versionID, err = a.versionID(ctx, appID, c); err != nil {
+		return
+	}
+
+	if keyID, err = a.keyID(ctx, appID, c.Key); err != nil {
+		return
+	}
+
+	switch c.Op {
+	case manifest.OpCreate, manifest.OpUpdate:
+		_, err = a.tx.ExecContext(ctx, setRate, versionID, keyID, toggle.RoundRate(c.Rate))
+	case manifest.OpDelete:
+		_, err = a.tx.ExecContext(ctx, dropToggle, versionID, keyID)
+	}
+
+	return err
+}
+
+func (a *applier) keyID(ctx context.Context, appID int64, key string) (id int64, err error) {
+	const query = `SELECT id FROM apps_features_keys WHERE app_id = $1 AND key = $2`
+
+	err = a.tx.QueryRowContext(ctx, query, appID, key).Scan(&id)
+
+	return
+}
+
+func (a *applier) versionID(ctx context.Context, appID int64, c *manifest.Change) (id int64, err error) {
+	const query = `
+SELECT id FROM apps_versions
+WHERE app_id = $1 AND env = $2 AND version = $3 AND platform = $4`
+
+	err = a.tx.QueryRowContext(ctx, query, appID, c.Env, c.Version, c.Platform).Scan(&id)
+
+	return
+}
@@ -0,0 +1,979 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+var (
+	errUnknownEnv = errors.New("unknown environment")
+	errBadStage   = errors.New("bad stage")
+	errBadRate    = errors.New("bad rate")
+)
+
+// memEnvs mirrors environments, seeded by schema.
+var memEnvs = []string{"dev", "staging", "production"}
+
+type (
+	memOrg struct {
+		name    string
+		keyHash string
+		maxApps int
+		maxKeys int
+	}
+
+	memApp struct {
+		orgID int64
+		name  string
+	}
+
+	memVersion struct {
+		appID    int64
+		env      string
+		version  string
+		platform string
+	}
+
+	memKey struct {
+		appID     int64
+		name      string
+		meta      toggle.Meta
+		createdAt time.Time
+	}
+
+	memToggle struct {
+		versionID int64
+		keyID     int64
+		rate      float64
+		updatedAt time.Time
+		staleAt   *time.Time
+	}
+
+	// memTables holds all rows, every table has its own id sequence.
+	memTables struct {
+		seq      map[string]int64
+		orgs     map[int64]memOrg
+		apps     map[int64]memApp
+		versions map[int64]memVersion
+		keys     map[int64]memKey
+		toggles  map[int64]memToggle
+		archive  map[int64]memToggle
+	}
+
+	memory struct {
+		mu  sync.RWMutex
+		t   *memTables
+		now func() time.Time
+	}
+)
+
+// NewMemory creates new in-process store, with same semantics as postgres one,
+// all data is lost upon exit.
+func NewMemory() Store {
+	return &memory{
+		t: &memTables{
+			seq:      make(map[string]int64),
+			orgs:     make(map[int64]memOrg),
+			apps:     make(map[int64]memApp),
+			versions: make(map[int64]memVersion),
+			keys:     make(map[int64]memKey),
+			toggles:  make(map[int64]memToggle),
+			archive:  make(map[int64]memToggle),
+		},
+		now: func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// view runs fn over current tables under read lock.
+func (m *memory) view(fn func(*memTables) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return fn(m.t)
+}
+
+// update runs fn over copy of current tables, copy replaces tables only if fn succeeds,
+// so every update is a transaction.
+func (m *memory) update(fn func(*memTables) error) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.t.clone()
+
+	if err = fn(t); err != nil {
+		return
+	}
+
+	m.t = t
+
+	return nil
+}
+
+// AddOrg adds new organization, only hash of its api key will be stored,
+// zero quotas means no limits.
+func (m *memory) AddOrg(
+	_ context.Context,
+	name, apiKey string,
+	maxApps, maxKeys int,
+) error {
+	o := memOrg{name: strings.ToLower(name), keyHash: hashKey(apiKey), maxApps: maxApps, maxKeys: maxKeys}
+
+	return m.update(func(t *memTables) error {
+		for _, v := range t.orgs {
+			if v.name == o.name || v.keyHash == o.keyHash {
+				return ErrConflict
+			}
+		}
+
+		t.orgs[t.next("orgs")] = o
+
+		return nil
+	})
+}
+
+// GetOrgID returns organization id for given api key.
+func (m *memory) GetOrgID(
+	_ context.Context,
+	apiKey string,
+) (id int64, err error) {
+	h := hashKey(apiKey)
+
+	err = m.view(func(t *memTables) error {
+		for oid, o := range t.orgs {
+			if o.keyHash == h {
+				id = oid
+
+				return nil
+			}
+		}
+
+		return sql.ErrNoRows
+	})
+
+	return
+}
+
+// GetOrgKeys returns api key hashes of all organizations by their ids.
+func (m *memory) GetOrgKeys(
+	_ context.Context,
+) (rv map[int64]string, err error) {
+	rv = make(map[int64]string)
+
+	err = m.view(func(t *memTables) error {
+		for id, o := range t.orgs {
+			rv[id] = o.keyHash
+		}
+
+		return nil
+	})
+
+	return rv, err
+}
+
+// GetOrgs returns ids of all organizations.
+func (m *memory) GetOrgs(
+	_ context.Context,
+) (rv []int64, err error) {
+	err = m.view(func(t *memTables) error {
+		for id := range t.orgs {
+			rv = append(rv, id)
+		}
+
+		return nil
+	})
+
+	sortIDs(rv)
+
+	return rv, err
+}
+
+// GetApps returns slice of available app names.
+func (m *memory) GetApps(
+	_ context.Context,
+	orgID int64,
+) (rv []string, err error) {
+	err = m.view(func(t *memTables) error {
+		for _, id := range t.appIDs() {
+			if a := t.apps[id]; a.orgID == orgID {
+				rv = append(rv, a.name)
+			}
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// GetAppID returns id for given app name.
+func (m *memory) GetAppID(
+	_ context.Context,
+	orgID int64,
+	app string,
+) (id int64, err error) {
+	err = m.view(func(t *memTables) (err error) {
+		id, err = t.appID(orgID, strings.ToLower(app))
+
+		return
+	})
+
+	return
+}
+
+// GetEnvs returns slice of environment names, ordered by promotion path.
+func (m *memory) GetEnvs(
+	_ context.Context,
+) ([]string, error) {
+	return append([]string(nil), memEnvs...), nil
+}
+
+// AddApps adds new app names.
+func (m *memory) AddApps(
+	_ context.Context,
+	orgID int64,
+	apps []string,
+) error {
+	return m.update(func(t *memTables) (err error) {
+		if err = t.checkQuota(orgID, appsQuota, len(apps)); err != nil {
+			return
+		}
+
+		for _, a := range apps {
+			if _, err = t.addApp(orgID, strings.ToLower(a)); err != nil {
+				return
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetAppFeatures returns slice of toggled features for given params.
+func (m *memory) GetAppFeatures(
+	_ context.Context,
+	orgID, appID int64,
+	env, version, platform string,
+) (rv toggle.Keys, err error) {
+	err = m.view(func(t *memTables) error {
+		if t.checkApp(orgID, appID) != nil {
+			return nil
+		}
+
+		vid, ok := t.versionID(appID, env, version, platform)
+		if !ok {
+			return nil
+		}
+
+		for _, id := range t.toggleIDs() {
+			if tg := t.toggles[id]; tg.versionID == vid && tg.rate > 0 {
+				rv = append(rv, toggle.Key{ID: id, Name: t.keys[tg.keyID].name, Rate: tg.rate})
+			}
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// GetAppKeys returns app keys with their meta, filtered by given filter.
+func (m *memory) GetAppKeys(
+	_ context.Context,
+	orgID, appID int64,
+	filter toggle.KeyFilter,
+) (rv []toggle.KeyInfo, err error) {
+	err = m.view(func(t *memTables) error {
+		if t.checkApp(orgID, appID) != nil {
+			return nil
+		}
+
+		for id, k := range t.keys {
+			if k.appID != appID || !keyMatches(&k.meta, filter) {
+				continue
+			}
+
+			ki := toggle.KeyInfo{Meta: k.meta, ID: id, Name: k.name, CreatedAt: k.createdAt}
+			ki.Tags = append([]string(nil), k.meta.Tags...)
+
+			rv = append(rv, ki)
+		}
+
+		return nil
+	})
+
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Name < rv[j].Name
+	})
+
+	return rv, err
+}
+
+// EditAppKey replaces editable meta (all but author) for given app key.
+func (m *memory) EditAppKey(
+	_ context.Context,
+	orgID, appID int64,
+	key string,
+	meta *toggle.Meta,
+) error {
+	return m.update(func(t *memTables) (err error) {
+		if err = t.checkApp(orgID, appID); err != nil {
+			return
+		}
+
+		return t.editKey(appID, key, meta)
+	})
+}
+
+// AddAppFeatures adds new version, platforms and toggles for given app and environment.
+func (m *memory) AddAppFeatures(
+	_ context.Context,
+	orgID, appID int64,
+	env string,
+	version string,
+	platforms []string,
+	keys toggle.Keys,
+) error {
+	now := m.now()
+
+	return m.update(func(t *memTables) (err error) {
+		if err = t.checkApp(orgID, appID); err != nil {
+			return
+		}
+
+		appKeys := make(map[string]int64)
+
+		for i := 0; i < len(keys); i++ {
+			if appKeys[keys[i].Name], err = t.getOrCreateKey(appID, keys[i].Name, keys[i].Meta, now); err != nil {
+				return
+			}
+		}
+
+		if err = t.checkQuota(orgID, keysQuota, 0); err != nil {
+			return
+		}
+
+		for _, p := range platforms {
+			var vid int64
+
+			if vid, err = t.addVersion(appID, env, version, p); err != nil {
+				return
+			}
+
+			for j := 0; j < len(keys); j++ {
+				if err = t.setToggle(vid, appKeys[keys[j].Name], keys[j].Rate, false, now); err != nil {
+					return
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// EditAppFeature modifies rate for selected key.
+func (m *memory) EditAppFeature(
+	_ context.Context,
+	orgID, appID int64,
+	env string,
+	version string,
+	platform string,
+	key string,
+	rate float64,
+) error {
+	now := m.now()
+
+	return m.update(func(t *memTables) error {
+		if t.checkApp(orgID, appID) != nil {
+			return sql.ErrNoRows
+		}
+
+		vid, ok := t.versionID(appID, env, version, platform)
+		if !ok {
+			return sql.ErrNoRows
+		}
+
+		kid, ok := t.keyID(appID, key)
+		if !ok {
+			return sql.ErrNoRows
+		}
+
+		if _, ok = t.toggleID(vid, kid); !ok {
+			return sql.ErrNoRows
+		}
+
+		return t.setToggle(vid, kid, rate, true, now)
+	})
+}
+
+// PromoteAppFeatures copies versions, platforms and toggle rates of given app
+// from one environment to another, overwriting rates already present in target.
+func (m *memory) PromoteAppFeatures(
+	_ context.Context,
+	orgID, appID int64,
+	from, to string,
+) error {
+	now := m.now()
+
+	return m.update(func(t *memTables) (err error) {
+		if err = t.checkApp(orgID, appID); err != nil {
+			return
+		}
+
+		for _, vid := range t.versionIDs() {
+			v := t.versions[vid]
+
+			if v.appID != appID || v.env != from {
+				continue
+			}
+
+			if _, ok := t.versionID(appID, to, v.version, v.platform); !ok {
+				if _, err = t.addVersion(appID, to, v.version, v.platform); err != nil {
+					return
+				}
+			}
+		}
+
+		for _, tid := range t.toggleIDs() {
+			tg := t.toggles[tid]
+			v := t.versions[tg.versionID]
+
+			if v.appID != appID || v.env != from {
+				continue
+			}
+
+			dst, _ := t.versionID(appID, to, v.version, v.platform)
+
+			if err = t.setToggle(dst, tg.keyID, tg.rate, true, now); err != nil {
+				return
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetSegments returns all app segments (versions and platforms of every env) of organization.
+func (m *memory) GetSegments(
+	_ context.Context,
+	orgID int64,
+) (rv []toggle.Segment, err error) {
+	err = m.view(func(t *memTables) error {
+		for _, id := range t.versionIDs() {
+			v := t.versions[id]
+			a := t.apps[v.appID]
+
+			if a.orgID != orgID {
+				continue
+			}
+
+			rv = append(rv, toggle.Segment{
+				Org:      orgID,
+				App:      a.name,
+				Env:      v.env,
+				Version:  v.version,
+				Platform: v.platform,
+			})
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// GetToggles returns all toggles of organization.
+func (m *memory) GetToggles(
+	_ context.Context,
+	orgID int64,
+) (rv []toggle.Toggle, err error) {
+	err = m.view(func(t *memTables) error {
+		for _, id := range t.toggleIDs() {
+			tg := t.toggles[id]
+			v := t.versions[tg.versionID]
+			a := t.apps[v.appID]
+
+			if a.orgID != orgID {
+				continue
+			}
+
+			k := t.keys[tg.keyID]
+
+			rv = append(rv, toggle.Toggle{
+				ID:        id,
+				App:       a.name,
+				Env:       v.env,
+				Version:   v.version,
+				Platform:  v.platform,
+				Key:       k.name,
+				Rate:      tg.rate,
+				UpdatedAt: tg.updatedAt,
+				ExpiresAt: k.meta.ExpiresAt,
+				StaleAt:   tg.staleAt,
+			})
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// MarkStale flags given toggles of organization as stale, flags for all other toggles are cleared.
+func (m *memory) MarkStale(
+	_ context.Context,
+	orgID int64,
+	ids []int64,
+) error {
+	now := m.now()
+	set := idSet(ids)
+
+	return m.update(func(t *memTables) error {
+		for id, tg := range t.toggles {
+			if !t.orgToggle(orgID, &tg) {
+				continue
+			}
+
+			_, stale := set[id]
+
+			switch {
+			case stale && tg.staleAt == nil:
+				tg.staleAt = &now
+			case !stale:
+				tg.staleAt = nil
+			}
+
+			t.toggles[id] = tg
+		}
+
+		return nil
+	})
+}
+
+// ArchiveToggles moves given toggles of organization to archive, returns number of archived ones.
+func (m *memory) ArchiveToggles(
+	_ context.Context,
+	orgID int64,
+	ids []int64,
+) (count int64, err error) {
+	err = m.update(func(t *memTables) error {
+		count = 0
+
+		for _, id := range ids {
+			tg, ok := t.toggles[id]
+			if !ok || !t.orgToggle(orgID, &tg) {
+				continue
+			}
+
+			if _, ok = t.archive[id]; ok {
+				return ErrConflict
+			}
+
+			t.archive[id] = tg
+			delete(t.toggles, id)
+			count++
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// ApplyChanges applies manifest changes for organization in single transaction.
+func (m *memory) ApplyChanges(
+	_ context.Context,
+	orgID int64,
+	changes []manifest.Change,
+) error {
+	now := m.now()
+
+	return m.update(func(t *memTables) (err error) {
+		for i := 0; i < len(changes); i++ {
+			c := &changes[i]
+
+			if err = t.apply(orgID, c, now); err != nil {
+				return changeError(c, err)
+			}
+		}
+
+		if err = t.checkQuota(orgID, appsQuota, 0); err != nil {
+			return
+		}
+
+		return t.checkQuota(orgID, keysQuota, 0)
+	})
+}
+
+func (t *memTables) clone() *memTables {
+	rv := &memTables{
+		seq:      make(map[string]int64, len(t.seq)),
+		orgs:     make(map[int64]memOrg, len(t.orgs)),
+		apps:     make(map[int64]memApp, len(t.apps)),
+		versions: make(map[int64]memVersion, len(t.versions)),
+		keys:     make(map[int64]memKey, len(t.keys)),
+		toggles:  make(map[int64]memToggle, len(t.toggles)),
+		archive:  make(map[int64]memToggle, len(t.archive)),
+	}
+
+	for k, v := range t.seq {
+		rv.seq[k] = v
+	}
+
+	for k, v := range t.orgs {
+		rv.orgs[k] = v
+	}
+
+	for k, v := range t.apps {
+		rv.apps[k] = v
+	}
+
+	for k, v := range t.versions {
+		rv.versions[k] = v
+	}
+
+	// keys tags slices are never modified in-place, so they can be shared.
+	for k, v := range t.keys {
+		rv.keys[k] = v
+	}
+
+	for k, v := range t.toggles {
+		rv.toggles[k] = v
+	}
+
+	for k, v := range t.archive {
+		rv.archive[k] = v
+	}
+
+	return rv
+}
+
+func (t *memTables) next(table string) int64 {
+	t.seq[table]++
+
+	return t.seq[table]
+}
+
+// checkApp ensures, that app belongs to organization.
+func (t *memTables) checkApp(orgID, appID int64) error {
+	if a, ok := t.apps[appID]; !ok || a.orgID != orgID {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// checkQuota checks if `add` more items fits in organization quota, `quota` is one of
+// appsQuota or keysQuota.
+func (t *memTables) checkQuota(orgID int64, quota string, add int) error {
+	o, ok := t.orgs[orgID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	var limit, used int
+
+	switch quota {
+	case appsQuota:
+		limit = o.maxApps
+
+		for _, a := range t.apps {
+			if a.orgID == orgID {
+				used++
+			}
+		}
+	case keysQuota:
+		limit = o.maxKeys
+
+		for _, k := range t.keys {
+			if t.apps[k.appID].orgID == orgID {
+				used++
+			}
+		}
+	}
+
+	if limit > 0 && used+add > limit {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+func (t *memTables) orgToggle(orgID int64, tg *memToggle) bool {
+	return t.apps[t.versions[tg.versionID].appID].orgID == orgID
+}
+
+func (t *memTables) appID(orgID int64, name string) (int64, error) {
+	for id, a := range t.apps {
+		if a.orgID == orgID && a.name == name {
+			return id, nil
+		}
+	}
+
+	return 0, sql.ErrNoRows
+}
+
+func (t *memTables) addApp(orgID int64, name string) (id int64, err error) {
+	if _, err = t.appID(orgID, name); err == nil {
+		return 0, ErrConflict
+	}
+
+	id = t.next("apps")
+	t.apps[id] = memApp{orgID: orgID, name: name}
+
+	return id, nil
+}
+
+func (t *memTables) dropApp(appID int64) {
+	for id, v := range t.versions {
+		if v.appID == appID {
+			t.dropVersion(id)
+		}
+	}
+
+	for id, k := range t.keys {
+		if k.appID == appID {
+			t.dropKey(id)
+		}
+	}
+
+	delete(t.apps, appID)
+}
+
+func (t *memTables) versionID(appID int64, env, version, platform string) (int64, bool) {
+	for id, v := range t.versions {
+		if v.appID == appID && v.env == env && v.version == version && v.platform == platform {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+func (t *memTables) addVersion(appID int64, env, version, platform string) (id int64, err error) {
+	if !validEnv(env) {
+		return 0, errUnknownEnv
+	}
+
+	if _, ok := t.versionID(appID, env, version, platform); ok {
+		return 0, ErrConflict
+	}
+
+	id = t.next("versions")
+	t.versions[id] = memVersion{appID: appID, env: env, version: version, platform: platform}
+
+	return id, nil
+}
+
+func (t *memTables) dropVersion(versionID int64) {
+	for id, tg := range t.toggles {
+		if tg.versionID == versionID {
+			delete(t.toggles, id)
+		}
+	}
+
+	delete(t.versions, versionID)
+}
+
+func (t *memTables) keyID(appID int64, name string) (int64, bool) {
+	for id, k := range t.keys {
+		if k.appID == appID && k.name == name {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+func (t *memTables) getOrCreateKey(
+	appID int64,
+	name string,
+	meta *toggle.Meta,
+	now time.Time,
+) (id int64, err error) {
+	m := metaOrDefault(meta)
+
+	if !toggle.ValidStage(m.Stage) {
+		return 0, errBadStage
+	}
+
+	// meta (tags included) of existing keys is changed only by editKey.
+	if id, ok := t.keyID(appID, name); ok {
+		return id, nil
+	}
+
+	m.Tags = normTags(m.Tags)
+
+	id = t.next("keys")
+	t.keys[id] = memKey{appID: appID, name: name, meta: m, createdAt: now}
+
+	return id, nil
+}
+
+func (t *memTables) editKey(appID int64, name string, meta *toggle.Meta) error {
+	id, ok := t.keyID(appID, name)
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	m := metaOrDefault(meta)
+
+	if !toggle.ValidStage(m.Stage) {
+		return errBadStage
+	}
+
+	k := t.keys[id]
+	m.CreatedBy = k.meta.CreatedBy
+	m.Tags = normTags(m.Tags)
+	k.meta = m
+	t.keys[id] = k
+
+	return nil
+}
+
+func (t *memTables) dropKey(keyID int64) {
+	for id, tg := range t.toggles {
+		if tg.keyID == keyID {
+			delete(t.toggles, id)
+		}
+	}
+
+	delete(t.keys, keyID)
+}
+
+func (t *memTables) toggleID(versionID, keyID int64) (int64, bool) {
+	for id, tg := range t.toggles {
+		if tg.versionID == versionID && tg.keyID == keyID {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+// setToggle creates toggle, or (if `upsert` is set) updates rate of existing one.
+func (t *memTables) setToggle(versionID, keyID int64, rate float64, upsert bool, now time.Time) error {
+	if rate < 0 || rate > 1 {
+		return errBadRate
+	}
+
+	rate = toggle.RoundRate(rate)
+
+	if id, ok := t.toggleID(versionID, keyID); ok {
+		if !upsert {
+			return ErrConflict
+		}
+
+		tg := t.toggles[id]
+		tg.rate, tg.updatedAt = rate, now
+		t.toggles[id] = tg
+
+		return nil
+	}
+
+	t.toggles[t.next("toggles")] = memToggle{versionID: versionID, keyID: keyID, rate: rate, updatedAt: now}
+
+	return nil
+}
+
+func keyMatches(m *toggle.Meta, f toggle.KeyFilter) bool {
+	if f.Owner != "" && m.Owner != f.Owner {
+		return false
+	}
+
+	if f.Stage != "" && m.Stage != f.Stage {
+		return false
+	}
+
+	if f.Tag == "" {
+		return true
+	}
+
+	for _, tag := range m.Tags {
+		if tag == f.Tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normTags returns sorted set of lowercased tags, always as new slice.
+func normTags(tags []string) (rv []string) {
+	set := make(map[string]struct{})
+
+	for _, tag := range tags {
+		set[strings.ToLower(tag)] = struct{}{}
+	}
+
+	for tag := range set {
+		rv = append(rv, tag)
+	}
+
+	sort.Strings(rv)
+
+	return rv
+}
+
+func validEnv(env string) bool {
+	for _, e := range memEnvs {
+		if e == env {
+			return true
+		}
+	}
+
+	return false
+}
+
+func idSet(ids []int64) map[int64]struct{} {
+	rv := make(map[int64]struct{}, len(ids))
+
+	for _, id := range ids {
+		rv[id] = struct{}{}
+	}
+
+	return rv
+}
+
+func (t *memTables) appIDs() (rv []int64) {
+	for id := range t.apps {
+		rv = append(rv, id)
+	}
+
+	sortIDs(rv)
+
+	return rv
+}
+
+func (t *memTables) versionIDs() (rv []int64) {
+	for id := range t.versions {
+		rv = append(rv, id)
+	}
+
+	sortIDs(rv)
+
+	return rv
+}
+
+func (t *memTables) toggleIDs() (rv []int64) {
+	for id := range t.toggles {
+		rv = append(rv, id)
+	}
+
+	sortIDs(rv)
+
+	return rv
+}
+
+func sortIDs(ids []int64) {
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+}
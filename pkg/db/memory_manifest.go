@@ -0,0 +1,116 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+)
+
+// apply applies single manifest change, the same way as applier does.
+func (t *memTables) apply(orgID int64, c *manifest.Change, now time.Time) (err error) {
+	if c.Kind == manifest.KindApp {
+		switch c.Op {
+		case manifest.OpCreate:
+			_, err = t.addApp(orgID, strings.ToLower(c.App))
+
+			return err
+		case manifest.OpDelete:
+			var appID int64
+
+			if appID, err = t.appID(orgID, c.App); err != nil {
+				return
+			}
+
+			t.dropApp(appID)
+
+			return nil
+		}
+	}
+
+	appID, err := t.appID(orgID, c.App)
+	if err != nil {
+		return err
+	}
+
+	switch c.Kind {
+	case manifest.KindKey:
+		return t.applyKey(appID, c, now)
+	case manifest.KindSegment:
+		return t.applySegment(appID, c)
+	case manifest.KindToggle:
+		return t.applyToggle(appID, c, now)
+	}
+
+	return errUnknownChange
+}
+
+func (t *memTables) applyKey(appID int64, c *manifest.Change, now time.Time) (err error) {
+	switch c.Op {
+	case manifest.OpCreate:
+		_, err = t.getOrCreateKey(appID, c.Key, c.Meta, now)
+	case manifest.OpUpdate:
+		if err = t.editKey(appID, c.Key, c.Meta); err != nil {
+			return
+		}
+
+		// unlike edit requests, manifest holds author as well.
+		keyID, _ := t.keyID(appID, c.Key)
+		k := t.keys[keyID]
+		k.meta.CreatedBy = metaOrDefault(c.Meta).CreatedBy
+		t.keys[keyID] = k
+	case manifest.OpDelete:
+		keyID, ok := t.keyID(appID, c.Key)
+		if !ok {
+			return sql.ErrNoRows
+		}
+
+		t.dropKey(keyID)
+	}
+
+	return err
+}
+
+func (t *memTables) applySegment(appID int64, c *manifest.Change) (err error) {
+	switch c.Op {
+	case manifest.OpCreate:
+		// segments may exist without toggles, so creation of existing one is not an error.
+		if _, err = t.addVersion(appID, c.Env, c.Version, c.Platform); errors.Is(err, ErrConflict) {
+			err = nil
+		}
+	case manifest.OpDelete:
+		versionID, ok := t.versionID(appID, c.Env, c.Version, c.Platform)
+		if !ok {
+			return sql.ErrNoRows
+		}
+
+		t.dropVersion(versionID)
+	}
+
+	return err
+}
+
+func (t *memTables) applyToggle(appID int64, c *manifest.Change, now time.Time) error {
+	versionID, ok := t.versionID(appID, c.Env, c.Version, c.Platform)
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	keyID, ok := t.keyID(appID, c.Key)
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	switch c.Op {
+	case manifest.OpCreate, manifest.OpUpdate:
+		return t.setToggle(versionID, keyID, c.Rate, true, now)
+	case manifest.OpDelete:
+		if id, ok := t.toggleID(versionID, keyID); ok {
+			delete(t.toggles, id)
+		}
+	}
+
+	return nil
+}
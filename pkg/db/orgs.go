@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	appsQuota = `
+SELECT
+	o.max_apps, COUNT(a.id)
+FROM
+	orgs o
+LEFT JOIN
+	apps a ON
+		a.org_id = o.id
+WHERE
+	o.id = $1
+GROUP BY
+	o.max_apps
+`
+
+	keysQuota = `
+SELECT
+	o.max_keys, COUNT(k.id)
+FROM
+	orgs o
+LEFT JOIN
+	apps a ON
+		a.org_id = o.id
+LEFT JOIN
+	apps_features_keys k ON
+		k.app_id = a.id
+WHERE
+	o.id = $1
+GROUP BY
+	o.max_keys
+`
+)
+
+// AddOrg adds new organization, only hash of its api key will be stored,
+// zero quotas means no limits.
+func (s *store) AddOrg(
+	ctx context.Context,
+	name, apiKey string,
+	maxApps, maxKeys int,
+) (err error) {
+	const query = `
+INSERT INTO orgs
+	(name, key_hash, max_apps, max_keys)
+VALUES
+	($1, $2, $3, $4)
+ON CONFLICT DO NOTHING
+`
+
+	var (
+		res sql.Result
+		n   int64
+	)
+
+	if res, err = s.db.ExecContext(ctx, query, strings.ToLower(name), hashKey(apiKey), maxApps, maxKeys); err != nil {
+		return
+	}
+
+	if n, err = res.RowsAffected(); err != nil {
+		return
+	}
+
+	if n == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// SetOrgKey replaces api key of organization, i.e. of "default" one, that holds apps, created before
+// organizations were added, returns sql.ErrNoRows if there is no such organization.
+func SetOrgKey(ctx context.Context, db *sql.DB, name, apiKey string) (err error) {
+	const query = `UPDATE orgs SET key_hash = $1 WHERE name = $2`
+
+	var (
+		res sql.Result
+		n   int64
+	)
+
+	if res, err = db.ExecContext(ctx, query, hashKey(apiKey), strings.ToLower(name)); err != nil {
+		return
+	}
+
+	if n, err = res.RowsAffected(); err != nil {
+		return
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetOrgIDByName returns id of organization with given name, or sql.ErrNoRows if there is no such one.
+func GetOrgIDByName(ctx context.Context, db *sql.DB, name string) (id int64, err error) {
+	const query = `SELECT id FROM orgs WHERE name = $1`
+
+	err = db.QueryRowContext(ctx, query, strings.ToLower(name)).Scan(&id)
+
+	return
+}
+
+// GetOrgID returns organization id for given api key.
+func (s *store) GetOrgID(
+	ctx context.Context,
+	apiKey string,
+) (id int64, err error) {
+	const query = `SELECT id FROM orgs WHERE key_hash = $1 LIMIT 1`
+
+	err = s.db.QueryRowContext(ctx, query, hashKey(apiKey)).Scan(&id)
+
+	return
+}
+
+// GetOrgKeys returns api key hashes of all organizations by their ids.
+func (s *store) GetOrgKeys(
+	ctx context.Context,
+) (rv map[int64]string, err error) {
+	const query = `SELECT id, key_hash FROM orgs`
+
+	var rows *sql.Rows
+
+	if rows, err = s.db.QueryContext(ctx, query); err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	var (
+		id   int64
+		hash string
+	)
+
+	rv = make(map[int64]string)
+
+	for rows.Next() {
+		if err = rows.Scan(&id, &hash); err != nil {
+			return
+		}
+
+		rv[id] = hash
+	}
+
+	return rv, rows.Err()
+}
+
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(h[:])
+}
+
+// checkApp ensures, that app belongs to organization.
+func checkApp(
+	ctx context.Context,
+	tx *sql.Tx,
+	orgID, appID int64,
+) error {
+	const query = `SELECT id FROM apps WHERE id = $1 AND org_id = $2`
+
+	return tx.QueryRowContext(ctx, query, appID, orgID).Scan(&appID)
+}
+
+// checkQuota runs query, that returns (limit, used) pair for organization,
+// and checks if `add` more items fits in.
+func checkQuota(
+	ctx context.Context,
+	tx *sql.Tx,
+	query string,
+	orgID int64,
+	add int,
+) (err error) {
+	var limit, used int
+
+	if err = tx.QueryRowContext(ctx, query, orgID).Scan(&limit, &used); err != nil {
+		return
+	}
+
+	if limit > 0 && used+add > limit {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
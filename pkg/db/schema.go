@@ -0,0 +1,303 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Supported SQL dialects.
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// getVersion selects current schema version.
+const getVersion = `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`
+
+var (
+	errUnknownDialect = errors.New("unknown sql dialect")
+	errSchemaOutdated = errors.New("schema is outdated")
+)
+
+// serialTypes holds auto-increment primary key column type for every dialect,
+// it replaces `{{serial}}` placeholder in migrations, the rest of schema is portable.
+var serialTypes = map[string]string{
+	DialectPostgres: "BIGSERIAL PRIMARY KEY",
+	DialectSQLite:   "INTEGER PRIMARY KEY AUTOINCREMENT",
+}
+
+// migrations holds schema changes, every migration is a list of statements, applied
+// in single transaction, new migrations must be appended only. Statements, prefixed by
+// `{{dialect}}`, are applied only for that dialect: sqlite can not drop constraints, nor
+// add some columns, so it rebuilds tables instead.
+var migrations = [][]string{
+	// 1: original schema, `IF NOT EXISTS` adopts databases, created with `sql/toggle.sql` by previous versions.
+	{
+		`CREATE TABLE IF NOT EXISTS apps(
+    id       {{serial}},
+    name     VARCHAR(255) NOT NULL,
+    UNIQUE(name)
+)`,
+		`CREATE TABLE IF NOT EXISTS apps_versions(
+    id         {{serial}},
+    app_id     BIGINT       NOT NULL,
+    version    VARCHAR(64)  NOT NULL,
+    platform   VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(app_id, version, platform)
+)`,
+		`CREATE INDEX IF NOT EXISTS apps_versions_idx
+    ON apps_versions (app_id, version, platform)`,
+		`CREATE TABLE IF NOT EXISTS apps_features_keys(
+    id         {{serial}},
+    app_id     BIGINT       NOT NULL,
+    key        VARCHAR(255) NOT NULL,
+    UNIQUE(app_id, key)
+)`,
+		`CREATE INDEX IF NOT EXISTS apps_features_keys_idx
+    ON apps_features_keys (app_id)`,
+		`CREATE TABLE IF NOT EXISTS apps_features_toggles(
+    id         {{serial}},
+    version_id BIGINT       NOT NULL,
+    key_id     BIGINT       NOT NULL,
+    rate       DECIMAL(3,2) NOT NULL,
+    updated_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK(rate >= 0 AND rate <= 1.0)
+)`,
+		`CREATE INDEX IF NOT EXISTS apps_features_toggles_idx
+    ON apps_features_toggles (version_id, key_id)`,
+	},
+	// 2: environments, existing versions belong to production.
+	{
+		`CREATE TABLE environments(
+    name     VARCHAR(64)  PRIMARY KEY,
+    position INT          NOT NULL,
+    UNIQUE(position)
+)`,
+		`INSERT INTO environments
+    (name, position)
+VALUES
+    ('dev', 0),
+    ('staging', 1),
+    ('production', 2)`,
+		`{{postgres}}ALTER TABLE apps_versions
+    ADD COLUMN env VARCHAR(64) NOT NULL DEFAULT 'production' REFERENCES environments(name)`,
+		`{{postgres}}ALTER TABLE apps_versions ALTER COLUMN env DROP DEFAULT`,
+		`{{postgres}}ALTER TABLE apps_versions DROP CONSTRAINT IF EXISTS apps_versions_app_id_version_platform_key`,
+		`{{postgres}}ALTER TABLE apps_versions ADD UNIQUE(app_id, env, version, platform)`,
+		`{{sqlite}}CREATE TABLE apps_versions_new(
+    id         {{serial}},
+    app_id     BIGINT       NOT NULL,
+    env        VARCHAR(64)  NOT NULL REFERENCES environments(name),
+    version    VARCHAR(64)  NOT NULL,
+    platform   VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(app_id, env, version, platform)
+)`,
+		`{{sqlite}}INSERT INTO apps_versions_new
+    (id, app_id, env, version, platform, created_at)
+SELECT
+    id, app_id, 'production', version, platform, created_at
+FROM
+    apps_versions`,
+		`{{sqlite}}DROP TABLE apps_versions`,
+		`{{sqlite}}ALTER TABLE apps_versions_new RENAME TO apps_versions`,
+		`DROP INDEX IF EXISTS apps_versions_idx`,
+		`CREATE INDEX apps_versions_idx
+    ON apps_versions (app_id, env, version, platform)`,
+		// toggles are upserted by version and key, previous versions could add duplicates.
+		`DELETE FROM apps_features_toggles
+WHERE id NOT IN (SELECT MAX(id) FROM apps_features_toggles GROUP BY version_id, key_id)`,
+		`DROP INDEX apps_features_toggles_idx`,
+		`CREATE UNIQUE INDEX apps_features_toggles_idx
+    ON apps_features_toggles (version_id, key_id)`,
+	},
+	// 3: organizations, existing apps are moved to "default" one, which has no api key
+	// until it is set by `toggle-svc org-key default`.
+	{
+		`CREATE TABLE orgs(
+    id       {{serial}},
+    name     VARCHAR(255) NOT NULL,
+    key_hash VARCHAR(64)  NOT NULL,
+    max_apps INT          NOT NULL DEFAULT 0,
+    max_keys INT          NOT NULL DEFAULT 0,
+    UNIQUE(name),
+    UNIQUE(key_hash)
+)`,
+		`INSERT INTO orgs
+    (name, key_hash)
+SELECT
+    'default', ''
+WHERE
+    EXISTS (SELECT 1 FROM apps)`,
+		`{{postgres}}ALTER TABLE apps ADD COLUMN org_id BIGINT REFERENCES orgs(id)`,
+		`{{postgres}}UPDATE apps SET org_id = (SELECT id FROM orgs WHERE name = 'default')`,
+		`{{postgres}}ALTER TABLE apps ALTER COLUMN org_id SET NOT NULL`,
+		`{{postgres}}ALTER TABLE apps DROP CONSTRAINT IF EXISTS apps_name_key`,
+		`{{postgres}}ALTER TABLE apps ADD UNIQUE(org_id, name)`,
+		`{{sqlite}}CREATE TABLE apps_new(
+    id       {{serial}},
+    org_id   BIGINT       NOT NULL REFERENCES orgs(id),
+    name     VARCHAR(255) NOT NULL,
+    UNIQUE(org_id, name)
+)`,
+		`{{sqlite}}INSERT INTO apps_new
+    (id, org_id, name)
+SELECT
+    id, (SELECT id FROM orgs WHERE name = 'default'), name
+FROM
+    apps`,
+		`{{sqlite}}DROP TABLE apps`,
+		`{{sqlite}}ALTER TABLE apps_new RENAME TO apps`,
+	},
+	// 4: keys meta.
+	{
+		`{{postgres}}ALTER TABLE apps_features_keys
+    ADD COLUMN description TEXT          NOT NULL DEFAULT '',
+    ADD COLUMN owner       VARCHAR(255)  NOT NULL DEFAULT '',
+    ADD COLUMN link        VARCHAR(1024) NOT NULL DEFAULT '',
+    ADD COLUMN stage       VARCHAR(16)   NOT NULL DEFAULT 'release',
+    ADD COLUMN created_by  VARCHAR(255)  NOT NULL DEFAULT '',
+    ADD COLUMN created_at  TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD CHECK(stage IN ('experiment', 'release', 'ops', 'permanent'))`,
+		// sqlite can not add column with CURRENT_TIMESTAMP default.
+		`{{sqlite}}CREATE TABLE apps_features_keys_new(
+    id          {{serial}},
+    app_id      BIGINT        NOT NULL,
+    key         VARCHAR(255)  NOT NULL,
+    description TEXT          NOT NULL DEFAULT '',
+    owner       VARCHAR(255)  NOT NULL DEFAULT '',
+    link        VARCHAR(1024) NOT NULL DEFAULT '',
+    stage       VARCHAR(16)   NOT NULL DEFAULT 'release',
+    created_by  VARCHAR(255)  NOT NULL DEFAULT '',
+    created_at  TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(app_id, key),
+    CHECK(stage IN ('experiment', 'release', 'ops', 'permanent'))
+)`,
+		`{{sqlite}}INSERT INTO apps_features_keys_new
+    (id, app_id, key)
+SELECT
+    id, app_id, key
+FROM
+    apps_features_keys`,
+		`{{sqlite}}DROP TABLE apps_features_keys`,
+		`{{sqlite}}ALTER TABLE apps_features_keys_new RENAME TO apps_features_keys`,
+		`CREATE INDEX IF NOT EXISTS apps_features_keys_idx
+    ON apps_features_keys (app_id)`,
+		`CREATE TABLE apps_features_keys_tags(
+    key_id     BIGINT       NOT NULL,
+    tag        VARCHAR(64)  NOT NULL,
+    UNIQUE(key_id, tag)
+)`,
+		`CREATE INDEX apps_features_keys_tags_idx
+    ON apps_features_keys_tags (tag)`,
+	},
+	// 5: stale toggles and archive.
+	{
+		`ALTER TABLE apps_features_keys ADD COLUMN expires_at TIMESTAMP NULL`,
+		`ALTER TABLE apps_features_toggles ADD COLUMN stale_at TIMESTAMP NULL`,
+		`CREATE TABLE apps_features_toggles_archive(
+    id          BIGINT       PRIMARY KEY,
+    version_id  BIGINT       NOT NULL,
+    key_id      BIGINT       NOT NULL,
+    rate        DECIMAL(3,2) NOT NULL,
+    updated_at  TIMESTAMP    NOT NULL,
+    archived_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`,
+	},
+}
+
+// Migrate brings database schema to current version, applied versions are kept
+// in `schema_migrations` table.
+func Migrate(ctx context.Context, db *sql.DB, dialect string) (err error) {
+	const createVersions = `
+CREATE TABLE IF NOT EXISTS schema_migrations(
+    version    INT       PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+	if _, ok := serialTypes[dialect]; !ok {
+		return errUnknownDialect
+	}
+
+	if _, err = db.ExecContext(ctx, createVersions); err != nil {
+		return
+	}
+
+	var current int
+
+	if err = db.QueryRowContext(ctx, getVersion).Scan(&current); err != nil {
+		return
+	}
+
+	for v := current; v < len(migrations); v++ {
+		if err = migrate(ctx, db, v, dialect); err != nil {
+			return fmt.Errorf("migration %d: %w", v+1, err)
+		}
+	}
+
+	return nil
+}
+
+// CheckSchema checks, that all migrations are applied.
+func CheckSchema(ctx context.Context, db *sql.DB) (err error) {
+	var current int
+
+	if err = db.QueryRowContext(ctx, getVersion).Scan(&current); err != nil {
+		return
+	}
+
+	if current < len(migrations) {
+		return fmt.Errorf("%w: version %d of %d", errSchemaOutdated, current, len(migrations))
+	}
+
+	return nil
+}
+
+// statement returns migration statement for dialect, or false, if it is meant for other one.
+func statement(stmt, dialect string) (string, bool) {
+	for d := range serialTypes {
+		if p := "{{" + d + "}}"; strings.HasPrefix(stmt, p) {
+			if d != dialect {
+				return "", false
+			}
+
+			stmt = stmt[len(p):]
+
+			break
+		}
+	}
+
+	return strings.ReplaceAll(stmt, "{{serial}}", serialTypes[dialect]), true
+}
+
+func migrate(ctx context.Context, db *sql.DB, v int, dialect string) error {
+	const setVersion = `INSERT INTO schema_migrations(version) VALUES ($1)`
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, raw := range migrations[v] {
+		stmt, ok := statement(raw, dialect)
+		if !ok {
+			continue
+		}
+
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, setVersion, v+1); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
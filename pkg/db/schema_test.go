@@ -0,0 +1,188 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+// legacySchema is `sql/toggle.sql` of versions, that had no migrations.
+var legacySchema = []string{
+	`CREATE TABLE apps(
+    id       {{serial}},
+    name     VARCHAR(255) NOT NULL,
+    UNIQUE(name)
+)`,
+	`CREATE TABLE apps_versions(
+    id         {{serial}},
+    app_id     BIGINT       NOT NULL,
+    version    VARCHAR(64)  NOT NULL,
+    platform   VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(app_id, version, platform)
+)`,
+	`CREATE INDEX apps_versions_idx
+    ON apps_versions (app_id, version, platform)`,
+	`CREATE TABLE apps_features_keys(
+    id         {{serial}},
+    app_id     BIGINT       NOT NULL,
+    key        VARCHAR(255) NOT NULL,
+    UNIQUE(app_id, key)
+)`,
+	`CREATE INDEX apps_features_keys_idx
+    ON apps_features_keys (app_id)`,
+	`CREATE TABLE apps_features_toggles(
+    id         {{serial}},
+    version_id BIGINT       NOT NULL,
+    key_id     BIGINT       NOT NULL,
+    rate       DECIMAL(3,2) NOT NULL,
+    updated_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK(rate >= 0 AND rate <= 1.0)
+)`,
+	`CREATE INDEX apps_features_toggles_idx
+    ON apps_features_toggles (version_id, key_id)`,
+	`INSERT INTO apps (name) VALUES ('legacy')`,
+	`INSERT INTO apps_versions (app_id, version, platform) VALUES (1, '1.0', 'ios')`,
+	`INSERT INTO apps_features_keys (app_id, key) VALUES (1, 'key')`,
+	// previous versions did not prevent duplicate toggles.
+	`INSERT INTO apps_features_toggles (version_id, key_id, rate) VALUES (1, 1, 0.25)`,
+	`INSERT INTO apps_features_toggles (version_id, key_id, rate) VALUES (1, 1, 0.5)`,
+}
+
+func TestLegacyUpgrade(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		conn, err := sql.Open("sqlite", ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn.SetMaxOpenConns(1)
+
+		t.Cleanup(func() { _ = conn.Close() })
+
+		// as in production.
+		if _, err = conn.Exec(`PRAGMA foreign_keys = ON`); err != nil {
+			t.Fatal(err)
+		}
+
+		testLegacyUpgrade(t, conn, db.DialectSQLite, "INTEGER PRIMARY KEY AUTOINCREMENT")
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv(envPostgresDSN)
+		if dsn == "" {
+			t.Skipf("%s is not set", envPostgresDSN)
+		}
+
+		conn, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { _ = conn.Close() })
+
+		dropTables(t, conn)
+
+		testLegacyUpgrade(t, conn, db.DialectPostgres, "BIGSERIAL PRIMARY KEY")
+	})
+}
+
+func testLegacyUpgrade(t *testing.T, conn *sql.DB, dialect, serial string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	for _, stmt := range legacySchema {
+		if _, err := conn.Exec(strings.ReplaceAll(stmt, "{{serial}}", serial)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := migrated(t, conn, dialect)
+
+	orgs, err := s.GetOrgs(ctx)
+	if err != nil || len(orgs) != 1 {
+		t.Fatalf("orgs: %v err: %v", orgs, err)
+	}
+
+	org := orgs[0]
+
+	// keyless clients are served by it, until they get api key.
+	if id, err := db.GetOrgIDByName(ctx, conn, "Default"); err != nil || id != org {
+		t.Fatalf("default org by name: id = %d err = %v", id, err)
+	}
+
+	if _, err = db.GetOrgIDByName(ctx, conn, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("missing org by name: err = %v", err)
+	}
+
+	// upgraded organization has no api key, until it is set.
+	if _, err = s.GetOrgID(ctx, ""); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("empty key: err = %v", err)
+	}
+
+	if err = db.SetOrgKey(ctx, conn, "default", "default-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := s.GetOrgID(ctx, "default-key"); err != nil || id != org {
+		t.Fatalf("default org: id = %d err = %v", id, err)
+	}
+
+	if err = db.SetOrgKey(ctx, conn, "missing", "key"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("missing org: err = %v", err)
+	}
+
+	appID, err := s.GetAppID(ctx, org, "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := s.GetAppFeatures(ctx, org, appID, "production", "1.0", "ios")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || keys[0].Name != "key" || keys[0].Rate != 0.5 {
+		t.Fatalf("keys: %+v", keys)
+	}
+
+	// existing keys get default meta.
+	infos, err := s.GetAppKeys(ctx, org, appID, toggle.KeyFilter{Stage: toggle.StageRelease})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(infos) != 1 || infos[0].Name != "key" || infos[0].CreatedAt.IsZero() {
+		t.Fatalf("key infos: %+v", infos)
+	}
+
+	// existing toggles are not stale, until marked so, and can be archived.
+	toggles, err := s.GetToggles(ctx, org)
+	if err != nil || len(toggles) != 1 || toggles[0].StaleAt != nil {
+		t.Fatalf("toggles: %+v err: %v", toggles, err)
+	}
+
+	if err = s.MarkStale(ctx, org, []int64{toggles[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := s.ArchiveToggles(ctx, org, []int64{toggles[0].ID}); err != nil || n != 1 {
+		t.Fatalf("archived: %d err: %v", n, err)
+	}
+
+	// new rows must not collide with upgraded ones.
+	if err = s.AddApps(ctx, org, []string{"new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := s.GetAppID(ctx, org, "new"); err != nil || id == appID {
+		t.Fatalf("new app id: %d err: %v", id, err)
+	}
+}
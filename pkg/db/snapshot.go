@@ -0,0 +1,438 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+type (
+	// snapshot holds last-known configuration, needed to serve clients toggles.
+	snapshot struct {
+		TakenAt time.Time `json:"taken_at"`
+		Orgs    []snapOrg `json:"orgs"`
+	}
+
+	snapOrg struct {
+		ID       int64            `json:"id"`
+		KeyHash  string           `json:"key_hash"`
+		Apps     map[string]int64 `json:"apps"`
+		Features []snapFeatures   `json:"features"`
+	}
+
+	snapFeatures struct {
+		AppID    int64       `json:"app_id"`
+		Env      string      `json:"env"`
+		Version  string      `json:"version"`
+		Platform string      `json:"platform"`
+		Keys     toggle.Keys `json:"keys"`
+	}
+
+	// snapIndex is a snapshot, indexed for lookups.
+	snapIndex struct {
+		takenAt  time.Time
+		orgs     map[string]int64
+		apps     map[appKey]int64
+		features map[featuresKey]toggle.Keys
+	}
+
+	// Fallback serves organizations, app ids and features lookups from last-known snapshot, when
+	// store fails (i.e. database is down), snapshot is kept in file, so it survives restarts.
+	Fallback struct {
+		Store
+		path     string
+		mu       sync.RWMutex
+		idx      *snapIndex
+		degraded bool
+		changes  chan struct{}
+	}
+)
+
+// NewFallback creates fallback for given store, its snapshot will be kept at `path`.
+func NewFallback(s Store, path string) *Fallback {
+	return &Fallback{
+		Store:   s,
+		path:    path,
+		changes: make(chan struct{}, 1),
+	}
+}
+
+// Load loads previously saved snapshot, if snapshot file does not exist - os.ErrNotExist is returned.
+func (f *Fallback) Load() (err error) {
+	var b []byte
+
+	if b, err = ioutil.ReadFile(f.path); err != nil {
+		return
+	}
+
+	var snap snapshot
+
+	if err = json.Unmarshal(b, &snap); err != nil {
+		return
+	}
+
+	f.mu.Lock()
+	f.idx = snap.index()
+	f.mu.Unlock()
+
+	return nil
+}
+
+// Refresh takes new snapshot from store and saves it.
+func (f *Fallback) Refresh(ctx context.Context) (err error) {
+	var snap *snapshot
+
+	if snap, err = takeSnapshot(ctx, f.Store); err != nil {
+		f.failed(ctx, err)
+
+		return
+	}
+
+	f.recovered()
+
+	f.mu.Lock()
+	f.idx = snap.index()
+	f.mu.Unlock()
+
+	return snap.save(f.path)
+}
+
+// Changes returns channel, that signals about writes, made through fallback, so snapshot
+// can be refreshed.
+func (f *Fallback) Changes() <-chan struct{} {
+	return f.changes
+}
+
+// Degraded reports, if store is failing and clients are served from snapshot.
+func (f *Fallback) Degraded() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.degraded
+}
+
+// TakenAt returns time of current snapshot, it is zero if there is no snapshot.
+func (f *Fallback) TakenAt() (rv time.Time) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.idx != nil {
+		rv = f.idx.takenAt
+	}
+
+	return rv
+}
+
+// GetOrgID returns organization id for given api key.
+func (f *Fallback) GetOrgID(ctx context.Context, apiKey string) (id int64, err error) {
+	if id, err = f.Store.GetOrgID(ctx, apiKey); !f.failed(ctx, err) {
+		return
+	}
+
+	err = f.lookup(err, func(x *snapIndex) (ok bool) {
+		id, ok = x.orgs[hashKey(apiKey)]
+
+		return
+	})
+
+	return id, err
+}
+
+// GetAppID returns id for given app name.
+func (f *Fallback) GetAppID(ctx context.Context, orgID int64, app string) (id int64, err error) {
+	if id, err = f.Store.GetAppID(ctx, orgID, app); !f.failed(ctx, err) {
+		return
+	}
+
+	err = f.lookup(err, func(x *snapIndex) (ok bool) {
+		id, ok = x.apps[appKey{org: orgID, name: strings.ToLower(app)}]
+
+		return
+	})
+
+	return id, err
+}
+
+// GetAppFeatures returns slice of toggled features for given params.
+func (f *Fallback) GetAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform string,
+) (rv toggle.Keys, err error) {
+	if rv, err = f.Store.GetAppFeatures(ctx, orgID, appID, env, version, platform); !f.failed(ctx, err) {
+		return
+	}
+
+	err = f.lookup(err, func(x *snapIndex) bool {
+		// missing segment has no features, as in database.
+		keys := x.features[featuresKey{org: orgID, app: appID, env: env, version: version, platform: platform}]
+		rv = append(toggle.Keys(nil), keys...)
+
+		return true
+	})
+
+	return rv, err
+}
+
+// AddOrg adds new organization, only hash of its api key will be stored,
+// zero quotas means no limits.
+func (f *Fallback) AddOrg(ctx context.Context, name, apiKey string, maxApps, maxKeys int) error {
+	return f.changed(f.Store.AddOrg(ctx, name, apiKey, maxApps, maxKeys))
+}
+
+// AddApps adds new app names.
+func (f *Fallback) AddApps(ctx context.Context, orgID int64, names []string) error {
+	return f.changed(f.Store.AddApps(ctx, orgID, names))
+}
+
+// AddAppFeatures adds new version, platforms and toggles for given app and environment.
+func (f *Fallback) AddAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version string,
+	platforms []string,
+	features toggle.Keys,
+) error {
+	return f.changed(f.Store.AddAppFeatures(ctx, orgID, appID, env, version, platforms, features))
+}
+
+// EditAppFeature modifies rate for selected key.
+func (f *Fallback) EditAppFeature(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform, key string,
+	rate float64,
+) error {
+	return f.changed(f.Store.EditAppFeature(ctx, orgID, appID, env, version, platform, key, rate))
+}
+
+// PromoteAppFeatures copies versions, platforms and toggle rates of given app
+// from one environment to another, overwriting rates already present in target.
+func (f *Fallback) PromoteAppFeatures(ctx context.Context, orgID, appID int64, from, to string) error {
+	return f.changed(f.Store.PromoteAppFeatures(ctx, orgID, appID, from, to))
+}
+
+// ArchiveToggles moves given toggles of organization to archive, returns number of archived ones.
+func (f *Fallback) ArchiveToggles(ctx context.Context, orgID int64, ids []int64) (n int64, err error) {
+	n, err = f.Store.ArchiveToggles(ctx, orgID, ids)
+
+	return n, f.changed(err)
+}
+
+// ApplyChanges applies manifest changes for organization.
+func (f *Fallback) ApplyChanges(ctx context.Context, orgID int64, changes []manifest.Change) error {
+	return f.changed(f.Store.ApplyChanges(ctx, orgID, changes))
+}
+
+// changed signals about successful write.
+func (f *Fallback) changed(err error) error {
+	if err != nil {
+		return err
+	}
+
+	select {
+	case f.changes <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// failed reports, if store request ended with failure (not just missing rows), and
+// switches fallback to degraded mode, successful requests switch it back.
+func (f *Fallback) failed(ctx context.Context, err error) bool {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		f.recovered()
+
+		return false
+	}
+
+	if ctx.Err() != nil {
+		// request was canceled, store is fine.
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.degraded {
+		log.Println("db: degraded mode on, error:", err)
+	}
+
+	f.degraded = true
+
+	return true
+}
+
+func (f *Fallback) recovered() {
+	f.mu.RLock()
+	degraded := f.degraded
+	f.mu.RUnlock()
+
+	if !degraded {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.degraded {
+		log.Println("db: degraded mode off")
+	}
+
+	f.degraded = false
+}
+
+// lookup runs fn over current snapshot, store error `err` is returned, if there is no
+// snapshot, sql.ErrNoRows - if fn finds nothing.
+func (f *Fallback) lookup(err error, fn func(*snapIndex) bool) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.idx == nil {
+		return err
+	}
+
+	if !fn(f.idx) {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// takeSnapshot reads configuration of all organizations from store.
+func takeSnapshot(ctx context.Context, s Store) (snap *snapshot, err error) {
+	var (
+		orgs    []int64
+		hashes  map[int64]string
+		apps    []string
+		toggles []toggle.Toggle
+	)
+
+	if orgs, err = s.GetOrgs(ctx); err != nil {
+		return
+	}
+
+	if hashes, err = s.GetOrgKeys(ctx); err != nil {
+		return
+	}
+
+	snap = &snapshot{TakenAt: time.Now().UTC()}
+
+	for _, orgID := range orgs {
+		o := snapOrg{ID: orgID, KeyHash: hashes[orgID], Apps: make(map[string]int64)}
+
+		if apps, err = s.GetApps(ctx, orgID); err != nil {
+			return
+		}
+
+		for _, name := range apps {
+			if o.Apps[name], err = s.GetAppID(ctx, orgID, name); err != nil {
+				return
+			}
+		}
+
+		if toggles, err = s.GetToggles(ctx, orgID); err != nil {
+			return
+		}
+
+		o.Features = groupFeatures(o.Apps, toggles)
+
+		snap.Orgs = append(snap.Orgs, o)
+	}
+
+	return snap, nil
+}
+
+// groupFeatures groups enabled toggles by their segments.
+func groupFeatures(apps map[string]int64, toggles []toggle.Toggle) (rv []snapFeatures) {
+	seen := make(map[featuresKey]int)
+
+	for i := 0; i < len(toggles); i++ {
+		t := &toggles[i]
+
+		if t.Rate <= 0 {
+			continue
+		}
+
+		k := featuresKey{app: apps[t.App], env: t.Env, version: t.Version, platform: t.Platform}
+
+		n, ok := seen[k]
+		if !ok {
+			n = len(rv)
+			seen[k] = n
+
+			rv = append(rv, snapFeatures{AppID: k.app, Env: t.Env, Version: t.Version, Platform: t.Platform})
+		}
+
+		rv[n].Keys = append(rv[n].Keys, toggle.Key{ID: t.ID, Name: t.Key, Rate: t.Rate})
+	}
+
+	return rv
+}
+
+// save atomically replaces snapshot file.
+func (s *snapshot) save(path string) (err error) {
+	var b []byte
+
+	if b, err = json.Marshal(s); err != nil {
+		return
+	}
+
+	tmp := path + ".tmp"
+
+	if err = ioutil.WriteFile(tmp, b, 0o600); err != nil {
+		return
+	}
+
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *snapshot) index() *snapIndex {
+	x := &snapIndex{
+		takenAt:  s.TakenAt,
+		orgs:     make(map[string]int64),
+		apps:     make(map[appKey]int64),
+		features: make(map[featuresKey]toggle.Keys),
+	}
+
+	for i := 0; i < len(s.Orgs); i++ {
+		o := &s.Orgs[i]
+
+		x.orgs[o.KeyHash] = o.ID
+
+		for name, id := range o.Apps {
+			x.apps[appKey{org: o.ID, name: name}] = id
+		}
+
+		for j := 0; j < len(o.Features); j++ {
+			sf := &o.Features[j]
+
+			x.features[featuresKey{
+				org:      o.ID,
+				app:      sf.AppID,
+				env:      sf.Env,
+				version:  sf.Version,
+				platform: sf.Platform,
+			}] = sf.Keys
+		}
+	}
+
+	return x
+}
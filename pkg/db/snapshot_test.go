@@ -0,0 +1,162 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+var errDown = errors.New("database is down")
+
+// flakyStore fails client lookups, when down.
+type flakyStore struct {
+	db.Store
+	down bool
+}
+
+func (f *flakyStore) GetOrgID(ctx context.Context, apiKey string) (int64, error) {
+	if f.down {
+		return 0, errDown
+	}
+
+	return f.Store.GetOrgID(ctx, apiKey)
+}
+
+func (f *flakyStore) GetAppID(ctx context.Context, orgID int64, app string) (int64, error) {
+	if f.down {
+		return 0, errDown
+	}
+
+	return f.Store.GetAppID(ctx, orgID, app)
+}
+
+func (f *flakyStore) GetAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform string,
+) (toggle.Keys, error) {
+	if f.down {
+		return nil, errDown
+	}
+
+	return f.Store.GetAppFeatures(ctx, orgID, appID, env, version, platform)
+}
+
+func (f *flakyStore) GetOrgs(ctx context.Context) ([]int64, error) {
+	if f.down {
+		return nil, errDown
+	}
+
+	return f.Store.GetOrgs(ctx)
+}
+
+func TestFallback(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	fs := &flakyStore{Store: db.NewMemory()}
+	fb := db.NewFallback(fs, path)
+
+	must := func(err error) {
+		t.Helper()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	must(fb.AddOrg(ctx, "acme", "acme-key", 0, 0))
+
+	select {
+	case <-fb.Changes():
+	default:
+		t.Fatal("no change signal")
+	}
+
+	orgID, err := fb.GetOrgID(ctx, "acme-key")
+	must(err)
+	must(fb.AddApps(ctx, orgID, []string{"web"}))
+
+	appID, err := fb.GetAppID(ctx, orgID, "web")
+	must(err)
+	must(fb.AddAppFeatures(ctx, orgID, appID, "dev", "1.0", []string{"ie6"},
+		toggle.Keys{{Name: "on", Rate: 1}, {Name: "off", Rate: 0}}))
+
+	want, err := fb.GetAppFeatures(ctx, orgID, appID, "dev", "1.0", "ie6")
+	must(err)
+
+	fs.down = true
+
+	if _, err = fb.GetOrgID(ctx, "acme-key"); !errors.Is(err, errDown) {
+		t.Fatalf("without snapshot: err = %v (want: %v)", err, errDown)
+	}
+
+	if !fb.Degraded() {
+		t.Fatal("not degraded")
+	}
+
+	if err = fb.Refresh(ctx); !errors.Is(err, errDown) {
+		t.Fatalf("refresh: err = %v (want: %v)", err, errDown)
+	}
+
+	fs.down = false
+
+	must(fb.Refresh(ctx))
+
+	if fb.Degraded() || fb.TakenAt().IsZero() {
+		t.Fatal("degraded after refresh")
+	}
+
+	fs.down = true
+
+	// snapshot must survive restarts.
+	restarted := db.NewFallback(fs, path)
+	must(restarted.Load())
+
+	for _, f := range []*db.Fallback{fb, restarted} {
+		id, err := f.GetOrgID(ctx, "acme-key")
+		must(err)
+
+		if id != orgID {
+			t.Fatalf("org id = %d (want: %d)", id, orgID)
+		}
+
+		if _, err = f.GetOrgID(ctx, "other-key"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("unknown org: err = %v", err)
+		}
+
+		if id, err = f.GetAppID(ctx, orgID, "WEB"); err != nil || id != appID {
+			t.Fatalf("app id = %d, %v (want: %d)", id, err, appID)
+		}
+
+		if _, err = f.GetAppID(ctx, orgID, "ios"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("unknown app: err = %v", err)
+		}
+
+		keys, err := f.GetAppFeatures(ctx, orgID, appID, "dev", "1.0", "ie6")
+		must(err)
+
+		if !reflect.DeepEqual(keys, want) {
+			t.Fatalf("features = %v (want: %v)", keys, want)
+		}
+
+		if keys, err = f.GetAppFeatures(ctx, orgID, appID, "dev", "2.0", "ie6"); err != nil || len(keys) != 0 {
+			t.Fatalf("unknown segment: %v, %v", keys, err)
+		}
+
+		if !f.Degraded() {
+			t.Fatal("not degraded")
+		}
+	}
+
+	fs.down = false
+
+	if _, err = fb.GetOrgID(ctx, "acme-key"); err != nil || fb.Degraded() {
+		t.Fatalf("not recovered: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+// orgVersions selects ids of all app versions of organization ($1).
+const orgVersions = `SELECT v.id FROM apps_versions v JOIN apps a ON a.id = v.app_id WHERE a.org_id = $1`
+
+// GetOrgs returns ids of all organizations.
+func (s *store) GetOrgs(
+	ctx context.Context,
+) (rv []int64, err error) {
+	const query = `SELECT id FROM orgs ORDER BY id`
+
+	var rows *sql.Rows
+
+	if rows, err = s.db.QueryContext(ctx, query); err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	var id int64
+
+	for rows.Next() {
+		if err = rows.Scan(&id); err != nil {
+			return
+		}
+
+		rv = append(rv, id)
+	}
+
+	return rv, rows.Err()
+}
+
+// GetToggles returns all toggles of organization.
+func (s *store) GetToggles(
+	ctx context.Context,
+	orgID int64,
+) (rv []toggle.Toggle, err error) {
+	const query = `
+SELECT
+	t.id, a.name, v.env, v.version, v.platform, k.key, t.rate, t.updated_at, k.expires_at, t.stale_at
+FROM
+	apps_features_toggles t
+JOIN
+	apps_versions v ON
+		v.id = t.version_id
+JOIN
+	apps a ON
+		a.id = v.app_id
+JOIN
+	apps_features_keys k ON
+		k.id = t.key_id
+WHERE
+	a.org_id = $1
+ORDER BY
+	t.id
+`
+
+	var rows *sql.Rows
+
+	if rows, err = s.db.QueryContext(ctx, query, orgID); err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var t toggle.Toggle
+
+		if err = rows.Scan(
+			&t.ID, &t.App, &t.Env, &t.Version, &t.Platform, &t.Key, &t.Rate, &t.UpdatedAt, &t.ExpiresAt, &t.StaleAt,
+		); err != nil {
+			return
+		}
+
+		rv = append(rv, t)
+	}
+
+	return rv, rows.Err()
+}
+
+// MarkStale flags given toggles of organization as stale, flags for all other toggles are cleared.
+func (s *store) MarkStale(
+	ctx context.Context,
+	orgID int64,
+	ids []int64,
+) error {
+	const (
+		setStale = `
+UPDATE apps_features_toggles
+SET stale_at = COALESCE(stale_at, CURRENT_TIMESTAMP)
+WHERE id = $2 AND version_id IN (` + orgVersions + `)`
+
+		clearStale = `
+UPDATE apps_features_toggles
+SET stale_at = NULL
+WHERE stale_at IS NOT NULL AND version_id IN (` + orgVersions + `)`
+	)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query, args := clearStale, []interface{}{orgID}
+
+	if len(ids) > 0 {
+		parts := make([]string, len(ids))
+
+		for i, id := range ids {
+			parts[i] = fmt.Sprintf("$%d", i+2)
+			args = append(args, id)
+		}
+
+		query += " AND id NOT IN (" + strings.Join(parts, ",") + ")"
+	}
+
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if _, err = tx.ExecContext(ctx, setStale, orgID, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ArchiveToggles moves given toggles of organization to archive, returns number of archived ones.
+func (s *store) ArchiveToggles(
+	ctx context.Context,
+	orgID int64,
+	ids []int64,
+) (count int64, err error) {
+	const (
+		archive = `
+INSERT INTO apps_features_toggles_archive
+	(id, version_id, key_id, rate, updated_at)
+SELECT
+	id, version_id, key_id, rate, updated_at
+FROM
+	apps_features_toggles
+WHERE
+	id = $2 AND version_id IN (` + orgVersions + `)`
+
+		drop = `
+DELETE FROM apps_features_toggles
+WHERE id = $2 AND version_id IN (` + orgVersions + `)`
+	)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+
+	defer tx.Rollback()
+
+	var res sql.Result
+
+	for _, id := range ids {
+		if _, err = tx.ExecContext(ctx, archive, orgID, id); err != nil {
+			return
+		}
+
+		if res, err = tx.ExecContext(ctx, drop, orgID, id); err != nil {
+			return
+		}
+
+		n, _ := res.RowsAffected()
+		count += n
+	}
+
+	return count, tx.Commit()
+}
@@ -3,23 +3,47 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/s0rg/toggle-svc/pkg/manifest"
 	"github.com/s0rg/toggle-svc/pkg/toggle"
 )
 
+var (
+	// ErrQuotaExceeded returned, when organization runs out of its apps or keys quota.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrConflict returned, when added organization or app already exists.
+	ErrConflict = errors.New("already exists")
+)
+
 type store struct {
 	db *sql.DB
 }
 
+// Store holds apps and toggles, every app is scoped by organization id, passed as
+// first argument after context.
 type Store interface {
-	GetApps(context.Context) ([]string, error)
-	GetAppID(context.Context, string) (int64, error)
-	GetAppFeatures(context.Context, int64, string, string) (toggle.Keys, error)
-	AddApps(context.Context, []string) error
-	AddAppFeatures(context.Context, int64, string, []string, toggle.Keys) error
-	EditAppFeature(context.Context, int64, string, string, string, float64) error
+	AddOrg(context.Context, string, string, int, int) error
+	GetOrgID(context.Context, string) (int64, error)
+	GetOrgKeys(context.Context) (map[int64]string, error)
+	GetApps(context.Context, int64) ([]string, error)
+	GetAppID(context.Context, int64, string) (int64, error)
+	GetEnvs(context.Context) ([]string, error)
+	GetAppFeatures(context.Context, int64, int64, string, string, string) (toggle.Keys, error)
+	GetAppKeys(context.Context, int64, int64, toggle.KeyFilter) ([]toggle.KeyInfo, error)
+	EditAppKey(context.Context, int64, int64, string, *toggle.Meta) error
+	AddApps(context.Context, int64, []string) error
+	AddAppFeatures(context.Context, int64, int64, string, string, []string, toggle.Keys) error
+	EditAppFeature(context.Context, int64, int64, string, string, string, string, float64) error
+	PromoteAppFeatures(context.Context, int64, int64, string, string) error
+	GetOrgs(context.Context) ([]int64, error)
+	GetToggles(context.Context, int64) ([]toggle.Toggle, error)
+	GetSegments(context.Context, int64) ([]toggle.Segment, error)
+	MarkStale(context.Context, int64, []int64) error
+	ArchiveToggles(context.Context, int64, []int64) (int64, error)
+	ApplyChanges(context.Context, int64, []manifest.Change) error
 }
 
 // New create new DB store.
@@ -30,11 +54,12 @@ func New(db *sql.DB) Store {
 // GetAppID returns id for given app name.
 func (s *store) GetAppID(
 	ctx context.Context,
+	orgID int64,
 	app string,
 ) (id int64, err error) {
-	const query = `SELECT id FROM apps WHERE name = $1 LIMIT 1`
+	const query = `SELECT id FROM apps WHERE org_id = $1 AND name = $2 LIMIT 1`
 
-	err = s.db.QueryRowContext(ctx, query, strings.ToLower(app)).Scan(&id)
+	err = s.db.QueryRowContext(ctx, query, orgID, strings.ToLower(app)).Scan(&id)
 
 	return
 }
@@ -42,8 +67,36 @@ func (s *store) GetAppID(
 // GetApps returns slice of available app names.
 func (s *store) GetApps(
 	ctx context.Context,
+	orgID int64,
 ) (rv []string, err error) {
-	const query = `SELECT name FROM apps`
+	const query = `SELECT name FROM apps WHERE org_id = $1`
+
+	var rows *sql.Rows
+
+	if rows, err = s.db.QueryContext(ctx, query, orgID); err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	var n string
+
+	for rows.Next() {
+		if err = rows.Scan(&n); err != nil {
+			return
+		}
+
+		rv = append(rv, n)
+	}
+
+	return rv, rows.Err()
+}
+
+// GetEnvs returns slice of environment names, ordered by promotion path.
+func (s *store) GetEnvs(
+	ctx context.Context,
+) (rv []string, err error) {
+	const query = `SELECT name FROM environments ORDER BY position`
 
 	var rows *sql.Rows
 
@@ -69,14 +122,17 @@ func (s *store) GetApps(
 // GetAppFeatures returns slice of toggled features for given params.
 func (s *store) GetAppFeatures(
 	ctx context.Context,
-	appID int64,
-	version, platform string,
+	orgID, appID int64,
+	env, version, platform string,
 ) (rv toggle.Keys, err error) {
 	const query = `
 SELECT
 	t.id, k.key, t.rate
 FROM
 	apps_versions v
+JOIN
+	apps a ON
+		a.id = v.app_id
 JOIN
 	apps_features_keys k ON
 		k.app_id = v.app_id
@@ -86,18 +142,22 @@ JOIN
 		AND
 		t.key_id = k.id
 WHERE
-	v.app_id = $1
+	a.org_id = $1
+	AND
+	v.app_id = $2
+	AND
+	v.env = $3
 	AND
-	v.version = $2
+	v.version = $4
 	AND
-	v.platform = $3
+	v.platform = $5
 	AND
 	t.rate > 0
 `
 
 	var rows *sql.Rows
 
-	if rows, err = s.db.QueryContext(ctx, query, appID, version, platform); err != nil {
+	if rows, err = s.db.QueryContext(ctx, query, orgID, appID, env, version, platform); err != nil {
 		return
 	}
 
@@ -119,9 +179,13 @@ WHERE
 // AddApps adds new app names.
 func (s *store) AddApps(
 	ctx context.Context,
+	orgID int64,
 	apps []string,
 ) error {
-	const queryHead = `INSERT INTO apps(name) VALUES `
+	const (
+		queryHead = `INSERT INTO apps(org_id, name) VALUES `
+		queryTail = ` ON CONFLICT DO NOTHING`
+	)
 
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -130,20 +194,36 @@ func (s *store) AddApps(
 
 	defer tx.Rollback()
 
+	if err = checkQuota(ctx, tx, appsQuota, orgID, len(apps)); err != nil {
+		return err
+	}
+
 	queryParts := make([]string, len(apps))
-	args := make([]interface{}, len(apps))
+	args := make([]interface{}, len(apps)+1)
+	args[0] = orgID
 
 	for i, a := range apps {
-		queryParts[i] = fmt.Sprintf("($%d)", i+1)
-		args[i] = strings.ToLower(a)
+		queryParts[i] = fmt.Sprintf("($1, $%d)", i+2)
+		args[i+1] = strings.ToLower(a)
 	}
 
-	query := queryHead + strings.Join(queryParts, ",")
+	var (
+		res sql.Result
+		n   int64
+	)
 
-	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+	if res, err = tx.ExecContext(ctx, queryHead+strings.Join(queryParts, ",")+queryTail, args...); err != nil {
 		return err
 	}
 
+	if n, err = res.RowsAffected(); err != nil {
+		return err
+	}
+
+	if n != int64(len(apps)) {
+		return ErrConflict
+	}
+
 	return tx.Commit()
 }
 
@@ -153,45 +233,59 @@ func (s *store) getOrCreateKeys(
 	appID int64,
 	keys toggle.Keys,
 ) (rv map[string]int64, err error) {
-	const query = `
-WITH new_app_key AS (
-	INSERT INTO	apps_features_keys
-		(app_id, key)
-	VALUES
-		($1, $2)
-	ON CONFLICT DO NOTHING
-	RETURNING id
-)
-
-SELECT id FROM new_app_key
-UNION
-SELECT id FROM apps_features_keys
-WHERE
-	app_id = $1 AND key = $2
-LIMIT 1
+	const (
+		addKey = `
+INSERT INTO apps_features_keys
+	(app_id, key, description, owner, link, stage, created_by, expires_at)
+VALUES
+	($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT DO NOTHING
 `
+		getKey = `SELECT id FROM apps_features_keys WHERE app_id = $1 AND key = $2`
+	)
 
 	rv = make(map[string]int64)
 
-	var id int64
+	var (
+		res   sql.Result
+		id, n int64
+	)
 
 	for i := 0; i < len(keys); i++ {
-		k := keys[i].Name
+		k, m := keys[i].Name, metaOrDefault(keys[i].Meta)
+
+		if res, err = tx.ExecContext(
+			ctx, addKey, appID, k, m.Description, m.Owner, m.Link, m.Stage, m.CreatedBy, m.ExpiresAt,
+		); err != nil {
+			return
+		}
 
-		if err = tx.QueryRowContext(ctx, query, appID, k).Scan(&id); err != nil {
+		if n, err = res.RowsAffected(); err != nil {
 			return
 		}
 
+		if err = tx.QueryRowContext(ctx, getKey, appID, k).Scan(&id); err != nil {
+			return
+		}
+
+		// meta (tags included) of existing keys is changed only by EditAppKey.
+		if n > 0 {
+			if err = addKeyTags(ctx, tx, id, m.Tags); err != nil {
+				return
+			}
+		}
+
 		rv[k] = id
 	}
 
 	return rv, nil
 }
 
-// AddAppFeatures adds new version, platforms and toggles for given app.
+// AddAppFeatures adds new version, platforms and toggles for given app and environment.
 func (s *store) AddAppFeatures(
 	ctx context.Context,
-	appID int64,
+	orgID, appID int64,
+	env string,
 	version string,
 	platforms []string,
 	keys toggle.Keys,
@@ -199,9 +293,9 @@ func (s *store) AddAppFeatures(
 	const (
 		addVersion = `
 INSERT INTO apps_versions
-	(app_id, version, platform)
+	(app_id, env, version, platform)
 VALUES
-	($1, $2, $3)
+	($1, $2, $3, $4)
 RETURNING id`
 
 		addToggle = `
@@ -219,16 +313,24 @@ VALUES
 
 	defer tx.Rollback()
 
+	if err = checkApp(ctx, tx, orgID, appID); err != nil {
+		return err
+	}
+
 	appKeys, err := s.getOrCreateKeys(ctx, tx, appID, keys)
 	if err != nil {
 		return err
 	}
 
+	if err = checkQuota(ctx, tx, keysQuota, orgID, 0); err != nil {
+		return err
+	}
+
 	var versionID int64
 
 	for i := 0; i < len(platforms); i++ {
 		if err = tx.QueryRowContext(
-			ctx, addVersion, appID, version, platforms[i],
+			ctx, addVersion, appID, env, version, platforms[i],
 		).Scan(
 			&versionID,
 		); err != nil {
@@ -239,7 +341,7 @@ VALUES
 			k := &keys[j]
 
 			if _, err = tx.ExecContext(
-				ctx, addToggle, versionID, appKeys[k.Name], k.Rate,
+				ctx, addToggle, versionID, appKeys[k.Name], toggle.RoundRate(k.Rate),
 			); err != nil {
 				return err
 			}
@@ -252,7 +354,8 @@ VALUES
 // EditAppFeature modifies rate for selected key.
 func (s *store) EditAppFeature(
 	ctx context.Context,
-	appID int64,
+	orgID, appID int64,
+	env string,
 	version string,
 	platform string,
 	key string,
@@ -264,6 +367,9 @@ SELECT
 	t.id
 FROM
 	apps_versions v
+JOIN
+	apps a ON
+		a.id = v.app_id
 JOIN
 	apps_features_keys k ON
 		k.app_id = v.app_id
@@ -273,31 +379,107 @@ JOIN
 		AND
 		t.key_id = k.id
 WHERE
-	v.app_id = $1
+	a.org_id = $1
+	AND
+	v.app_id = $2
 	AND
-	v.version = $2
+	v.env = $3
 	AND
-	v.platform = $3
+	v.version = $4
 	AND
-	k.key = $4
+	v.platform = $5
+	AND
+	k.key = $6
 LIMIT 1
 `
 
 		setRate = `
 UPDATE apps_features_toggles
-SET rate = $2, updated_at = NOW()
+SET rate = $2, updated_at = CURRENT_TIMESTAMP
 WHERE id = $1`
 	)
 
 	var toggleID int64
 
 	if err = s.db.QueryRowContext(
-		ctx, getToggleID, appID, version, platform, key,
+		ctx, getToggleID, orgID, appID, env, version, platform, key,
 	).Scan(&toggleID); err != nil {
 		return
 	}
 
-	_, err = s.db.ExecContext(ctx, setRate, toggleID, rate)
+	_, err = s.db.ExecContext(ctx, setRate, toggleID, toggle.RoundRate(rate))
 
 	return err
 }
+
+// PromoteAppFeatures copies versions, platforms and toggle rates of given app
+// from one environment to another, overwriting rates already present in target.
+func (s *store) PromoteAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	from, to string,
+) error {
+	const (
+		copyVersions = `
+INSERT INTO apps_versions
+	(app_id, env, version, platform)
+SELECT
+	app_id, $3, version, platform
+FROM
+	apps_versions
+WHERE
+	app_id = $1
+	AND
+	env = $2
+ON CONFLICT DO NOTHING
+`
+
+		copyToggles = `
+INSERT INTO apps_features_toggles
+	(version_id, key_id, rate)
+SELECT
+	dst.id, t.key_id, t.rate
+FROM
+	apps_versions src
+JOIN
+	apps_features_toggles t ON
+		t.version_id = src.id
+JOIN
+	apps_versions dst ON
+		dst.app_id = src.app_id
+		AND
+		dst.env = $3
+		AND
+		dst.version = src.version
+		AND
+		dst.platform = src.platform
+WHERE
+	src.app_id = $1
+	AND
+	src.env = $2
+ON CONFLICT (version_id, key_id) DO UPDATE
+SET rate = EXCLUDED.rate, updated_at = CURRENT_TIMESTAMP
+`
+	)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err = checkApp(ctx, tx, orgID, appID); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, copyVersions, appID, from, to); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, copyToggles, appID, from, to); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
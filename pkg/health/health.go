@@ -0,0 +1,165 @@
+// Package health serves liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check statuses.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFail     = "fail"
+)
+
+const checkTimeout = 2 * time.Second
+
+// ErrDegraded should be wrapped by check errors, if service still works without dependency.
+var ErrDegraded = errors.New("degraded")
+
+var errDraining = errors.New("shutting down")
+
+type (
+	// Check tests single dependency.
+	Check struct {
+		Name string
+		// Live marks liveness checks, all checks are used for readiness.
+		Live bool
+		// Do returns nil, if dependency is fine.
+		Do func(context.Context) error
+	}
+
+	// Checker runs checks for probes.
+	Checker struct {
+		checks   []Check
+		draining int32
+	}
+
+	// Result is a check result.
+	Result struct {
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}
+
+	// Report is a probe response.
+	Report struct {
+		Status string            `json:"status"`
+		Checks map[string]Result `json:"checks"`
+	}
+)
+
+// New creates checker with given checks.
+func New(checks ...Check) *Checker {
+	return &Checker{checks: checks}
+}
+
+// Drain makes readiness probe fail, it should be called before shutdown, so load
+// balancers stop sending new requests.
+func (c *Checker) Drain() {
+	atomic.StoreInt32(&c.draining, 1)
+}
+
+// Live runs liveness checks.
+func (c *Checker) Live(ctx context.Context) *Report {
+	return c.run(ctx, true)
+}
+
+// Ready runs all checks, report fails while draining.
+func (c *Checker) Ready(ctx context.Context) (rep *Report) {
+	rep = c.run(ctx, false)
+
+	if atomic.LoadInt32(&c.draining) == 1 {
+		rep.add("shutdown", errDraining)
+	}
+
+	return rep
+}
+
+// LiveHandler serves liveness probe.
+func (c *Checker) LiveHandler() http.HandlerFunc {
+	return serve(c.Live)
+}
+
+// ReadyHandler serves readiness probe.
+func (c *Checker) ReadyHandler() http.HandlerFunc {
+	return serve(c.Ready)
+}
+
+func (c *Checker) run(ctx context.Context, live bool) (rep *Report) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+
+	rep = &Report{Status: StatusOK, Checks: make(map[string]Result)}
+
+	for _, ch := range c.checks {
+		if live && !ch.Live {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(ch Check) {
+			defer wg.Done()
+
+			err := ch.Do(ctx)
+
+			mu.Lock()
+			rep.add(ch.Name, err)
+			mu.Unlock()
+		}(ch)
+	}
+
+	wg.Wait()
+
+	return rep
+}
+
+// add adds check result, report status is the worst of its checks.
+func (r *Report) add(name string, err error) {
+	res := Result{Status: StatusOK}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrDegraded):
+		res = Result{Status: StatusDegraded, Error: err.Error()}
+
+		if r.Status == StatusOK {
+			r.Status = StatusDegraded
+		}
+	default:
+		res = Result{Status: StatusFail, Error: err.Error()}
+		r.Status = StatusFail
+	}
+
+	r.Checks[name] = res
+}
+
+func serve(probe func(context.Context) *Report) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rep := probe(r.Context())
+
+		code := http.StatusOK
+		if rep.Status == StatusFail {
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+
+		if err := json.NewEncoder(w).Encode(rep); err != nil {
+			log.Println("health: response error:", err)
+		}
+	}
+}
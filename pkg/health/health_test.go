@@ -0,0 +1,73 @@
+//nolint:testpackage
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func check(name string, live bool, err error) Check {
+	return Check{Name: name, Live: live, Do: func(context.Context) error { return err }}
+}
+
+func TestProbes(t *testing.T) {
+	errDown := errors.New("down")
+	degraded := fmt.Errorf("%w: %v", ErrDegraded, errDown)
+
+	tests := []struct {
+		name   string
+		checks []Check
+		drain  bool
+		live   string
+		ready  string
+	}{
+		{"ok", []Check{check("db", false, nil), check("workers", true, nil)}, false, StatusOK, StatusOK},
+		{"degraded", []Check{check("db", false, degraded), check("workers", true, nil)}, false, StatusOK, StatusDegraded},
+		{"fail", []Check{check("db", false, errDown), check("redis", false, degraded)}, false, StatusOK, StatusFail},
+		{"dead", []Check{check("db", false, nil), check("workers", true, errDown)}, false, StatusFail, StatusFail},
+		{"draining", []Check{check("db", false, nil)}, true, StatusOK, StatusFail},
+	}
+
+	for _, tc := range tests {
+		c := New(tc.checks...)
+
+		if tc.drain {
+			c.Drain()
+		}
+
+		for _, p := range []struct {
+			h    http.HandlerFunc
+			want string
+		}{
+			{c.LiveHandler(), tc.live},
+			{c.ReadyHandler(), tc.ready},
+		} {
+			rec := httptest.NewRecorder()
+			p.h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			var rep Report
+
+			if err := json.NewDecoder(rec.Body).Decode(&rep); err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+
+			if rep.Status != p.want {
+				t.Fatalf("%s: status = %s (want: %s), report: %+v", tc.name, rep.Status, p.want, rep)
+			}
+
+			code := http.StatusOK
+			if p.want == StatusFail {
+				code = http.StatusServiceUnavailable
+			}
+
+			if rec.Code != code {
+				t.Fatalf("%s: code = %d (want: %d)", tc.name, rec.Code, code)
+			}
+		}
+	}
+}
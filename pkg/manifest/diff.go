@@ -0,0 +1,232 @@
+package manifest
+
+import (
+	"sort"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+// Change operations.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// Change subjects.
+const (
+	KindApp     = "app"
+	KindKey     = "key"
+	KindSegment = "segment"
+	KindToggle  = "toggle"
+)
+
+// Change is a single step, needed to bring one manifest to another.
+type Change struct {
+	Op       string       `json:"op" yaml:"op"`
+	Kind     string       `json:"kind" yaml:"kind"`
+	App      string       `json:"app" yaml:"app"`
+	Key      string       `json:"key,omitempty" yaml:"key,omitempty"`
+	Env      string       `json:"env,omitempty" yaml:"env,omitempty"`
+	Version  string       `json:"version,omitempty" yaml:"version,omitempty"`
+	Platform string       `json:"platform,omitempty" yaml:"platform,omitempty"`
+	Rate     float64      `json:"rate" yaml:"rate"`
+	Meta     *toggle.Meta `json:"meta,omitempty" yaml:"meta,omitempty"`
+}
+
+// Diff returns changes, that turns `have` (normalized) manifest into `want` one, changes
+// are ordered: all deletions (toggles first, apps last) go before creations and updates
+// (apps first, toggles last).
+func Diff(have, want *Manifest) (rv []Change) {
+	var (
+		haveApps, haveNames = appsMap(have)
+		wantApps, wantNames = appsMap(want)
+		dels                [4][]Change
+		adds                [4][]Change
+	)
+
+	for _, name := range union(haveNames, wantNames) {
+		h, w := haveApps[name], wantApps[name]
+
+		switch {
+		case w == nil:
+			dels[0] = append(dels[0], Change{Op: OpDelete, Kind: KindApp, App: name})
+
+			continue
+		case h == nil:
+			adds[0] = append(adds[0], Change{Op: OpCreate, Kind: KindApp, App: name})
+			h = &App{Name: name}
+		}
+
+		diffKeys(h, w, &dels[1], &adds[1])
+		diffSegments(h, w, &dels, &adds)
+	}
+
+	for i := len(dels) - 1; i >= 0; i-- {
+		rv = append(rv, dels[i]...)
+	}
+
+	for i := 0; i < len(adds); i++ {
+		rv = append(rv, adds[i]...)
+	}
+
+	return rv
+}
+
+func diffKeys(have, want *App, dels, adds *[]Change) {
+	hk, hn := keysMap(have)
+	wk, wn := keysMap(want)
+
+	for _, name := range union(hn, wn) {
+		h, w := hk[name], wk[name]
+
+		switch {
+		case w == nil:
+			*dels = append(*dels, Change{Op: OpDelete, Kind: KindKey, App: have.Name, Key: name})
+		case h == nil:
+			*adds = append(*adds, Change{Op: OpCreate, Kind: KindKey, App: want.Name, Key: name, Meta: w.Meta()})
+		case !sameMeta(h, w):
+			*adds = append(*adds, Change{Op: OpUpdate, Kind: KindKey, App: want.Name, Key: name, Meta: w.Meta()})
+		}
+	}
+}
+
+func diffSegments(have, want *App, dels, adds *[4][]Change) {
+	hs, hn := segmentsMap(have)
+	ws, wn := segmentsMap(want)
+
+	for _, id := range union(hn, wn) {
+		h, w := hs[id], ws[id]
+
+		if w == nil {
+			dels[2] = append(dels[2], segmentChange(OpDelete, KindSegment, have.Name, h))
+
+			continue
+		}
+
+		if h == nil {
+			adds[2] = append(adds[2], segmentChange(OpCreate, KindSegment, want.Name, w))
+			h = &Segment{}
+		}
+
+		for _, key := range union(rateKeys(h.Rates), rateKeys(w.Rates)) {
+			hr, hok := h.Rates[key]
+			wr, wok := w.Rates[key]
+
+			c := segmentChange("", KindToggle, want.Name, w)
+			c.Key, c.Rate = key, wr
+
+			switch {
+			case !wok:
+				c.Op = OpDelete
+				dels[3] = append(dels[3], c)
+			case !hok:
+				c.Op = OpCreate
+				adds[3] = append(adds[3], c)
+			case hr != wr:
+				c.Op = OpUpdate
+				adds[3] = append(adds[3], c)
+			}
+		}
+	}
+}
+
+func segmentChange(op, kind, app string, s *Segment) Change {
+	return Change{
+		Op:       op,
+		Kind:     kind,
+		App:      app,
+		Env:      s.Env,
+		Version:  s.Version,
+		Platform: s.Platform,
+	}
+}
+
+func sameMeta(a, b *Key) bool {
+	if a.Description != b.Description ||
+		a.Owner != b.Owner ||
+		a.Link != b.Link ||
+		a.Stage != b.Stage ||
+		a.CreatedBy != b.CreatedBy ||
+		len(a.Tags) != len(b.Tags) {
+		return false
+	}
+
+	for i := 0; i < len(a.Tags); i++ {
+		if a.Tags[i] != b.Tags[i] {
+			return false
+		}
+	}
+
+	switch {
+	case a.ExpiresAt == nil || b.ExpiresAt == nil:
+		return a.ExpiresAt == b.ExpiresAt
+	default:
+		return a.ExpiresAt.Equal(*b.ExpiresAt)
+	}
+}
+
+func appsMap(m *Manifest) (rv map[string]*App, names []string) {
+	rv = make(map[string]*App, len(m.Apps))
+
+	for i := 0; i < len(m.Apps); i++ {
+		a := &m.Apps[i]
+		rv[a.Name] = a
+		names = append(names, a.Name)
+	}
+
+	return rv, names
+}
+
+func keysMap(a *App) (rv map[string]*Key, names []string) {
+	rv = make(map[string]*Key, len(a.Keys))
+
+	for i := 0; i < len(a.Keys); i++ {
+		k := &a.Keys[i]
+		rv[k.Name] = k
+		names = append(names, k.Name)
+	}
+
+	return rv, names
+}
+
+func segmentsMap(a *App) (rv map[string]*Segment, ids []string) {
+	rv = make(map[string]*Segment, len(a.Segments))
+
+	for i := 0; i < len(a.Segments); i++ {
+		s := &a.Segments[i]
+		id := segmentID(s.Env, s.Version, s.Platform)
+		rv[id] = s
+		ids = append(ids, id)
+	}
+
+	return rv, ids
+}
+
+func rateKeys(m map[string]float64) (rv []string) {
+	for k := range m {
+		rv = append(rv, k)
+	}
+
+	return rv
+}
+
+// union returns sorted set of strings from both slices.
+func union(a, b []string) (rv []string) {
+	seen := make(map[string]struct{}, len(a)+len(b))
+
+	for _, l := range [][]string{a, b} {
+		for _, s := range l {
+			if _, ok := seen[s]; ok {
+				continue
+			}
+
+			seen[s] = struct{}{}
+			rv = append(rv, s)
+		}
+	}
+
+	sort.Strings(rv)
+
+	return rv
+}
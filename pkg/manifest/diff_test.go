@@ -0,0 +1,182 @@
+//nolint:testpackage
+package manifest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+const (
+	haveYAML = `
+apps:
+  - name: web
+    keys:
+      - name: a
+      - name: b
+        owner: team
+    segments:
+      - env: production
+        version: "1.0"
+        platform: ie6
+        rates:
+          a: 1
+          b: 0.5
+  - name: old
+`
+
+	wantYAML = `
+apps:
+  - name: Web
+    keys:
+      - name: a
+      - name: b
+        owner: other
+      - name: c
+    segments:
+      - env: production
+        version: "1.0"
+        platform: ie6
+        rates:
+          a: 0.5
+          c: 1
+  - name: new
+`
+)
+
+func mustDecode(t *testing.T, s string) *Manifest {
+	t.Helper()
+
+	m, err := Decode(strings.NewReader(s), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestDiff(t *testing.T) {
+	have, want := mustDecode(t, haveYAML), mustDecode(t, wantYAML)
+
+	var expect = []struct {
+		op, kind, app, key string
+	}{
+		{OpDelete, KindToggle, "web", "b"},
+		{OpDelete, KindApp, "old", ""},
+		{OpCreate, KindApp, "new", ""},
+		{OpUpdate, KindKey, "web", "b"},
+		{OpCreate, KindKey, "web", "c"},
+		{OpUpdate, KindToggle, "web", "a"},
+		{OpCreate, KindToggle, "web", "c"},
+	}
+
+	changes := Diff(have, want)
+
+	if len(changes) != len(expect) {
+		t.Fatalf("changes: %d (want: %d): %+v", len(changes), len(expect), changes)
+	}
+
+	for n, e := range expect {
+		c := &changes[n]
+
+		if c.Op != e.op || c.Kind != e.kind || c.App != e.app || c.Key != e.key {
+			t.Fatalf("step %d: change = %+v (want: %+v)", n, c, e)
+		}
+	}
+
+	if c := Diff(want, want); len(c) != 0 {
+		t.Fatalf("self diff: %+v", c)
+	}
+}
+
+func TestDiffAuthor(t *testing.T) {
+	have := mustDecode(t, `apps: [{name: web, keys: [{name: a, created_by: bob}]}]`)
+	want := mustDecode(t, `apps: [{name: web, keys: [{name: a, created_by: alice}]}]`)
+
+	if c := Diff(have, want); len(c) != 1 || c[0].Op != OpUpdate || c[0].Meta.CreatedBy != "alice" {
+		t.Fatalf("changes: %+v", c)
+	}
+}
+
+func TestDiffRates(t *testing.T) {
+	seg := `{env: production, version: "1.0", platform: ie6, rates: {a: %s}}`
+	decode := func(rate string) *Manifest {
+		return mustDecode(t, `apps: [{name: web, keys: [{name: a}], segments: [`+strings.Replace(seg, "%s", rate, 1)+`]}]`)
+	}
+
+	// rates are compared as database stores them.
+	if c := Diff(decode("0.33"), decode("0.333")); len(c) != 0 {
+		t.Fatalf("rounded rate: %+v", c)
+	}
+
+	c := Diff(decode("0.33"), decode("0"))
+	if len(c) != 1 || c[0].Op != OpUpdate || c[0].Rate != 0 {
+		t.Fatalf("changes: %+v", c)
+	}
+
+	var b strings.Builder
+
+	if err := EncodeChanges(&b, c, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(b.String(), `"rate": 0`) {
+		t.Fatalf("zero rate is not shown: %s", b.String())
+	}
+}
+
+func TestBuild(t *testing.T) {
+	m := Build(
+		[]string{"web"},
+		map[string][]toggle.KeyInfo{"web": {{Name: "a"}}},
+		[]toggle.Segment{
+			{App: "web", Env: "production", Version: "2.0", Platform: "ie6"},
+			{App: "web", Env: "production", Version: "1.0", Platform: "ie6"},
+			{App: "gone", Env: "production", Version: "1.0", Platform: "ie6"},
+		},
+		[]toggle.Toggle{
+			{App: "web", Env: "production", Version: "1.0", Platform: "ie6", Key: "a", Rate: 0.5},
+			{App: "web", Env: "dev", Version: "1.0", Platform: "ie6", Key: "a", Rate: 1},
+		},
+	)
+
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Apps) != 1 || len(m.Apps[0].Segments) != 2 {
+		t.Fatalf("manifest: %+v", m)
+	}
+
+	// segment without toggles is kept.
+	if s := m.Apps[0].Segments; s[0].Rates["a"] != 0.5 || s[1].Version != "2.0" || len(s[1].Rates) != 0 {
+		t.Fatalf("segments: %+v", s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var table = []string{
+		`apps: [{name: ""}]`,
+		`apps: [{name: a}, {name: a}]`,
+		`apps: [{name: a, keys: [{name: k, stage: bogus}]}]`,
+		`apps: [{name: a, segments: [{env: dev, version: "1", platform: p, rates: {k: 1}}]}]`,
+		`apps: [{name: a, keys: [{name: k}], segments: [{env: dev, version: "1", platform: p, rates: {k: 2}}]}]`,
+		`apps: [{name: a, keys: [{name: k}], segments: [{version: "1", platform: p, rates: {k: 1}}]}]`,
+	}
+
+	for n, s := range table {
+		m, err := Decode(strings.NewReader(s), FormatYAML)
+		if err != nil {
+			t.Fatalf("step %d: decode: %v", n, err)
+		}
+
+		if err = m.Validate(); err == nil {
+			t.Fatalf("step %d: no error", n)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+// Supported manifest formats.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+var (
+	// ErrInvalid returned by Validate for inconsistent manifests.
+	ErrInvalid       = errors.New("invalid manifest")
+	errUnknownFormat = errors.New("unknown format")
+)
+
+type (
+	// Manifest describes toggles configuration of organization.
+	Manifest struct {
+		Apps []App `json:"apps" yaml:"apps"`
+	}
+
+	// App holds app keys and its toggles.
+	App struct {
+		Name     string    `json:"name" yaml:"name"`
+		Keys     []Key     `json:"keys,omitempty" yaml:"keys,omitempty"`
+		Segments []Segment `json:"segments,omitempty" yaml:"segments,omitempty"`
+	}
+
+	// Key is an app toggle key with its meta.
+	Key struct {
+		Name        string     `json:"name" yaml:"name"`
+		Description string     `json:"description,omitempty" yaml:"description,omitempty"`
+		Owner       string     `json:"owner,omitempty" yaml:"owner,omitempty"`
+		Tags        []string   `json:"tags,omitempty" yaml:"tags,omitempty"`
+		Link        string     `json:"link,omitempty" yaml:"link,omitempty"`
+		Stage       string     `json:"stage,omitempty" yaml:"stage,omitempty"`
+		CreatedBy   string     `json:"created_by,omitempty" yaml:"created_by,omitempty"`
+		ExpiresAt   *time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	}
+
+	// Segment holds keys rates for app env, version and platform.
+	Segment struct {
+		Env      string             `json:"env" yaml:"env"`
+		Version  string             `json:"version" yaml:"version"`
+		Platform string             `json:"platform" yaml:"platform"`
+		Rates    map[string]float64 `json:"rates" yaml:"rates"`
+	}
+)
+
+// Decode reads manifest in given format.
+func Decode(r io.Reader, format string) (m *Manifest, err error) {
+	m = &Manifest{}
+
+	switch format {
+	case FormatJSON, "":
+		err = json.NewDecoder(r).Decode(m)
+	case FormatYAML:
+		err = yaml.NewDecoder(r).Decode(m)
+	default:
+		err = errUnknownFormat
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	m.normalize()
+
+	return m, nil
+}
+
+// Encode writes manifest in given format.
+func (m *Manifest) Encode(w io.Writer, format string) error {
+	return encode(w, m, format)
+}
+
+// EncodeChanges writes changes in given format.
+func EncodeChanges(w io.Writer, changes []Change, format string) error {
+	return encode(w, changes, format)
+}
+
+func encode(w io.Writer, v interface{}, format string) (err error) {
+	switch format {
+	case FormatJSON, "":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(v)
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+
+		if err = enc.Encode(v); err != nil {
+			return
+		}
+
+		err = enc.Close()
+	default:
+		err = errUnknownFormat
+	}
+
+	return err
+}
+
+// Build assembles manifest from apps, their keys, segments and toggles, segments without
+// toggles are kept as well.
+func Build(
+	apps []string,
+	keys map[string][]toggle.KeyInfo,
+	segments []toggle.Segment,
+	toggles []toggle.Toggle,
+) *Manifest {
+	m := &Manifest{Apps: make([]App, len(apps))}
+	idx := make(map[string]int, len(apps))
+
+	for i, name := range apps {
+		app := &m.Apps[i]
+		app.Name = name
+		idx[name] = i
+
+		for _, k := range keys[name] {
+			app.Keys = append(app.Keys, keyFromInfo(&k))
+		}
+	}
+
+	for i := 0; i < len(segments); i++ {
+		s := &segments[i]
+
+		if ai, ok := idx[s.App]; ok {
+			app := &m.Apps[ai]
+			app.Segments = append(app.Segments, Segment{
+				Env:      s.Env,
+				Version:  s.Version,
+				Platform: s.Platform,
+				Rates:    make(map[string]float64),
+			})
+		}
+	}
+
+	// segments are indexed after all of them are appended, as append may move them.
+	segs := make(map[string]*Segment)
+
+	for i := 0; i < len(m.Apps); i++ {
+		app := &m.Apps[i]
+
+		for j := 0; j < len(app.Segments); j++ {
+			s := &app.Segments[j]
+			segs[app.Name+"/"+segmentID(s.Env, s.Version, s.Platform)] = s
+		}
+	}
+
+	for i := 0; i < len(toggles); i++ {
+		t := &toggles[i]
+
+		if seg, ok := segs[t.App+"/"+segmentID(t.Env, t.Version, t.Platform)]; ok {
+			seg.Rates[t.Key] = t.Rate
+		}
+	}
+
+	m.normalize()
+
+	return m
+}
+
+// Validate checks (normalized) manifest for consistency.
+func (m *Manifest) Validate() error {
+	apps := make(map[string]struct{}, len(m.Apps))
+
+	for i := 0; i < len(m.Apps); i++ {
+		app := &m.Apps[i]
+
+		if app.Name == "" {
+			return fmt.Errorf("%w: app #%d has no name", ErrInvalid, i)
+		}
+
+		if _, ok := apps[app.Name]; ok {
+			return fmt.Errorf("%w: app '%s' duplicated", ErrInvalid, app.Name)
+		}
+
+		apps[app.Name] = struct{}{}
+		keys := make(map[string]struct{}, len(app.Keys))
+
+		for j := 0; j < len(app.Keys); j++ {
+			k := &app.Keys[j]
+
+			if _, ok := keys[k.Name]; ok || k.Name == "" {
+				return fmt.Errorf("%w: app '%s' key '%s' empty or duplicated", ErrInvalid, app.Name, k.Name)
+			}
+
+			if !toggle.ValidStage(k.Stage) {
+				return fmt.Errorf("%w: app '%s' key '%s' has unknown stage '%s'", ErrInvalid, app.Name, k.Name, k.Stage)
+			}
+
+			keys[k.Name] = struct{}{}
+		}
+
+		segs := make(map[string]struct{}, len(app.Segments))
+
+		for j := 0; j < len(app.Segments); j++ {
+			s := &app.Segments[j]
+			sk := segmentID(s.Env, s.Version, s.Platform)
+
+			if s.Env == "" || s.Version == "" || s.Platform == "" {
+				return fmt.Errorf("%w: app '%s' segment '%s' incomplete", ErrInvalid, app.Name, sk)
+			}
+
+			if _, ok := segs[sk]; ok {
+				return fmt.Errorf("%w: app '%s' segment '%s' duplicated", ErrInvalid, app.Name, sk)
+			}
+
+			segs[sk] = struct{}{}
+
+			for k, r := range s.Rates {
+				if _, ok := keys[k]; !ok {
+					return fmt.Errorf("%w: app '%s' segment '%s' has undeclared key '%s'", ErrInvalid, app.Name, sk, k)
+				}
+
+				if r < 0 || r > 1 {
+					return fmt.Errorf("%w: app '%s' segment '%s' key '%s' rate out of range", ErrInvalid, app.Name, sk, k)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// normalize brings manifest to canonical form: lowercase app names and tags,
+// default stages and envs, rates rounded as database stores them, everything sorted.
+func (m *Manifest) normalize() {
+	for i := 0; i < len(m.Apps); i++ {
+		app := &m.Apps[i]
+		app.Name = strings.ToLower(app.Name)
+
+		for j := 0; j < len(app.Keys); j++ {
+			k := &app.Keys[j]
+
+			if k.Stage == "" {
+				k.Stage = toggle.StageRelease
+			}
+
+			for n := 0; n < len(k.Tags); n++ {
+				k.Tags[n] = strings.ToLower(k.Tags[n])
+			}
+
+			sort.Strings(k.Tags)
+		}
+
+		for j := 0; j < len(app.Segments); j++ {
+			rates := app.Segments[j].Rates
+
+			for k, r := range rates {
+				rates[k] = toggle.RoundRate(r)
+			}
+		}
+
+		sort.Slice(app.Keys, func(a, b int) bool {
+			return app.Keys[a].Name < app.Keys[b].Name
+		})
+
+		sort.Slice(app.Segments, func(a, b int) bool {
+			sa, sb := &app.Segments[a], &app.Segments[b]
+
+			return segmentID(sa.Env, sa.Version, sa.Platform) < segmentID(sb.Env, sb.Version, sb.Platform)
+		})
+	}
+
+	sort.Slice(m.Apps, func(a, b int) bool {
+		return m.Apps[a].Name < m.Apps[b].Name
+	})
+}
+
+// Meta returns toggle meta for key.
+func (k *Key) Meta() *toggle.Meta {
+	return &toggle.Meta{
+		Description: k.Description,
+		Owner:       k.Owner,
+		Tags:        k.Tags,
+		Link:        k.Link,
+		Stage:       k.Stage,
+		CreatedBy:   k.CreatedBy,
+		ExpiresAt:   k.ExpiresAt,
+	}
+}
+
+func keyFromInfo(k *toggle.KeyInfo) Key {
+	return Key{
+		Name:        k.Name,
+		Description: k.Description,
+		Owner:       k.Owner,
+		Tags:        k.Tags,
+		Link:        k.Link,
+		Stage:       k.Stage,
+		CreatedBy:   k.CreatedBy,
+		ExpiresAt:   k.ExpiresAt,
+	}
+}
+
+func segmentID(env, version, platform string) string {
+	return strings.Join([]string{env, version, platform}, "/")
+}
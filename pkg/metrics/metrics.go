@@ -0,0 +1,172 @@
+// Package metrics holds prometheus metrics of service.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+const namespace = "toggle"
+
+var (
+	toggleLabels = []string{"org", "app", "env", "version", "platform", "key"}
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of api requests by route and response code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "code"})
+
+	dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_call_duration_seconds",
+		Help:      "Duration of database calls by store method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"op"})
+
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_call_duration_seconds",
+		Help:      "Duration of redis calls by store method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"op"})
+
+	expiryTracked = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "expiry_tracked_states",
+		Help:      "Number of client states in expiry index.",
+	})
+
+	expiryOverdue = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "expiry_overdue_states",
+		Help:      "Number of client states, which deadlines passed, but they were not reaped yet.",
+	})
+
+	reaperDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reaper_dropped_states_total",
+		Help:      "Number of dead client states, dropped by reaper.",
+	})
+
+	clientStates = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "client_states",
+		Help:      "Number of live client states, as counted by segments counters.",
+	})
+
+	togglesEnabled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "enabled_clients",
+		Help:      "Number of clients, that have toggle enabled.",
+	}, toggleLabels)
+
+	togglesTotal = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "total_clients",
+		Help:      "Number of clients in toggle segment.",
+	}, toggleLabels)
+)
+
+// Handler serves metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRequest records api request duration.
+func ObserveRequest(route string, code int, d time.Duration) {
+	requestDuration.WithLabelValues(route, strconv.Itoa(code)).Observe(d.Seconds())
+}
+
+// SetExpiry records expiry index stats.
+func SetExpiry(tracked, overdue int64) {
+	expiryTracked.Set(float64(tracked))
+	expiryOverdue.Set(float64(overdue))
+}
+
+// AddReaped records number of dropped dead states.
+func AddReaped(n int) {
+	reaperDropped.Add(float64(n))
+}
+
+// Rollout holds clients counters of single toggle.
+type Rollout struct {
+	Segment toggle.Segment
+	Key     string
+	Enabled int64
+	Total   int64
+}
+
+// SetRollouts replaces toggles gauges with given ones, `states` is a total number of live client states.
+func SetRollouts(states int64, rollouts []Rollout) {
+	clientStates.Set(float64(states))
+
+	togglesEnabled.Reset()
+	togglesTotal.Reset()
+
+	for i := 0; i < len(rollouts); i++ {
+		r := &rollouts[i]
+		s := &r.Segment
+		labels := []string{strconv.FormatInt(s.Org, 10), s.App, s.Env, s.Version, s.Platform, r.Key}
+
+		togglesEnabled.WithLabelValues(labels...).Set(float64(r.Enabled))
+		togglesTotal.WithLabelValues(labels...).Set(float64(r.Total))
+	}
+}
+
+// WatchFallback exports degraded mode status and snapshot time.
+func WatchFallback(degraded func() bool, takenAt func() time.Time) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "degraded",
+		Help:      "Is 1, when database fails and clients are served from snapshot.",
+	}, func() float64 {
+		if degraded() {
+			return 1
+		}
+
+		return 0
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "snapshot_timestamp_seconds",
+		Help:      "Time of current configuration snapshot, zero if there is none.",
+	}, func() float64 {
+		t := takenAt()
+		if t.IsZero() {
+			return 0
+		}
+
+		return float64(t.Unix())
+	})
+}
+
+// WatchBreaker exports circuit breaker state: 0 - closed, 1 - half-open, 2 - open.
+func WatchBreaker(name string, state func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "breaker_state",
+		Help:        "Circuit breaker state: 0 - closed, 1 - half-open (probe is allowed), 2 - open.",
+		ConstLabels: prometheus.Labels{"name": name},
+	}, func() float64 {
+		return float64(state())
+	})
+}
+
+// timer returns function, that records time passed since timer call.
+func timer(h *prometheus.HistogramVec, op string) func() {
+	start := time.Now()
+
+	return func() {
+		h.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	}
+}
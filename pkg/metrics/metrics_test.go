@@ -0,0 +1,43 @@
+package metrics //nolint:testpackage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+func TestSetRollouts(t *testing.T) {
+	seg := toggle.Segment{Org: 1, App: "ios", Env: "dev", Version: "1.0", Platform: "iphone"}
+
+	SetRollouts(10, []Rollout{
+		{Segment: seg, Key: "a", Enabled: 3, Total: 10},
+		{Segment: seg, Key: "b", Enabled: 7, Total: 10},
+	})
+
+	if got := testutil.ToFloat64(togglesEnabled.WithLabelValues("1", "ios", "dev", "1.0", "iphone", "b")); got != 7 {
+		t.Fatalf("enabled = %v (want: 7)", got)
+	}
+
+	if got := testutil.ToFloat64(clientStates); got != 10 {
+		t.Fatalf("states = %v (want: 10)", got)
+	}
+
+	// toggles, that are gone, must be dropped.
+	SetRollouts(0, []Rollout{{Segment: seg, Key: "a"}})
+
+	if n := testutil.CollectAndCount(togglesTotal); n != 1 {
+		t.Fatalf("total series = %d (want: 1)", n)
+	}
+}
+
+func TestObserveRequest(t *testing.T) {
+	ObserveRequest("test", 200, time.Millisecond)
+	ObserveRequest("test", 500, time.Millisecond)
+
+	if n := testutil.CollectAndCount(requestDuration); n != 2 {
+		t.Fatalf("series = %d (want: 2)", n)
+	}
+}
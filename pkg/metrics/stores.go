@@ -0,0 +1,234 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+	"github.com/s0rg/toggle-svc/pkg/redis"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+type (
+	dbStore struct {
+		s db.Store
+	}
+
+	redisStore struct {
+		s redis.Store
+	}
+)
+
+// DB records latencies of store calls.
+func DB(s db.Store) db.Store {
+	return &dbStore{s: s}
+}
+
+// Redis records latencies of store calls.
+func Redis(s redis.Store) redis.Store {
+	return &redisStore{s: s}
+}
+
+func (d *dbStore) AddOrg(ctx context.Context, name, apiKey string, maxApps, maxKeys int) error {
+	defer timer(dbDuration, "AddOrg")()
+
+	return d.s.AddOrg(ctx, name, apiKey, maxApps, maxKeys)
+}
+
+func (d *dbStore) GetOrgID(ctx context.Context, apiKey string) (int64, error) {
+	defer timer(dbDuration, "GetOrgID")()
+
+	return d.s.GetOrgID(ctx, apiKey)
+}
+
+func (d *dbStore) GetOrgKeys(ctx context.Context) (map[int64]string, error) {
+	defer timer(dbDuration, "GetOrgKeys")()
+
+	return d.s.GetOrgKeys(ctx)
+}
+
+func (d *dbStore) GetApps(ctx context.Context, orgID int64) ([]string, error) {
+	defer timer(dbDuration, "GetApps")()
+
+	return d.s.GetApps(ctx, orgID)
+}
+
+func (d *dbStore) GetAppID(ctx context.Context, orgID int64, app string) (int64, error) {
+	defer timer(dbDuration, "GetAppID")()
+
+	return d.s.GetAppID(ctx, orgID, app)
+}
+
+func (d *dbStore) GetEnvs(ctx context.Context) ([]string, error) {
+	defer timer(dbDuration, "GetEnvs")()
+
+	return d.s.GetEnvs(ctx)
+}
+
+func (d *dbStore) GetAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform string,
+) (toggle.Keys, error) {
+	defer timer(dbDuration, "GetAppFeatures")()
+
+	return d.s.GetAppFeatures(ctx, orgID, appID, env, version, platform)
+}
+
+func (d *dbStore) GetAppKeys(
+	ctx context.Context,
+	orgID, appID int64,
+	filter toggle.KeyFilter,
+) ([]toggle.KeyInfo, error) {
+	defer timer(dbDuration, "GetAppKeys")()
+
+	return d.s.GetAppKeys(ctx, orgID, appID, filter)
+}
+
+func (d *dbStore) EditAppKey(ctx context.Context, orgID, appID int64, key string, meta *toggle.Meta) error {
+	defer timer(dbDuration, "EditAppKey")()
+
+	return d.s.EditAppKey(ctx, orgID, appID, key, meta)
+}
+
+func (d *dbStore) AddApps(ctx context.Context, orgID int64, names []string) error {
+	defer timer(dbDuration, "AddApps")()
+
+	return d.s.AddApps(ctx, orgID, names)
+}
+
+func (d *dbStore) AddAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version string,
+	platforms []string,
+	features toggle.Keys,
+) error {
+	defer timer(dbDuration, "AddAppFeatures")()
+
+	return d.s.AddAppFeatures(ctx, orgID, appID, env, version, platforms, features)
+}
+
+func (d *dbStore) EditAppFeature(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform, key string,
+	rate float64,
+) error {
+	defer timer(dbDuration, "EditAppFeature")()
+
+	return d.s.EditAppFeature(ctx, orgID, appID, env, version, platform, key, rate)
+}
+
+func (d *dbStore) PromoteAppFeatures(ctx context.Context, orgID, appID int64, from, to string) error {
+	defer timer(dbDuration, "PromoteAppFeatures")()
+
+	return d.s.PromoteAppFeatures(ctx, orgID, appID, from, to)
+}
+
+func (d *dbStore) GetOrgs(ctx context.Context) ([]int64, error) {
+	defer timer(dbDuration, "GetOrgs")()
+
+	return d.s.GetOrgs(ctx)
+}
+
+func (d *dbStore) GetToggles(ctx context.Context, orgID int64) ([]toggle.Toggle, error) {
+	defer timer(dbDuration, "GetToggles")()
+
+	return d.s.GetToggles(ctx, orgID)
+}
+
+func (d *dbStore) GetSegments(ctx context.Context, orgID int64) ([]toggle.Segment, error) {
+	defer timer(dbDuration, "GetSegments")()
+
+	return d.s.GetSegments(ctx, orgID)
+}
+
+func (d *dbStore) MarkStale(ctx context.Context, orgID int64, ids []int64) error {
+	defer timer(dbDuration, "MarkStale")()
+
+	return d.s.MarkStale(ctx, orgID, ids)
+}
+
+func (d *dbStore) ArchiveToggles(ctx context.Context, orgID int64, ids []int64) (int64, error) {
+	defer timer(dbDuration, "ArchiveToggles")()
+
+	return d.s.ArchiveToggles(ctx, orgID, ids)
+}
+
+func (d *dbStore) ApplyChanges(ctx context.Context, orgID int64, changes []manifest.Change) error {
+	defer timer(dbDuration, "ApplyChanges")()
+
+	return d.s.ApplyChanges(ctx, orgID, changes)
+}
+
+func (r *redisStore) ClientsCount(ctx context.Context, seg toggle.Segment) (int64, error) {
+	defer timer(redisDuration, "ClientsCount")()
+
+	return r.s.ClientsCount(ctx, seg)
+}
+
+func (r *redisStore) MarkAlive(ctx context.Context, org int64, key string) error {
+	defer timer(redisDuration, "MarkAlive")()
+
+	return r.s.MarkAlive(ctx, org, key)
+}
+
+func (r *redisStore) DropState(ctx context.Context, org int64, key string) error {
+	defer timer(redisDuration, "DropState")()
+
+	return r.s.DropState(ctx, org, key)
+}
+
+func (r *redisStore) GetState(ctx context.Context, org int64, key string) ([]int64, bool, error) {
+	defer timer(redisDuration, "GetState")()
+
+	return r.s.GetState(ctx, org, key)
+}
+
+func (r *redisStore) IsAlive(ctx context.Context, org int64, key string) (bool, error) {
+	defer timer(redisDuration, "IsAlive")()
+
+	return r.s.IsAlive(ctx, org, key)
+}
+
+func (r *redisStore) TogglesAssign(ctx context.Context, seg toggle.Segment, keys toggle.Keys) (string, error) {
+	defer timer(redisDuration, "TogglesAssign")()
+
+	return r.s.TogglesAssign(ctx, seg, keys)
+}
+
+func (r *redisStore) TogglesCount(ctx context.Context, seg toggle.Segment, ids []int64) ([]int64, error) {
+	defer timer(redisDuration, "TogglesCount")()
+
+	return r.s.TogglesCount(ctx, seg, ids)
+}
+
+func (r *redisStore) ReapExpired(ctx context.Context, now time.Time, limit int) (claimed, dropped int, err error) {
+	defer timer(redisDuration, "ReapExpired")()
+
+	return r.s.ReapExpired(ctx, now, limit)
+}
+
+func (r *redisStore) ExpiryStats(ctx context.Context, now time.Time) (tracked, overdue int64, err error) {
+	defer timer(redisDuration, "ExpiryStats")()
+
+	return r.s.ExpiryStats(ctx, now)
+}
+
+func (r *redisStore) ExpiryEntries(ctx context.Context, offset, limit int) ([]redis.Tracked, error) {
+	defer timer(redisDuration, "ExpiryEntries")()
+
+	return r.s.ExpiryEntries(ctx, offset, limit)
+}
+
+func (r *redisStore) Reconcile(ctx context.Context, fix bool) (*redis.Report, error) {
+	defer timer(redisDuration, "Reconcile")()
+
+	return r.s.Reconcile(ctx, fix)
+}
+
+func (r *redisStore) SetExpiration(d time.Duration) {
+	r.s.SetExpiration(d)
+}
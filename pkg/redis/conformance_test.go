@@ -0,0 +1,115 @@
+package redis_test
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mediocregopher/radix/v3"
+
+	"github.com/s0rg/toggle-svc/pkg/redis"
+	"github.com/s0rg/toggle-svc/pkg/redis/redistest"
+)
+
+// envRedisAddr holds address of redis server, used for tests, its database will be flushed.
+const envRedisAddr = "TOGGLE_TEST_REDIS"
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func TestMemoryStore(t *testing.T) {
+	redistest.Run(t, func(*testing.T) *redistest.Backend {
+		c := &clock{now: time.Now()}
+		s := redis.NewMemoryWithClock(redistest.TTL, c.Now)
+
+		return &redistest.Backend{
+			Store:   func() redis.Store { return s },
+			Advance: c.Advance,
+			Now:     c.Now,
+		}
+	})
+}
+
+func TestMiniredisStore(t *testing.T) {
+	redistest.Run(t, func(t *testing.T) *redistest.Backend {
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(mr.Close)
+
+		// miniredis expires keys only on FastForward, so backend clock is wall clock with offset.
+		c := &clock{}
+
+		return &redistest.Backend{
+			Store: func() redis.Store { return newStore(t, mr.Addr()) },
+			Advance: func(d time.Duration) {
+				c.Advance(d)
+				mr.FastForward(d)
+			},
+			Now: func() time.Time {
+				return time.Now().Add(c.Now().Sub(time.Time{}))
+			},
+		}
+	})
+}
+
+func TestRedisStore(t *testing.T) {
+	addr := os.Getenv(envRedisAddr)
+	if addr == "" {
+		t.Skipf("%s is not set", envRedisAddr)
+	}
+
+	redistest.Run(t, func(t *testing.T) *redistest.Backend {
+		conn, err := radix.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		if err = conn.Do(radix.Cmd(nil, "FLUSHDB")); err != nil {
+			t.Fatal(err)
+		}
+
+		return &redistest.Backend{
+			Store:   func() redis.Store { return newStore(t, addr) },
+			Advance: time.Sleep,
+			Now:     time.Now,
+		}
+	})
+}
+
+func newStore(t *testing.T, addr string) redis.Store {
+	t.Helper()
+
+	const poolSize = 8
+
+	pool, err := radix.NewPool("tcp", addr, poolSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = pool.Close() })
+
+	return redis.New(pool, redistest.TTL)
+}
@@ -0,0 +1,171 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+const (
+	keyExpiry   = "expiry"
+	expirySep   = ":"
+	expiryLease = time.Minute
+)
+
+var (
+	errBadKey   = errors.New("bad key")
+	errBadState = errors.New("bad state")
+)
+
+// Tracked is a client state in expiry index.
+type Tracked struct {
+	Org      int64     `json:"org"`
+	Key      string    `json:"key"`
+	Deadline time.Time `json:"deadline"`
+}
+
+var claim = radix.NewEvalScript(1, claimScript)
+
+func expiryKey() string {
+	return keyPrefix + ":" + keyExpiry
+}
+
+func expiryEntry(org int64, key string) string {
+	return strconv.FormatInt(org, 10) + expirySep + key
+}
+
+func parseExpiryEntry(e string) (org int64, key string, err error) {
+	parts := strings.SplitN(e, expirySep, 2)
+	if len(parts) != 2 {
+		return 0, "", errBadKey
+	}
+
+	if org, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return
+	}
+
+	return org, parts[1], nil
+}
+
+// track puts (or moves) client state in expiry index.
+func (r *redis) track(ctx context.Context, org int64, key string, deadline time.Time) error {
+	return r.do(ctx, "ZADD", radix.FlatCmd(nil, "ZADD", expiryKey(), deadline.Unix(), expiryEntry(org, key)))
+}
+
+// ReapExpired claims up to `limit` client states, which deadlines passed at `now`, and drops
+// dead ones, alive states are moved to their new deadlines. Claimed entries are leased, so
+// several replicas can reap concurrently, if replica dies in the middle - its entries will
+// be reaped by others, after lease is over.
+func (r *redis) ReapExpired(ctx context.Context, now time.Time, limit int) (claimed, dropped int, err error) {
+	var entries []string
+
+	if err = r.do(ctx, "claim", claim.Cmd(
+		&entries,
+		expiryKey(),
+		strconv.FormatInt(now.Unix(), 10),
+		strconv.FormatInt(now.Add(expiryLease).Unix(), 10),
+		strconv.Itoa(limit),
+	)); err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		var (
+			org   int64
+			key   string
+			alive bool
+		)
+
+		if org, key, err = parseExpiryEntry(e); err != nil {
+			_ = r.do(ctx, "ZREM", radix.Cmd(nil, "ZREM", expiryKey(), e))
+
+			continue
+		}
+
+		if alive, err = r.IsAlive(ctx, org, key); err != nil {
+			return
+		}
+
+		if alive {
+			if err = r.track(ctx, org, key, now.Add(r.expiration())); err != nil {
+				return
+			}
+
+			continue
+		}
+
+		if err = r.DropState(ctx, org, key); err != nil {
+			return
+		}
+
+		if err = r.do(ctx, "ZREM", radix.Cmd(nil, "ZREM", expiryKey(), e)); err != nil {
+			return
+		}
+
+		dropped++
+	}
+
+	return len(entries), dropped, nil
+}
+
+// ExpiryStats returns number of client states in expiry index, and number of them, which
+// deadlines passed at `now`, but were not reaped yet.
+func (r *redis) ExpiryStats(ctx context.Context, now time.Time) (tracked, overdue int64, err error) {
+	if err = r.do(ctx, "ZCARD", radix.Cmd(&tracked, "ZCARD", expiryKey())); err != nil {
+		return
+	}
+
+	deadline := strconv.FormatInt(now.Unix(), 10)
+	err = r.do(ctx, "ZCOUNT", radix.Cmd(&overdue, "ZCOUNT", expiryKey(), "-inf", deadline))
+
+	return tracked, overdue, err
+}
+
+// ExpiryEntries returns up to `limit` client states of expiry index, ordered by their
+// deadlines, starting from `offset` one.
+func (r *redis) ExpiryEntries(ctx context.Context, offset, limit int) (rv []Tracked, err error) {
+	var pairs []string
+
+	// ZRANGE with negative stop counts from the end.
+	if limit <= 0 {
+		return []Tracked{}, nil
+	}
+
+	if err = r.do(ctx, "ZRANGE", radix.Cmd(
+		&pairs,
+		"ZRANGE",
+		expiryKey(),
+		strconv.Itoa(offset),
+		strconv.Itoa(offset+limit-1),
+		"WITHSCORES",
+	)); err != nil {
+		return
+	}
+
+	rv = make([]Tracked, 0, len(pairs)/2)
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		var (
+			t        Tracked
+			deadline float64
+		)
+
+		if t.Org, t.Key, err = parseExpiryEntry(pairs[i]); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, pairs[i])
+		}
+
+		if deadline, err = strconv.ParseFloat(pairs[i+1], 64); err != nil {
+			return
+		}
+
+		t.Deadline = time.Unix(int64(deadline), 0)
+		rv = append(rv, t)
+	}
+
+	return rv, nil
+}
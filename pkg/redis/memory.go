@@ -0,0 +1,372 @@
+package redis
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+type memory struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	now      func() time.Time
+	counters map[string]int64
+	states   map[string]state
+	alive    map[string]time.Time
+	expiry   map[string]time.Time
+}
+
+// NewMemory creates new in-process store, with same semantics as redis one: alive
+// flags expire after `d`, and expired client states should be reaped.
+func NewMemory(d time.Duration) Store {
+	return NewMemoryWithClock(d, time.Now)
+}
+
+// NewMemoryWithClock creates new in-process store, that uses given clock for alive flags
+// expiration, handy for tests.
+func NewMemoryWithClock(d time.Duration, now func() time.Time) Store {
+	return &memory{
+		ttl:      d,
+		now:      now,
+		counters: make(map[string]int64),
+		states:   make(map[string]state),
+		alive:    make(map[string]time.Time),
+		expiry:   make(map[string]time.Time),
+	}
+}
+
+// SetExpiration changes alive flags ttl, it is applied to new and prolonged flags.
+func (m *memory) SetExpiration(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ttl = d
+}
+
+// ClientsCount returns total number of alive clients in given segment.
+func (m *memory) ClientsCount(_ context.Context, seg toggle.Segment) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counters[clientsKey(seg.Org, segmentKey(seg))], nil
+}
+
+// TogglesCount returns numbers of clients in given segment, that have given toggles enabled.
+func (m *memory) TogglesCount(_ context.Context, seg toggle.Segment, ids []int64) (counts []int64, err error) {
+	if len(ids) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	segment := segmentKey(seg)
+	counts = make([]int64, len(ids))
+
+	for i, id := range ids {
+		counts[i] = m.counters[toggleKey(seg.Org, segment, id)]
+	}
+
+	return counts, nil
+}
+
+// MarkAlive updates key expire time.
+func (m *memory) MarkAlive(_ context.Context, org int64, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	if m.isAlive(org, key, now) {
+		m.alive[aliveKey(org, key)] = now.Add(m.ttl)
+	}
+
+	m.expiry[expiryEntry(org, key)] = now.Add(m.ttl)
+
+	return nil
+}
+
+// IsAlive checks key for existence.
+func (m *memory) IsAlive(_ context.Context, org int64, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.isAlive(org, key, m.now()), nil
+}
+
+// DropState cleans-up state and decrease counters, it is safe to call it concurrently:
+// counters will be decreased only once.
+func (m *memory) DropState(_ context.Context, org int64, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dropState(org, key)
+
+	return nil
+}
+
+// GetState returns toggles ids from state.
+func (m *memory) GetState(_ context.Context, org int64, key string) (ids []int64, found bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.isAlive(org, key, m.now()) {
+		return nil, false, nil
+	}
+
+	s, ok := m.states[stateKey(org, key)]
+	if !ok {
+		return nil, false, nil
+	}
+
+	return append([]int64(nil), s.Toggles...), true, nil
+}
+
+// TogglesAssign atomically increases counters, switching off currently over-used toggles in keys,
+// and saves state (returning it id) for given segment, see assignScript for details.
+func (m *memory) TogglesAssign(_ context.Context, seg toggle.Segment, keys toggle.Keys) (key string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	segment := segmentKey(seg)
+	key = newClientKey(segment)
+
+	ckey := clientsKey(seg.Org, segment)
+	m.counters[ckey]++
+	total := m.counters[ckey]
+
+	ids := []int64{}
+
+	for i := 0; i < len(keys); i++ {
+		k := &keys[i]
+		tkey := toggleKey(seg.Org, segment, k.ID)
+		on := k.Rate >= 1
+
+		if !on && k.Rate > 0 {
+			on = float64(m.counters[tkey]+1)/float64(total) <= k.Rate
+		}
+
+		if on {
+			m.counters[tkey]++
+			ids = append(ids, k.ID)
+		}
+	}
+
+	now := m.now()
+
+	m.states[stateKey(seg.Org, key)] = state{Segment: segment, Toggles: ids}
+	m.alive[aliveKey(seg.Org, key)] = now.Add(m.ttl)
+	m.expiry[expiryEntry(seg.Org, key)] = now.Add(m.ttl)
+
+	keys.EnableByID(ids)
+
+	return key, nil
+}
+
+// ReapExpired claims up to `limit` client states, which deadlines passed at `now`, and drops
+// dead ones, alive states are moved to their new deadlines.
+func (m *memory) ReapExpired(_ context.Context, now time.Time, limit int) (claimed, dropped int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []string
+
+	for e, deadline := range m.expiry {
+		if !deadline.After(now) {
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		di, dj := m.expiry[entries[i]], m.expiry[entries[j]]
+		if di.Equal(dj) {
+			return entries[i] < entries[j]
+		}
+
+		return di.Before(dj)
+	})
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	clock := m.now()
+
+	for _, e := range entries {
+		org, key, _ := parseExpiryEntry(e)
+
+		if m.isAlive(org, key, clock) {
+			m.expiry[e] = now.Add(m.ttl)
+
+			continue
+		}
+
+		m.dropState(org, key)
+		delete(m.expiry, e)
+
+		dropped++
+	}
+
+	return len(entries), dropped, nil
+}
+
+// ExpiryEntries returns up to `limit` client states of expiry index, ordered by their
+// deadlines, starting from `offset` one.
+func (m *memory) ExpiryEntries(_ context.Context, offset, limit int) (rv []Tracked, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := make([]string, 0, len(m.expiry))
+
+	for e := range m.expiry {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		di, dj := m.expiry[entries[i]], m.expiry[entries[j]]
+		if di.Equal(dj) {
+			return entries[i] < entries[j]
+		}
+
+		return di.Before(dj)
+	})
+
+	if offset > len(entries) || limit <= 0 {
+		offset = len(entries)
+	}
+
+	if entries = entries[offset:]; len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	rv = make([]Tracked, len(entries))
+
+	for i, e := range entries {
+		org, key, _ := parseExpiryEntry(e)
+		rv[i] = Tracked{Org: org, Key: key, Deadline: m.expiry[e]}
+	}
+
+	return rv, nil
+}
+
+// ExpiryStats returns number of client states in expiry index, and number of them, which
+// deadlines passed at `now`, but were not reaped yet.
+func (m *memory) ExpiryStats(_ context.Context, now time.Time) (tracked, overdue int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, deadline := range m.expiry {
+		tracked++
+
+		if !deadline.After(now) {
+			overdue++
+		}
+	}
+
+	return tracked, overdue, nil
+}
+
+// Reconcile recomputes all segments and toggles counters from client states, and reports
+// discrepancies, with `fix` set - counters are repaired, and untracked states are tracked.
+func (m *memory) Reconcile(_ context.Context, fix bool) (rep *Report, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rep = &Report{}
+	want := make(map[string]int64)
+	now := m.now()
+
+	for skey, s := range m.states {
+		org, id, _ := parseStateKey(skey)
+
+		rep.States++
+		want[clientsKey(org, s.Segment)]++
+
+		for _, t := range s.Toggles {
+			want[toggleKey(org, s.Segment, t)]++
+		}
+
+		e := expiryEntry(org, id)
+
+		if _, ok := m.expiry[e]; ok {
+			continue
+		}
+
+		rep.Untracked++
+
+		if fix {
+			m.expiry[e] = now
+		}
+	}
+
+	for key, w := range want {
+		if h := m.counters[key]; h != w {
+			rep.Counters = append(rep.Counters, Discrepancy{Key: key, Have: h, Want: w})
+		}
+	}
+
+	for key, h := range m.counters {
+		if _, ok := want[key]; !ok && h != 0 {
+			rep.Counters = append(rep.Counters, Discrepancy{Key: key, Have: h})
+		}
+	}
+
+	sort.Slice(rep.Counters, func(i, j int) bool {
+		return rep.Counters[i].Key < rep.Counters[j].Key
+	})
+
+	if !fix {
+		return rep, nil
+	}
+
+	for i := 0; i < len(rep.Counters); i++ {
+		d := &rep.Counters[i]
+
+		if d.Want == 0 {
+			delete(m.counters, d.Key)
+		} else {
+			m.counters[d.Key] = d.Want
+		}
+
+		d.Repaired = true
+	}
+
+	return rep, nil
+}
+
+func (m *memory) isAlive(org int64, key string, now time.Time) bool {
+	akey := aliveKey(org, key)
+
+	deadline, ok := m.alive[akey]
+	if !ok {
+		return false
+	}
+
+	if !deadline.After(now) {
+		delete(m.alive, akey)
+
+		return false
+	}
+
+	return true
+}
+
+func (m *memory) dropState(org int64, key string) {
+	skey := stateKey(org, key)
+
+	s, ok := m.states[skey]
+	if !ok {
+		return
+	}
+
+	m.counters[clientsKey(org, s.Segment)]--
+
+	for _, id := range s.Toggles {
+		m.counters[toggleKey(org, s.Segment, id)]--
+	}
+
+	delete(m.states, skey)
+}
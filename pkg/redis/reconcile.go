@@ -0,0 +1,229 @@
+package redis
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+const scanCount = 1000
+
+var repair = radix.NewEvalScript(2, repairScript)
+
+type (
+	// Discrepancy is a counter, which value differs from one, computed from client states.
+	Discrepancy struct {
+		Key      string `json:"key"`
+		Have     int64  `json:"have"`
+		Want     int64  `json:"want"`
+		Repaired bool   `json:"repaired"`
+	}
+
+	// Report holds reconciliation results.
+	Report struct {
+		States    int           `json:"states"`
+		Untracked int           `json:"untracked"`
+		Counters  []Discrepancy `json:"counters"`
+	}
+)
+
+// Reconcile recomputes all segments and toggles counters from client states, and reports
+// discrepancies, with `fix` set - counters are repaired, and states missing in expiry index
+// are put there. Counter is repaired only if its segment had no assigns and drops since
+// reconcile started, counters of segments, changed in between, are reported, but left as is.
+func (r *redis) Reconcile(ctx context.Context, fix bool) (rep *Report, err error) {
+	var versions map[string]int64
+
+	if rep, versions, err = r.discrepancies(ctx, fix); err != nil || !fix {
+		return
+	}
+
+	if err = r.repairCounters(ctx, rep, versions); err != nil {
+		return
+	}
+
+	return rep, nil
+}
+
+// discrepancies reads segments versions, then counters and client states, and reports counters, that
+// differ from ones, computed from states, versions are returned for repairCounters.
+func (r *redis) discrepancies(ctx context.Context, fix bool) (rep *Report, versions map[string]int64, err error) {
+	var want map[string]int64
+
+	rep = &Report{}
+	versions = make(map[string]int64)
+	have := make(map[string]int64)
+
+	for _, scan := range []struct {
+		pattern string
+		dst     map[string]int64
+	}{
+		{strings.Join([]string{keyPrefix, "*", keyVersion}, ":"), versions},
+		{strings.Join([]string{keyPrefix, "*", keyClients, keyCount}, ":"), have},
+		{strings.Join([]string{keyPrefix, "*", keyToggles, "*", keyCount}, ":"), have},
+	} {
+		dst := scan.dst
+
+		if err = r.scan(scan.pattern, func(key string) (err error) {
+			var val int64
+
+			if err = r.do(ctx, "GET", radix.Cmd(&val, "GET", key)); err != nil {
+				return
+			}
+
+			dst[key] = val
+
+			return nil
+		}); err != nil {
+			return
+		}
+	}
+
+	if want, err = r.countStates(ctx, rep, fix); err != nil {
+		return
+	}
+
+	for key, w := range want {
+		if _, ok := have[key]; !ok {
+			have[key] = 0
+		}
+
+		if h := have[key]; h != w {
+			rep.Counters = append(rep.Counters, Discrepancy{Key: key, Have: h, Want: w})
+		}
+	}
+
+	for key, h := range have {
+		if _, ok := want[key]; !ok && h != 0 {
+			rep.Counters = append(rep.Counters, Discrepancy{Key: key, Have: h})
+		}
+	}
+
+	sort.Slice(rep.Counters, func(i, j int) bool {
+		return rep.Counters[i].Key < rep.Counters[j].Key
+	})
+
+	return rep, versions, nil
+}
+
+// repairCounters sets reported counters to their computed values, if their segments versions
+// still match ones, observed before counting.
+func (r *redis) repairCounters(ctx context.Context, rep *Report, versions map[string]int64) (err error) {
+	for i := 0; i < len(rep.Counters); i++ {
+		d := &rep.Counters[i]
+		vkey := counterVersionKey(d.Key)
+
+		var ok int
+
+		if err = r.do(ctx, "repair", repair.Cmd(
+			&ok, vkey, d.Key, strconv.FormatInt(versions[vkey], 10), strconv.FormatInt(d.Want, 10),
+		)); err != nil {
+			return
+		}
+
+		d.Repaired = ok == 1
+	}
+
+	return nil
+}
+
+// countStates scans all client states and counts expected counters values.
+func (r *redis) countStates(ctx context.Context, rep *Report, fix bool) (want map[string]int64, err error) {
+	want = make(map[string]int64)
+	pattern := strings.Join([]string{keyPrefix, "*", keyClients, "*", keyState}, ":")
+
+	err = r.scan(pattern, func(key string) (err error) {
+		var (
+			org   int64
+			id    string
+			raw   string
+			s     state
+			score string
+		)
+
+		if org, id, err = parseStateKey(key); err != nil {
+			return nil
+		}
+
+		if err = r.do(ctx, "GET", radix.Cmd(&raw, "GET", key)); err != nil || raw == "" {
+			return
+		}
+
+		if s, err = decodeState(raw); err != nil {
+			return nil
+		}
+
+		rep.States++
+		want[clientsKey(org, s.Segment)]++
+
+		for _, t := range s.Toggles {
+			want[toggleKey(org, s.Segment, t)]++
+		}
+
+		if err = r.do(ctx, "ZSCORE", radix.Cmd(&score, "ZSCORE", expiryKey(), expiryEntry(org, id))); err != nil {
+			return
+		}
+
+		if score != "" {
+			return nil
+		}
+
+		rep.Untracked++
+
+		if fix {
+			err = r.track(ctx, org, id, time.Now())
+		}
+
+		return err
+	})
+
+	return want, err
+}
+
+// scan iterates over keys, matching pattern, on every primary node.
+func (r *redis) scan(pattern string, fn func(string) error) (err error) {
+	var (
+		key  string
+		opts = radix.ScanOpts{Command: "SCAN", Pattern: pattern, Count: scanCount}
+		s    radix.Scanner
+	)
+
+	if c, ok := r.c.(*radix.Cluster); ok {
+		s = c.NewScanner(opts)
+	} else {
+		s = radix.NewScanner(r.c, opts)
+	}
+
+	for s.Next(&key) {
+		if err = fn(key); err != nil {
+			_ = s.Close()
+
+			return
+		}
+	}
+
+	return s.Close()
+}
+
+// parseStateKey extracts organization id and client key from state key.
+func parseStateKey(key string) (org int64, id string, err error) {
+	parts := strings.Split(key, ":")
+	if len(parts) != 6 {
+		return 0, "", errBadKey
+	}
+
+	if org, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return
+	}
+
+	return org, strings.Trim(parts[2], "{}") + clientSep + parts[4], nil
+}
+
+// counterVersionKey returns version key of counter's segment.
+func counterVersionKey(counter string) string {
+	return counter[:strings.Index(counter, "}")+1] + ":" + keyVersion
+}
@@ -0,0 +1,438 @@
+// Package redistest holds conformance test suite for redis.Store implementations.
+package redistest
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/redis"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+// TTL is an alive flags ttl, that stores under test must use.
+const TTL = 2 * time.Second
+
+type (
+	// Backend is a storage under test.
+	Backend struct {
+		// Store returns store over backend, stores returned by different calls must
+		// share same data, as service replicas do.
+		Store func() redis.Store
+		// Advance moves backend clock forward.
+		Advance func(time.Duration)
+		// Now returns current backend time.
+		Now func() time.Time
+	}
+
+	// Factory returns new empty backend, it is called once for every test.
+	Factory func(t *testing.T) *Backend
+
+	suite struct {
+		ctx context.Context
+		t   *testing.T
+		b   *Backend
+		s   redis.Store
+	}
+)
+
+// Run runs conformance suite against backends, created by given factory.
+func Run(t *testing.T, newBackend Factory) {
+	t.Helper()
+
+	for _, tc := range []struct {
+		name string
+		fn   func(*suite)
+	}{
+		{"StateLifecycle", testStateLifecycle},
+		{"AliveTTL", testAliveTTL},
+		{"SetExpiration", testSetExpiration},
+		{"Counters", testCounters},
+		{"Reap", testReap},
+		{"ReapReplicas", testReapReplicas},
+		{"ExpiryEntries", testExpiryEntries},
+		{"ConcurrentAssign", testConcurrentAssign},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			b := newBackend(t)
+			tc.fn(&suite{ctx: context.Background(), t: t, b: b, s: b.Store()})
+		})
+	}
+}
+
+func (s *suite) must(err error) {
+	s.t.Helper()
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *suite) expect(what string, got, want interface{}) {
+	s.t.Helper()
+
+	if !reflect.DeepEqual(got, want) {
+		s.t.Fatalf("%s: got %v (want: %v)", what, got, want)
+	}
+}
+
+func (s *suite) assign(seg toggle.Segment, keys toggle.Keys) string {
+	s.t.Helper()
+
+	key, err := s.s.TogglesAssign(s.ctx, seg, keys)
+	s.must(err)
+
+	return key
+}
+
+func (s *suite) clients(seg toggle.Segment) int64 {
+	s.t.Helper()
+
+	n, err := s.s.ClientsCount(s.ctx, seg)
+	s.must(err)
+
+	return n
+}
+
+func (s *suite) toggles(seg toggle.Segment, ids ...int64) []int64 {
+	s.t.Helper()
+
+	counts, err := s.s.TogglesCount(s.ctx, seg, ids)
+	s.must(err)
+
+	return counts
+}
+
+// tracked returns numbers of tracked and overdue states.
+func (s *suite) tracked() [2]int64 {
+	s.t.Helper()
+
+	tracked, overdue, err := s.s.ExpiryStats(s.ctx, s.b.Now())
+	s.must(err)
+
+	return [2]int64{tracked, overdue}
+}
+
+func (s *suite) alive(org int64, key string) bool {
+	s.t.Helper()
+
+	yes, err := s.s.IsAlive(s.ctx, org, key)
+	s.must(err)
+
+	return yes
+}
+
+// consistent checks, that counters match client states.
+func (s *suite) consistent(states int) {
+	s.t.Helper()
+
+	rep, err := s.s.Reconcile(s.ctx, false)
+	s.must(err)
+
+	if rep.States != states || rep.Untracked != 0 || len(rep.Counters) != 0 {
+		s.t.Fatalf("reconcile report: %+v (want %d states and no discrepancies)", rep, states)
+	}
+}
+
+// reap reaps all expired states with given store, returns number of dropped ones.
+func (s *suite) reap(st redis.Store) (total int, err error) {
+	const batch = 8
+
+	for {
+		claimed, dropped, err := st.ReapExpired(s.ctx, s.b.Now(), batch)
+		if err != nil {
+			return total, err
+		}
+
+		total += dropped
+
+		if claimed == 0 {
+			return total, nil
+		}
+	}
+}
+
+func testStateLifecycle(s *suite) {
+	seg := toggle.Segment{Org: 1, App: "ios", Env: "dev", Version: "2.0", Platform: "iphone"}
+	keys := toggle.Keys{{ID: 10, Name: "a", Rate: 1}, {ID: 11, Name: "b", Rate: 0}, {ID: 12, Name: "c", Rate: 1}}
+
+	key := s.assign(seg, keys)
+
+	s.expect("enabled keys", keys.Names(), []string{"a", "c"})
+	s.expect("alive", s.alive(seg.Org, key), true)
+
+	ids, found, err := s.s.GetState(s.ctx, seg.Org, key)
+	s.must(err)
+	s.expect("state found", found, true)
+	s.expect("state ids", ids, []int64{10, 12})
+
+	if _, found, _ = s.s.GetState(s.ctx, seg.Org+1, key); found {
+		s.t.Fatal("state found in other org")
+	}
+
+	if _, found, _ = s.s.GetState(s.ctx, seg.Org, "unknown"); found {
+		s.t.Fatal("unknown state found")
+	}
+
+	s.expect("clients", s.clients(seg), int64(1))
+	s.consistent(1)
+
+	s.must(s.s.DropState(s.ctx, seg.Org, key))
+	s.expect("clients after drop", s.clients(seg), int64(0))
+
+	if _, found, _ = s.s.GetState(s.ctx, seg.Org, key); found {
+		s.t.Fatal("dropped state found")
+	}
+
+	// second drop must not decrease counters.
+	s.must(s.s.DropState(s.ctx, seg.Org, key))
+	s.expect("clients after second drop", s.clients(seg), int64(0))
+	s.consistent(0)
+}
+
+func testAliveTTL(s *suite) {
+	seg := toggle.Segment{Org: 1, App: "web", Env: "dev", Version: "1.0", Platform: "ie6"}
+
+	gone := s.assign(seg, toggle.Keys{{ID: 1, Rate: 1}})
+	kept := s.assign(seg, toggle.Keys{{ID: 1, Rate: 1}})
+
+	s.b.Advance(TTL * 6 / 10)
+	s.must(s.s.MarkAlive(s.ctx, seg.Org, kept))
+	s.b.Advance(TTL * 6 / 10)
+
+	s.expect("expired alive", s.alive(seg.Org, gone), false)
+	s.expect("prolonged alive", s.alive(seg.Org, kept), true)
+
+	if _, found, _ := s.s.GetState(s.ctx, seg.Org, gone); found {
+		s.t.Fatal("state of expired client found")
+	}
+
+	if _, found, _ := s.s.GetState(s.ctx, seg.Org, kept); !found {
+		s.t.Fatal("state of alive client not found")
+	}
+
+	// expired clients can not be resurrected.
+	s.must(s.s.MarkAlive(s.ctx, seg.Org, gone))
+	s.expect("marked expired alive", s.alive(seg.Org, gone), false)
+
+	// counters are not changed until state is dropped.
+	s.expect("clients", s.clients(seg), int64(2))
+}
+
+func testSetExpiration(s *suite) {
+	seg := toggle.Segment{Org: 1, App: "web", Env: "dev", Version: "1.0", Platform: "ie6"}
+
+	s.s.SetExpiration(TTL * 2)
+
+	key := s.assign(seg, toggle.Keys{{ID: 1, Rate: 1}})
+
+	s.b.Advance(TTL * 12 / 10)
+	s.expect("alive with longer ttl", s.alive(seg.Org, key), true)
+
+	s.s.SetExpiration(TTL / 2)
+	s.must(s.s.MarkAlive(s.ctx, seg.Org, key))
+
+	s.b.Advance(TTL * 6 / 10)
+	s.expect("alive with shorter ttl", s.alive(seg.Org, key), false)
+}
+
+func testCounters(s *suite) {
+	const clients = 10
+
+	seg := toggle.Segment{Org: 1, App: "android", Env: "production", Version: "3.0", Platform: "tv"}
+	other := seg
+	other.Org = 2
+
+	var (
+		keys    []string
+		enabled = make(map[string]int)
+	)
+
+	for i := 0; i < clients; i++ {
+		tk := toggle.Keys{{ID: 1, Name: "half", Rate: 0.5}, {ID: 2, Name: "off", Rate: 0}, {ID: 3, Name: "on", Rate: 1}}
+		keys = append(keys, s.assign(seg, tk))
+
+		for _, n := range tk.Names() {
+			enabled[n]++
+		}
+	}
+
+	s.assign(other, toggle.Keys{{ID: 1, Name: "half", Rate: 0.5}})
+
+	s.expect("enabled", enabled, map[string]int{"half": clients / 2, "on": clients})
+	s.expect("toggles", s.toggles(seg, 1, 2, 3, 4), []int64{clients / 2, 0, clients, 0})
+	s.expect("clients", s.clients(seg), int64(clients))
+	s.expect("other org clients", s.clients(other), int64(1))
+	s.consistent(clients + 1)
+
+	for _, k := range keys[:3] {
+		s.must(s.s.DropState(s.ctx, seg.Org, k))
+	}
+
+	// only second client had "half" enabled.
+	s.expect("clients after drop", s.clients(seg), int64(clients-3))
+	s.expect("toggles after drop", s.toggles(seg, 1, 2, 3), []int64{clients/2 - 1, 0, clients - 3})
+	s.consistent(clients - 3 + 1)
+}
+
+func testReap(s *suite) {
+	const clients = 20
+
+	seg := toggle.Segment{Org: 3, App: "web", Env: "staging", Version: "1.0", Platform: "chrome"}
+
+	var kept string
+
+	for i := 0; i < clients; i++ {
+		kept = s.assign(seg, toggle.Keys{{ID: 1, Rate: 1}, {ID: 2, Rate: 0.5}})
+	}
+
+	dropped, err := s.reap(s.s)
+	s.must(err)
+	s.expect("dropped before expiration", dropped, 0)
+	s.expect("tracked before expiration", s.tracked(), [2]int64{clients, 0})
+
+	s.b.Advance(TTL * 6 / 10)
+	s.must(s.s.MarkAlive(s.ctx, seg.Org, kept))
+	s.b.Advance(TTL * 6 / 10)
+
+	dropped, err = s.reap(s.s)
+	s.must(err)
+	s.expect("dropped", dropped, clients-1)
+	s.expect("clients", s.clients(seg), int64(1))
+	s.expect("tracked", s.tracked()[0], int64(1))
+
+	if _, found, _ := s.s.GetState(s.ctx, seg.Org, kept); !found {
+		s.t.Fatal("state of alive client was reaped")
+	}
+
+	s.consistent(1)
+}
+
+func testExpiryEntries(s *suite) {
+	seg := toggle.Segment{Org: 4, App: "web", Env: "dev", Version: "1.0", Platform: "chrome"}
+
+	want := map[string]bool{
+		s.assign(seg, toggle.Keys{{ID: 1, Rate: 1}}): true,
+		s.assign(seg, toggle.Keys{{ID: 1, Rate: 1}}): true,
+	}
+
+	got := make(map[string]bool)
+
+	for offset := 0; offset < 3; offset++ {
+		page, err := s.s.ExpiryEntries(s.ctx, offset, 1)
+		s.must(err)
+
+		if offset == 2 {
+			s.expect("past last page", len(page), 0)
+
+			break
+		}
+
+		if len(page) != 1 || page[0].Org != seg.Org || page[0].Deadline.IsZero() {
+			s.t.Fatalf("page %d: %+v", offset, page)
+		}
+
+		got[page[0].Key] = true
+	}
+
+	s.expect("tracked", got, want)
+}
+
+func testReapReplicas(s *suite) {
+	const clients = 50
+
+	seg := toggle.Segment{Org: 3, App: "web", Env: "staging", Version: "1.0", Platform: "chrome"}
+
+	for i := 0; i < clients; i++ {
+		s.assign(seg, toggle.Keys{{ID: 1, Rate: 1}})
+	}
+
+	s.b.Advance(TTL * 12 / 10)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		dropped int
+	)
+
+	for _, st := range []redis.Store{s.s, s.b.Store(), s.b.Store()} {
+		wg.Add(1)
+
+		go func(st redis.Store) {
+			defer wg.Done()
+
+			n, err := s.reap(st)
+			if err != nil {
+				s.t.Error(err)
+			}
+
+			mu.Lock()
+			dropped += n
+			mu.Unlock()
+		}(st)
+	}
+
+	wg.Wait()
+
+	s.expect("dropped", dropped, clients)
+	s.expect("clients", s.clients(seg), int64(0))
+	s.consistent(0)
+}
+
+func testConcurrentAssign(s *suite) {
+	const (
+		workers = 16
+		clients = 400
+		rate    = 0.1
+	)
+
+	seg := toggle.Segment{Org: 1, App: "web", Env: "production", Version: "1.0", Platform: "ie6"}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		enabled int
+		jobs    = make(chan struct{})
+	)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func(st redis.Store) {
+			defer wg.Done()
+
+			for range jobs {
+				keys := toggle.Keys{{ID: 1, Name: "partial", Rate: rate}, {ID: 2, Name: "full", Rate: 1}}
+
+				if _, err := st.TogglesAssign(s.ctx, seg, keys); err != nil {
+					s.t.Error(err)
+
+					return
+				}
+
+				mu.Lock()
+				enabled += len(keys.Names()) - 1
+				mu.Unlock()
+			}
+		}(s.b.Store())
+	}
+
+	for i := 0; i < clients; i++ {
+		jobs <- struct{}{}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	s.expect("clients", s.clients(seg), int64(clients))
+
+	if limit := int(rate * clients); enabled > limit || enabled < limit-1 {
+		s.t.Fatalf("enabled = %d (want: %d)", enabled, limit)
+	}
+
+	s.consistent(clients)
+}
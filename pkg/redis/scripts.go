@@ -0,0 +1,105 @@
+package redis
+
+// assignScript atomically assigns toggles for new client in segment:
+// increments segment clients counter, checks every toggle rate against its counter,
+// increments counters of enabled toggles, saves client state and alive flag and bumps
+// segment version.
+//
+// KEYS[1] - segment clients counter, KEYS[2] - state key, KEYS[3] - alive key,
+// KEYS[4] - segment version, KEYS[5..] - toggles counters.
+//
+// ARGV[1] - alive ttl (seconds), ARGV[2] - segment key,
+// ARGV[3..] - toggles ids and rates pairs, in KEYS order.
+//
+// Returns ids of enabled toggles.
+const assignScript = `
+local total = redis.call('INCR', KEYS[1])
+local ids = {}
+
+for i = 5, #KEYS do
+	local n = (i - 5) * 2 + 3
+	local rate = tonumber(ARGV[n + 1])
+	local on = rate >= 1
+
+	if not on and rate > 0 then
+		local curr = tonumber(redis.call('GET', KEYS[i]) or '0')
+		on = (curr + 1) / total <= rate
+	end
+
+	if on then
+		redis.call('INCR', KEYS[i])
+		ids[#ids + 1] = ARGV[n]
+	end
+end
+
+redis.call('SET', KEYS[2], ARGV[2] .. '|' .. table.concat(ids, ','))
+redis.call('SETEX', KEYS[3], ARGV[1], '1')
+redis.call('INCR', KEYS[4])
+
+return ids
+`
+
+// dropScript atomically drops client state and decrements its counters, only if state
+// is still the same, as was read by caller - so counters never decremented twice,
+// segment version is bumped.
+//
+// KEYS[1] - state key, KEYS[2] - segment version, KEYS[3] - segment clients counter,
+// KEYS[4..] - toggles counters.
+//
+// ARGV[1] - state value.
+//
+// Returns 1 if state was dropped.
+const dropScript = `
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+
+for i = 3, #KEYS do
+	redis.call('DECR', KEYS[i])
+end
+
+redis.call('DEL', KEYS[1])
+redis.call('INCR', KEYS[2])
+
+return 1
+`
+
+// claimScript leases expired entries of expiry index: their deadlines are moved forward,
+// so other replicas will not see them, until lease is over.
+//
+// KEYS[1] - expiry index.
+//
+// ARGV[1] - now (unix seconds), ARGV[2] - lease deadline (unix seconds), ARGV[3] - limit.
+//
+// Returns claimed entries.
+const claimScript = `
+local claimed = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+
+for i = 1, #claimed do
+	redis.call('ZADD', KEYS[1], ARGV[2], claimed[i])
+end
+
+return claimed
+`
+
+// repairScript sets counter to new value, only if its segment was not changed since its version
+// was observed by caller, before counting states and reading counters.
+//
+// KEYS[1] - segment version, KEYS[2] - counter.
+//
+// ARGV[1] - observed version, ARGV[2] - new value (zero value removes counter).
+//
+// Returns 1 if counter was set.
+const repairScript = `
+if tonumber(redis.call('GET', KEYS[1]) or '0') ~= tonumber(ARGV[1]) then
+	return 0
+end
+
+if tonumber(ARGV[2]) == 0 then
+	redis.call('DEL', KEYS[2])
+else
+	redis.call('SET', KEYS[2], ARGV[2])
+end
+
+return 1
+`
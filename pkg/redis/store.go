@@ -1,79 +1,146 @@
 package redis
 
 import (
-	"errors"
+	"context"
 	"strconv"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/mediocregopher/radix/v3"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 
 	"github.com/s0rg/toggle-svc/pkg/toggle"
+	"github.com/s0rg/toggle-svc/pkg/tracing"
 )
 
-var errReplyNotFull = errors.New("reply not full")
-
 type state struct {
 	Segment string  `json:"key"`
 	Toggles []int64 `json:"ids"`
 }
 
 type Store interface {
-	ClientsInc(app, version, platform string) (int64, error)
-	MarkAlive(string) error
-	DropState(string) error
-	GetState(string) ([]int64, bool, error)
-	IsAlive(string) (bool, error)
-	TogglesGet(app, version, platform string, keys toggle.Keys) ([]int64, error)
-	TogglesIncr(app, version, platform string, keys toggle.Keys) (string, error)
+	ClientsCount(ctx context.Context, seg toggle.Segment) (int64, error)
+	MarkAlive(ctx context.Context, org int64, key string) error
+	DropState(ctx context.Context, org int64, key string) error
+	GetState(ctx context.Context, org int64, key string) ([]int64, bool, error)
+	IsAlive(ctx context.Context, org int64, key string) (bool, error)
+	TogglesAssign(ctx context.Context, seg toggle.Segment, keys toggle.Keys) (string, error)
+	TogglesCount(ctx context.Context, seg toggle.Segment, ids []int64) ([]int64, error)
+	ReapExpired(ctx context.Context, now time.Time, limit int) (claimed, dropped int, err error)
+	ExpiryStats(ctx context.Context, now time.Time) (tracked, overdue int64, err error)
+	ExpiryEntries(ctx context.Context, offset, limit int) ([]Tracked, error)
+	Reconcile(ctx context.Context, fix bool) (*Report, error)
+	SetExpiration(d time.Duration)
 }
 
 type redis struct {
 	c   radix.Client
-	exp string
+	ttl int64
 }
 
 // New create new redis store.
 func New(c radix.Client, d time.Duration) Store {
 	return &redis{
 		c:   c,
-		exp: strconv.Itoa(int(d.Seconds())),
+		ttl: int64(d),
 	}
 }
 
-// ClientsInc increases total number of clients in given segment.
-func (r *redis) ClientsInc(app, version, platform string) (count int64, err error) {
-	key := clientsKey(segmentKey(app, version, platform))
-	err = r.c.Do(radix.Cmd(&count, "INCR", key))
+// SetExpiration changes alive flags ttl, it is applied to new and prolonged flags.
+func (r *redis) SetExpiration(d time.Duration) {
+	atomic.StoreInt64(&r.ttl, int64(d))
+}
+
+func (r *redis) expiration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.ttl))
+}
+
+// expire returns alive flags ttl in seconds, as EXPIRE argument.
+func (r *redis) expire() string {
+	return strconv.Itoa(int(r.expiration().Seconds()))
+}
+
+// do runs redis command (or script) `op` in its own span.
+func (r *redis) do(ctx context.Context, op string, a radix.Action) (err error) {
+	_, end := tracing.Start(ctx, "redis."+op, semconv.DBSystemRedis, semconv.DBOperationKey.String(op))
+	defer end(&err)
+
+	return r.c.Do(a)
+}
+
+// ClientsCount returns total number of alive clients in given segment.
+func (r *redis) ClientsCount(ctx context.Context, seg toggle.Segment) (count int64, err error) {
+	key := clientsKey(seg.Org, segmentKey(seg))
+	err = r.do(ctx, "GET", radix.Cmd(&count, "GET", key))
 
 	return
 }
 
+// TogglesCount returns numbers of clients in given segment, that have given toggles enabled.
+func (r *redis) TogglesCount(ctx context.Context, seg toggle.Segment, ids []int64) (counts []int64, err error) {
+	if len(ids) == 0 {
+		return
+	}
+
+	var (
+		segment = segmentKey(seg)
+		keys    = make([]string, len(ids))
+		raw     []string
+	)
+
+	for i, id := range ids {
+		keys[i] = toggleKey(seg.Org, segment, id)
+	}
+
+	if err = r.do(ctx, "MGET", radix.Cmd(&raw, "MGET", keys...)); err != nil {
+		return
+	}
+
+	counts = make([]int64, len(ids))
+
+	for i, v := range raw {
+		if v == "" {
+			continue
+		}
+
+		if counts[i], err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	return counts, nil
+}
+
 // MarkAlive updates key expire time.
-func (r *redis) MarkAlive(key string) (err error) {
-	return r.c.Do(radix.Cmd(nil, "EXPIRE", aliveKey(key), r.exp))
+func (r *redis) MarkAlive(ctx context.Context, org int64, key string) (err error) {
+	if err = r.do(ctx, "EXPIRE", radix.Cmd(nil, "EXPIRE", aliveKey(org, key), r.expire())); err != nil {
+		return
+	}
+
+	return r.track(ctx, org, key, time.Now().Add(r.expiration()))
 }
 
 // IsAlive checks key for existence.
-func (r *redis) IsAlive(key string) (yes bool, err error) {
+func (r *redis) IsAlive(ctx context.Context, org int64, key string) (yes bool, err error) {
 	var rc int
 
-	if err = r.c.Do(radix.Cmd(&rc, "EXISTS", aliveKey(key))); err != nil {
+	if err = r.do(ctx, "EXISTS", radix.Cmd(&rc, "EXISTS", aliveKey(org, key))); err != nil {
 		return
 	}
 
 	return rc == 1, nil
 }
 
-// DropState cleans-up state and decrease counters.
-func (r *redis) DropState(key string) (err error) {
+// DropState cleans-up state and decrease counters, it is safe to call it concurrently:
+// counters will be decreased only once.
+func (r *redis) DropState(ctx context.Context, org int64, key string) (err error) {
 	var (
-		skey = stateKey(key)
+		skey = stateKey(org, key)
 		raw  string
 		s    state
 	)
 
-	if err = r.c.Do(radix.Cmd(&raw, "GET", skey)); err != nil || raw == "" {
+	if err = r.do(ctx, "GET", radix.Cmd(&raw, "GET", skey)); err != nil || raw == "" {
 		return
 	}
 
@@ -81,25 +148,30 @@ func (r *redis) DropState(key string) (err error) {
 		return
 	}
 
-	if err = r.togglesDecr(s.Segment, s.Toggles); err != nil {
-		return
+	args := make([]string, 0, 4+len(s.Toggles))
+	args = append(args, skey, versionKey(org, s.Segment), clientsKey(org, s.Segment))
+
+	for _, id := range s.Toggles {
+		args = append(args, toggleKey(org, s.Segment, id))
 	}
 
-	return r.c.Do(radix.Cmd(nil, "DEL", skey))
+	args = append(args, raw)
+
+	return r.do(ctx, "drop", radix.NewEvalScript(3+len(s.Toggles), dropScript).Cmd(nil, args...))
 }
 
 // GetState returns toggles ids from state.
-func (r *redis) GetState(key string) (ids []int64, found bool, err error) {
+func (r *redis) GetState(ctx context.Context, org int64, key string) (ids []int64, found bool, err error) {
 	var (
 		raw string
 		s   state
 	)
 
-	if found, err = r.IsAlive(key); err != nil || !found {
+	if found, err = r.IsAlive(ctx, org, key); err != nil || !found {
 		return
 	}
 
-	if err = r.c.Do(radix.Cmd(&raw, "GET", stateKey(key))); err != nil || raw == "" {
+	if err = r.do(ctx, "GET", radix.Cmd(&raw, "GET", stateKey(org, key))); err != nil || raw == "" {
 		found = false
 
 		return
@@ -112,134 +184,41 @@ func (r *redis) GetState(key string) (ids []int64, found bool, err error) {
 	return s.Toggles, true, nil
 }
 
-// TogglesIncr increase counters and save state (returning it id) for given segment and keys.
-func (r *redis) TogglesIncr(app, version, platform string, keys toggle.Keys) (key string, err error) {
-	key = uuid.New().String()
+// TogglesAssign atomically increases counters, switching off currently over-used toggles in keys,
+// and saves state (returning it id) for given segment. State is put in expiry index by separate call,
+// as index lives in other cluster slot, states, left untracked by crash in between, are tracked back
+// by Reconcile (with `fix` set) and reaped then.
+func (r *redis) TogglesAssign(ctx context.Context, seg toggle.Segment, keys toggle.Keys) (key string, err error) {
+	segment := segmentKey(seg)
+	key = newClientKey(segment)
+
+	args := make([]string, 0, 6+len(keys)*3)
+	args = append(args,
+		clientsKey(seg.Org, segment), stateKey(seg.Org, key), aliveKey(seg.Org, key), versionKey(seg.Org, segment),
+	)
 
-	s := state{
-		Segment: segmentKey(app, version, platform),
+	for i := 0; i < len(keys); i++ {
+		args = append(args, toggleKey(seg.Org, segment, keys[i].ID))
 	}
 
-	err = r.c.Do(radix.WithConn(s.Segment, func(rc radix.Conn) (err error) {
-		if err = rc.Do(radix.Cmd(nil, "MULTI")); err != nil {
-			return
-		}
-
-		defer func() {
-			if err != nil {
-				_ = rc.Do(radix.Cmd(nil, "DISCARD"))
-			}
-		}()
+	args = append(args, r.expire(), segment)
 
-		for i := 0; i < len(keys); i++ {
-			if keys[i].Rate == 0 {
-				continue
-			}
-
-			keyID := keys[i].ID
-			if err = rc.Do(radix.Cmd(nil, "INCR", toggleKey(s.Segment, keyID))); err != nil {
-				return
-			}
-
-			s.Toggles = append(s.Toggles, keyID)
-		}
-
-		var state string
-
-		if state, err = encodeState(s); err != nil {
-			return
-		}
-
-		if err = rc.Do(radix.Cmd(nil, "SET", stateKey(key), state)); err != nil {
-			return
-		}
-
-		if err = rc.Do(radix.Cmd(nil, "SETEX", aliveKey(key), r.exp, "1")); err != nil {
-			return
-		}
-
-		return rc.Do(radix.Cmd(nil, "EXEC"))
-	}))
-
-	return key, err
-}
-
-func (r *redis) togglesDecr(segment string, keysID []int64) (err error) {
-	err = r.c.Do(radix.WithConn(segment, func(rc radix.Conn) (err error) {
-		if err = rc.Do(radix.Cmd(nil, "MULTI")); err != nil {
-			return
-		}
-
-		defer func() {
-			if err != nil {
-				_ = rc.Do(radix.Cmd(nil, "DISCARD"))
-			}
-		}()
-
-		for i := 0; i < len(keysID); i++ {
-			if err = rc.Do(radix.Cmd(nil, "DECR", toggleKey(segment, keysID[i]))); err != nil {
-				return
-			}
-		}
-
-		if err = rc.Do(radix.Cmd(nil, "DECR", clientsKey(segment))); err != nil {
-			return
-		}
-
-		return rc.Do(radix.Cmd(nil, "EXEC"))
-	}))
-
-	return err
-}
-
-// TogglesGet returns slice of toggles counters.
-func (r *redis) TogglesGet(app, version, platform string, keys toggle.Keys) (rv []int64, err error) {
-	var result []string
-
-	segment := segmentKey(app, version, platform)
-
-	err = r.c.Do(radix.WithConn(segment, func(rc radix.Conn) (err error) {
-		if err = rc.Do(radix.Cmd(nil, "MULTI")); err != nil {
-			return
-		}
-
-		defer func() {
-			if err != nil {
-				_ = rc.Do(radix.Cmd(nil, "DISCARD"))
-			}
-		}()
-
-		for i := 0; i < len(keys); i++ {
-			key := toggleKey(segment, keys[i].ID)
-
-			if err = rc.Do(radix.Cmd(nil, "GET", key)); err != nil {
-				return err
-			}
-		}
-
-		return rc.Do(radix.Cmd(&result, "EXEC"))
-	}))
-	if err != nil {
-		return
+	for i := 0; i < len(keys); i++ {
+		k := &keys[i]
+		args = append(args, strconv.FormatInt(k.ID, 10), strconv.FormatFloat(k.Rate, 'f', -1, 64))
 	}
 
-	if len(result) != len(keys) {
-		err = errReplyNotFull
+	var ids []int64
 
+	if err = r.do(ctx, "assign", radix.NewEvalScript(4+len(keys), assignScript).Cmd(&ids, args...)); err != nil {
 		return
 	}
 
-	rv = make([]int64, len(result))
+	keys.EnableByID(ids)
 
-	for i, r := range result {
-		if r == "" {
-			continue
-		}
-
-		if rv[i], err = strconv.ParseInt(r, 10, 64); err != nil {
-			return
-		}
+	if err = r.track(ctx, seg.Org, key, time.Now().Add(r.expiration())); err != nil {
+		return
 	}
 
-	return rv, err
+	return key, nil
 }
@@ -0,0 +1,197 @@
+//nolint:testpackage
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mediocregopher/radix/v3"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+func newTestStore(t *testing.T) (*redis, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(mr.Close)
+
+	pool, err := radix.NewPool("tcp", mr.Addr(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = pool.Close() })
+
+	return New(pool, time.Minute).(*redis), mr
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	r, mr := newTestStore(t)
+	seg := toggle.Segment{Org: 4, App: "android", Env: "production", Version: "3.0", Platform: "tv"}
+
+	for i := 0; i < 3; i++ {
+		if _, err := r.TogglesAssign(ctx, seg, toggle.Keys{{ID: 7, Rate: 1}, {ID: 8, Rate: 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segment := segmentKey(seg)
+
+	// drift: lost decrement, counter for gone toggle and untracked state.
+	_, _ = mr.Incr(clientsKey(seg.Org, segment), 2)
+	_ = mr.Set(toggleKey(seg.Org, segment, 9), "5")
+	_ = mr.Del(toggleKey(seg.Org, segment, 8))
+
+	members, _ := mr.ZMembers(expiryKey())
+	_, _ = mr.ZRem(expiryKey(), members[0])
+
+	rep, err := r.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.States != 3 || rep.Untracked != 1 || len(rep.Counters) != 3 {
+		t.Fatalf("report: %+v", rep)
+	}
+
+	if rep, err = r.Reconcile(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range rep.Counters {
+		if !d.Repaired {
+			t.Fatalf("not repaired: %+v", d)
+		}
+	}
+
+	if rep, err = r.Reconcile(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.Untracked != 0 || len(rep.Counters) != 0 {
+		t.Fatalf("after repair: %+v", rep)
+	}
+}
+
+func TestReconcileInFlight(t *testing.T) {
+	ctx := context.Background()
+	r, mr := newTestStore(t)
+	busy := toggle.Segment{Org: 4, App: "android", Env: "production", Version: "3.0", Platform: "tv"}
+	idle := toggle.Segment{Org: 4, App: "android", Env: "production", Version: "3.0", Platform: "watch"}
+
+	for _, seg := range []toggle.Segment{busy, idle} {
+		if _, err := r.TogglesAssign(ctx, seg, toggle.Keys{{ID: 7, Rate: 1}}); err != nil {
+			t.Fatal(err)
+		}
+
+		_, _ = mr.Incr(clientsKey(seg.Org, segmentKey(seg)), 1)
+	}
+
+	rep, versions, err := r.discrepancies(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rep.Counters) != 2 {
+		t.Fatalf("report: %+v", rep)
+	}
+
+	// client of busy segment comes and goes after states were counted: counters hold same values,
+	// but segment was changed, so they can not be trusted.
+	key, err := r.TogglesAssign(ctx, busy, toggle.Keys{{ID: 7, Rate: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.DropState(ctx, busy.Org, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.repairCounters(ctx, rep, versions); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range rep.Counters {
+		if want := d.Key == clientsKey(idle.Org, segmentKey(idle)); d.Repaired != want {
+			t.Fatalf("repaired: %+v (want: %v)", d, want)
+		}
+	}
+
+	if n, _ := r.ClientsCount(ctx, busy); n != 2 {
+		t.Fatalf("busy clients = %d (want: 2, left as is)", n)
+	}
+
+	if n, _ := r.ClientsCount(ctx, idle); n != 1 {
+		t.Fatalf("idle clients = %d (want: 1)", n)
+	}
+}
+
+func TestUntrackedStateReaped(t *testing.T) {
+	ctx := context.Background()
+	r, mr := newTestStore(t)
+	seg := toggle.Segment{Org: 6, App: "web", Env: "production", Version: "1.0", Platform: "chrome"}
+
+	key, err := r.TogglesAssign(ctx, seg, toggle.Keys{{ID: 1, Rate: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replica crashed between assign script and expiry index update.
+	_, _ = mr.ZRem(expiryKey(), expiryEntry(seg.Org, key))
+
+	mr.FastForward(2 * time.Minute)
+
+	rep, err := r.Reconcile(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.Untracked != 1 {
+		t.Fatalf("report: %+v", rep)
+	}
+
+	if _, dropped, err := r.ReapExpired(ctx, time.Now(), 10); err != nil || dropped != 1 {
+		t.Fatalf("dropped: %d err: %v", dropped, err)
+	}
+
+	if n, _ := r.ClientsCount(ctx, seg); n != 0 {
+		t.Fatalf("clients = %d (want: 0)", n)
+	}
+}
+
+func TestSegmentKeysShareSlot(t *testing.T) {
+	seg := toggle.Segment{Org: 5, App: "ios", Env: "dev", Version: "2.1", Platform: "ipad"}
+	segment := segmentKey(seg)
+	key := newClientKey(segment)
+
+	slot := radix.ClusterSlot([]byte(clientsKey(seg.Org, segment)))
+
+	for _, k := range []string{
+		stateKey(seg.Org, key),
+		aliveKey(seg.Org, key),
+		versionKey(seg.Org, segment),
+		toggleKey(seg.Org, segment, 1),
+		toggleKey(seg.Org, segment, 100500),
+	} {
+		if s := radix.ClusterSlot([]byte(k)); s != slot {
+			t.Fatalf("key %s: slot = %d (want: %d)", k, s, slot)
+		}
+	}
+
+	org, id, err := parseStateKey(stateKey(seg.Org, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if org != seg.Org || id != key {
+		t.Fatalf("parsed: %d %s (want: %d %s)", org, id, seg.Org, key)
+	}
+}
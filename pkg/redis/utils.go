@@ -7,7 +7,9 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/fxamacker/cbor/v2"
+	"github.com/google/uuid"
+
+	"github.com/s0rg/toggle-svc/pkg/toggle"
 )
 
 const (
@@ -17,53 +19,94 @@ const (
 	keyCount   = "count"
 	keyState   = "state"
 	keyAlive   = "alive"
+	keyVersion = "version"
+	stateSep   = "|"
+	idsSep     = ","
+	clientSep  = "."
 )
 
 var b64enc = base64.RawURLEncoding
 
-func segmentKey(app, version, platform string) string {
+func segmentKey(seg toggle.Segment) string {
 	h := fnv.New128a()
-	_, _ = io.WriteString(h, strings.Join([]string{app, version, platform}, ":"))
+	_, _ = io.WriteString(h, strings.Join([]string{seg.App, seg.Env, seg.Version, seg.Platform}, ":"))
 
 	return b64enc.EncodeToString(h.Sum(nil))
 }
 
-func clientsKey(appKey string) string {
-	return strings.Join([]string{keyPrefix, keyClients, appKey, keyCount}, ":")
+func orgPrefix(org int64) string {
+	return keyPrefix + ":" + strconv.FormatInt(org, 10)
 }
 
-func toggleKey(appKey string, toggleID int64) string {
-	return strings.Join([]string{keyPrefix, keyToggles, appKey, strconv.Itoa(int(toggleID)), keyCount}, ":")
+// segmentPrefix wraps segment key in hash tag, so under Redis Cluster all keys of segment
+// (counters and client states) share same slot and can be used together in scripts.
+func segmentPrefix(org int64, segment string) string {
+	return orgPrefix(org) + ":{" + segment + "}"
 }
 
-func stateKey(key string) string {
-	return strings.Join([]string{keyPrefix, keyClients, key, keyState}, ":")
+// newClientKey creates new client key, it embeds segment key, so client state can be
+// located in segment's slot.
+func newClientKey(segment string) string {
+	return segment + clientSep + uuid.New().String()
 }
 
-func aliveKey(key string) string {
-	return strings.Join([]string{keyPrefix, keyClients, key, keyAlive}, ":")
+// splitClientKey splits client key onto segment key and client id.
+func splitClientKey(key string) (segment, id string) {
+	parts := strings.SplitN(key, clientSep, 2)
+	if len(parts) != 2 {
+		return "", key
+	}
+
+	return parts[0], parts[1]
 }
 
-func encodeState(s state) (rv string, err error) {
-	var b []byte
+func clientsKey(org int64, segment string) string {
+	return strings.Join([]string{segmentPrefix(org, segment), keyClients, keyCount}, ":")
+}
 
-	if b, err = cbor.Marshal(s); err != nil {
-		return
-	}
+// versionKey holds number of segment changes (assigns and drops of its clients), so
+// reconcile can tell, if segment was changed while it was counting.
+func versionKey(org int64, segment string) string {
+	return segmentPrefix(org, segment) + ":" + keyVersion
+}
+
+func toggleKey(org int64, segment string, toggleID int64) string {
+	return strings.Join([]string{segmentPrefix(org, segment), keyToggles, strconv.FormatInt(toggleID, 10), keyCount}, ":")
+}
 
-	rv = b64enc.EncodeToString(b)
+func stateKey(org int64, key string) string {
+	segment, id := splitClientKey(key)
 
-	return rv, nil
+	return strings.Join([]string{segmentPrefix(org, segment), keyClients, id, keyState}, ":")
 }
 
+func aliveKey(org int64, key string) string {
+	segment, id := splitClientKey(key)
+
+	return strings.Join([]string{segmentPrefix(org, segment), keyClients, id, keyAlive}, ":")
+}
+
+// decodeState parses state, written by assignScript ("{segment}|{id},{id}...").
 func decodeState(s string) (rv state, err error) {
-	var b []byte
+	parts := strings.SplitN(s, stateSep, 2)
+	if len(parts) != 2 {
+		return rv, errBadState
+	}
 
-	if b, err = b64enc.DecodeString(s); err != nil {
-		return
+	rv.Segment = parts[0]
+
+	if parts[1] == "" {
+		return rv, nil
 	}
 
-	err = cbor.Unmarshal(b, &rv)
+	ids := strings.Split(parts[1], idsSep)
+	rv.Toggles = make([]int64, len(ids))
+
+	for i, id := range ids {
+		if rv.Toggles[i], err = strconv.ParseInt(id, 10, 64); err != nil {
+			return
+		}
+	}
 
-	return rv, err
+	return rv, nil
 }
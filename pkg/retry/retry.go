@@ -1,44 +1,105 @@
 package retry
 
 import (
+	"context"
 	"log"
+	"math"
+	"math/rand"
 	"time"
 )
 
-var (
-	sleepBase   = time.Second * 5
-	sleepJitter = time.Second
-)
+// Policy describes retries with exponential backoff and full jitter: n-th retry (counting from zero)
+// waits for random duration in [0, min(Max, Base*2^n)).
+type Policy struct {
+	// Tries limits number of attempts, zero means no limit (then MaxElapsed should be set).
+	Tries int
+	// Base is a backoff of first retry.
+	Base time.Duration
+	// Max caps backoff, zero means no cap.
+	Max time.Duration
+	// MaxElapsed limits total time spent, retry is not started, if its backoff ends later, zero means no limit.
+	MaxElapsed time.Duration
+	// Retryable classifies errors, nil means that every error is retryable.
+	Retryable func(error) bool
+}
 
 type Step struct {
 	Name string
 	Do   func() error
 }
 
-// Retry executes 'fn', if error happened, it will be logged,
-// and next attempt will be committed, after short sleep, 'tries' times.
-func Run(tries int, reason string, fn func() error) (err error) {
-	for t := 0; t < tries; t++ {
-		err = fn()
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
 
-		switch {
-		case err == nil:
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Backoff returns random wait duration before n-th retry.
+func (p *Policy) Backoff(n int) time.Duration {
+	d := p.Base
+
+	for i := 0; i < n && d < math.MaxInt64/2 && (p.Max <= 0 || d < p.Max); i++ {
+		d *= 2
+	}
+
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	//nolint:gosec // jitter does not need crypto rand.
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// Run executes 'fn' until it succeeds, returns non-retryable error, or policy limits are reached,
+// waits between attempts are interrupted by ctx, returns last error of 'fn'. Failed attempts are
+// logged, if 'reason' is not empty.
+func Run(ctx context.Context, p Policy, reason string, fn func() error) (err error) {
+	start := time.Now()
+
+	for t := 0; p.Tries <= 0 || t < p.Tries; t++ {
+		if err = fn(); err == nil || (p.Retryable != nil && !p.Retryable(err)) {
 			return
-		case t < tries:
-			log.Printf("[retry] %s: try %d last err: %v", reason, t+1, err)
-			time.Sleep(sleepBase + sleepJitter*time.Duration(t+1))
+		}
+
+		if p.Tries > 0 && t+1 == p.Tries {
+			break
+		}
+
+		d := p.Backoff(t)
+
+		if p.MaxElapsed > 0 && time.Since(start)+d > p.MaxElapsed {
+			break
+		}
+
+		if reason != "" {
+			log.Printf("[retry] %s: try %d last err: %v, next in: %v", reason, t+1, err, d)
+		}
+
+		if sleep(ctx, d) != nil {
+			break
 		}
 	}
 
-	return
+	return err
 }
 
-// RunSteps executes several `steps` one by one, returns first error.
-func RunSteps(tries int, steps []Step) (err error) {
+// RunSteps executes several `steps` one by one, every step is retried by policy, returns first error.
+func RunSteps(ctx context.Context, p Policy, steps []Step) (err error) {
 	for i := 0; i < len(steps); i++ {
 		step := &steps[i]
 
-		if err = Run(tries, step.Name, step.Do); err != nil {
+		if err = Run(ctx, p, step.Name, step.Do); err != nil {
 			return
 		}
 	}
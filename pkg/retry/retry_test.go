@@ -2,14 +2,28 @@
 package retry
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/lib/pq"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
 )
 
 const maxTries = 3
 
-var errFail = errors.New("test fail")
+var (
+	errFail  = errors.New("test fail")
+	testPlan = Policy{Tries: maxTries, Base: time.Millisecond, Max: 4 * time.Millisecond}
+)
 
 type failer struct {
 	fn  func()
@@ -37,9 +51,6 @@ func (f *failer) Reset(limit int) {
 }
 
 func TestRun(t *testing.T) {
-	sleepBase = time.Millisecond * 100
-	sleepJitter = time.Millisecond * 20
-
 	var table = []struct {
 		errCount    int
 		countExpext int
@@ -60,8 +71,8 @@ func TestRun(t *testing.T) {
 	for n, s := range table {
 		fail.Reset(s.errCount)
 
-		err = Run(maxTries, "", fail.Fail)
-		if errors.Is(err, s.errExpect) {
+		err = Run(context.Background(), testPlan, "", fail.Fail)
+		if !errors.Is(err, s.errExpect) {
 			t.Fatalf("step %d: err == %v", n, err)
 		}
 
@@ -74,9 +85,6 @@ func TestRun(t *testing.T) {
 }
 
 func TestRunSteps(t *testing.T) {
-	sleepBase = time.Millisecond * 100
-	sleepJitter = time.Millisecond * 20
-
 	var table = []struct {
 		errCountA    int
 		countAExpext int
@@ -107,8 +115,8 @@ func TestRunSteps(t *testing.T) {
 		failA.Reset(s.errCountA)
 		failB.Reset(s.errCountB)
 
-		err = RunSteps(maxTries, steps)
-		if errors.Is(err, s.errExpect) {
+		err = RunSteps(context.Background(), testPlan, steps)
+		if !errors.Is(err, s.errExpect) {
 			t.Fatalf("step %d: err == %v", n, err)
 		}
 
@@ -123,3 +131,160 @@ func TestRunSteps(t *testing.T) {
 		countA, countB = 0, 0
 	}
 }
+
+func TestBackoff(t *testing.T) {
+	p := Policy{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+
+	var table = []struct {
+		n   int
+		max time.Duration
+	}{
+		{0, 10 * time.Millisecond},
+		{1, 20 * time.Millisecond},
+		{2, 40 * time.Millisecond},
+		{3, 50 * time.Millisecond},
+		{100, 50 * time.Millisecond},
+	}
+
+	for _, s := range table {
+		for i := 0; i < 100; i++ {
+			if d := p.Backoff(s.n); d < 0 || d >= s.max {
+				t.Fatalf("n: %d backoff = %v (want: [0, %v))", s.n, d, s.max)
+			}
+		}
+	}
+
+	// no cap must not overflow.
+	p.Max = 0
+
+	if d := p.Backoff(200); d < 0 {
+		t.Fatalf("uncapped backoff = %v", d)
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	ctx := context.Background()
+	errFatal := errors.New("fatal")
+
+	var count int
+
+	fail := func(err error) func() error {
+		return func() error {
+			count++
+
+			return err
+		}
+	}
+
+	p := testPlan
+	p.Retryable = func(err error) bool { return !errors.Is(err, errFatal) }
+
+	if err := Run(ctx, p, "", fail(errFatal)); !errors.Is(err, errFatal) || count != 1 {
+		t.Fatalf("non-retryable: err = %v count = %d", err, count)
+	}
+
+	// elapsed time limit stops unlimited tries.
+	count = 0
+	p = Policy{Base: 10 * time.Millisecond, MaxElapsed: 30 * time.Millisecond}
+
+	if err := Run(ctx, p, "", fail(errFail)); !errors.Is(err, errFail) || count < 2 {
+		t.Fatalf("max elapsed: err = %v count = %d", err, count)
+	}
+
+	// canceled context interrupts waits.
+	count = 0
+	p = Policy{Base: time.Hour}
+
+	cctx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if err := Run(cctx, p, "ctx", fail(errFail)); !errors.Is(err, errFail) || count != 1 {
+		t.Fatalf("canceled: err = %v count = %d", err, count)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	var table = []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errFail, false},
+		{sql.ErrNoRows, false},
+		{context.Canceled, false},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), false},
+		{io.EOF, true},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "57P01"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{resp2.Error{E: errors.New("LOADING Redis is loading the dataset in memory")}, true},
+		{resp2.Error{E: errors.New("WRONGTYPE Operation against a key")}, false},
+	}
+
+	for n, s := range table {
+		if got := Transient(s.err); got != s.want {
+			t.Fatalf("step %d: Transient(%v) = %v (want: %v)", n, s.err, got, s.want)
+		}
+	}
+}
+
+type flakyDB struct {
+	db.Store
+	fails int
+	calls int
+}
+
+func (f *flakyDB) GetAppID(ctx context.Context, orgID int64, app string) (int64, error) {
+	f.calls++
+
+	if f.calls <= f.fails {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return f.Store.GetAppID(ctx, orgID, app)
+}
+
+func (f *flakyDB) AddApps(ctx context.Context, orgID int64, names []string) error {
+	f.calls++
+
+	return io.ErrUnexpectedEOF
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	mem := db.NewMemory()
+
+	if err := mem.AddOrg(ctx, "acme", "acme-key", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mem.AddApps(ctx, 1, []string{"app"}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := testPlan
+	p.Retryable = Transient
+
+	f := &flakyDB{Store: mem, fails: maxTries - 1}
+	s := DB(f, p)
+
+	if _, err := s.GetAppID(ctx, 1, "app"); err != nil || f.calls != maxTries {
+		t.Fatalf("read: err = %v calls = %d", err, f.calls)
+	}
+
+	f.calls = 0
+
+	// writes are not retried.
+	if err := s.AddApps(ctx, 1, []string{"other"}); !errors.Is(err, io.ErrUnexpectedEOF) || f.calls != 1 {
+		t.Fatalf("write: err = %v calls = %d", err, f.calls)
+	}
+
+	f.calls, f.fails = 0, maxTries
+
+	if _, err := s.GetAppID(ctx, 1, "app"); !errors.Is(err, io.ErrUnexpectedEOF) || f.calls != maxTries {
+		t.Fatalf("exhausted: err = %v calls = %d", err, f.calls)
+	}
+}
@@ -0,0 +1,206 @@
+package retry
+
+import (
+	"context"
+	"time"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/redis"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+type (
+	dbStore struct {
+		db.Store
+		p Policy
+	}
+
+	redisStore struct {
+		redis.Store
+		p Policy
+	}
+)
+
+// DB retries reads (and only them, as writes may be applied despite error) of store by policy.
+func DB(s db.Store, p Policy) db.Store {
+	return &dbStore{Store: s, p: p}
+}
+
+// Redis retries reads and idempotent writes of store by policy, new client states are never retried.
+func Redis(s redis.Store, p Policy) redis.Store {
+	return &redisStore{Store: s, p: p}
+}
+
+func (d *dbStore) GetOrgID(ctx context.Context, apiKey string) (id int64, err error) {
+	err = Run(ctx, d.p, "", func() (err error) {
+		id, err = d.Store.GetOrgID(ctx, apiKey)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetOrgKeys(ctx context.Context) (rv map[int64]string, err error) {
+	err = Run(ctx, d.p, "", func() (err error) {
+		rv, err = d.Store.GetOrgKeys(ctx)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetApps(ctx context.Context, orgID int64) (rv []string, err error) {
+	err = Run(ctx, d.p, "", func() (err error) {
+		rv, err = d.Store.GetApps(ctx, orgID)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetAppID(ctx context.Context, orgID int64, app string) (id int64, err error) {
+	err = Run(ctx, d.p, "", func() (err error) {
+		id, err = d.Store.GetAppID(ctx, orgID, app)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetEnvs(ctx context.Context) (rv []string, err error) {
+	err = Run(ctx, d.p, "", func() (err error) {
+		rv, err = d.Store.GetEnvs(ctx)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform string,
+) (rv toggle.Keys, err error) {
+	err = Run(ctx, d.p, "", func() (err error) {
+		rv, err = d.Store.GetAppFeatures(ctx, orgID, appID, env, version, platform)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetAppKeys(
+	ctx context.Context,
+	orgID, appID int64,
+	filter toggle.KeyFilter,
+) (rv []toggle.KeyInfo, err error) {
+	err = Run(ctx, d.p, "", func() (err error) {
+		rv, err = d.Store.GetAppKeys(ctx, orgID, appID, filter)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetOrgs(ctx context.Context) (rv []int64, err error) {
+	err = Run(ctx, d.p, "", func() (err error) {
+		rv, err = d.Store.GetOrgs(ctx)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetToggles(ctx context.Context, orgID int64) (rv []toggle.Toggle, err error) {
+	err = Run(ctx, d.p, "", func() (err error) {
+		rv, err = d.Store.GetToggles(ctx, orgID)
+
+		return
+	})
+
+	return
+}
+
+func (d *dbStore) GetSegments(ctx context.Context, orgID int64) (rv []toggle.Segment, err error) {
+	err = Run(ctx, d.p, "", func() (err error) {
+		rv, err = d.Store.GetSegments(ctx, orgID)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) ClientsCount(ctx context.Context, seg toggle.Segment) (n int64, err error) {
+	err = Run(ctx, r.p, "", func() (err error) {
+		n, err = r.Store.ClientsCount(ctx, seg)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) MarkAlive(ctx context.Context, org int64, key string) error {
+	return Run(ctx, r.p, "", func() error {
+		return r.Store.MarkAlive(ctx, org, key)
+	})
+}
+
+func (r *redisStore) GetState(ctx context.Context, org int64, key string) (ids []int64, found bool, err error) {
+	err = Run(ctx, r.p, "", func() (err error) {
+		ids, found, err = r.Store.GetState(ctx, org, key)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) IsAlive(ctx context.Context, org int64, key string) (ok bool, err error) {
+	err = Run(ctx, r.p, "", func() (err error) {
+		ok, err = r.Store.IsAlive(ctx, org, key)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) TogglesCount(ctx context.Context, seg toggle.Segment, ids []int64) (rv []int64, err error) {
+	err = Run(ctx, r.p, "", func() (err error) {
+		rv, err = r.Store.TogglesCount(ctx, seg, ids)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) ExpiryStats(ctx context.Context, now time.Time) (tracked, overdue int64, err error) {
+	err = Run(ctx, r.p, "", func() (err error) {
+		tracked, overdue, err = r.Store.ExpiryStats(ctx, now)
+
+		return
+	})
+
+	return
+}
+
+func (r *redisStore) ExpiryEntries(ctx context.Context, offset, limit int) (rv []redis.Tracked, err error) {
+	err = Run(ctx, r.p, "", func() (err error) {
+		rv, err = r.Store.ExpiryEntries(ctx, offset, limit)
+
+		return
+	})
+
+	return
+}
@@ -0,0 +1,69 @@
+package retry
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/lib/pq"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+var (
+	// postgres error classes and codes, that are worth retrying.
+	pgClasses = map[pq.ErrorClass]bool{
+		"08": true, // connection exception
+		"40": true, // transaction rollback: serialization failure, deadlock
+		"53": true, // insufficient resources: too many connections
+	}
+	pgCodes = map[pq.ErrorCode]bool{
+		"57P01": true, // admin shutdown
+		"57P02": true, // crash shutdown
+		"57P03": true, // cannot connect now
+	}
+
+	// redis error replies, sent while server is not ready to serve.
+	redisPrefixes = []string{"LOADING", "BUSY", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN", "READONLY"}
+)
+
+// Transient reports, if err looks like temporary database or redis failure (broken or refused connection,
+// timeout, failover, etc), that may pass on retry. Context errors are never transient.
+func Transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var (
+		pgErr  *pq.Error
+		rdErr  resp2.Error
+		netErr net.Error
+	)
+
+	switch {
+	case errors.As(err, &pgErr):
+		return pgClasses[pgErr.Code.Class()] || pgCodes[pgErr.Code]
+	case errors.As(err, &rdErr):
+		msg := rdErr.Error()
+
+		for _, p := range redisPrefixes {
+			if strings.HasPrefix(msg, p) {
+				return true
+			}
+		}
+
+		return false
+	case errors.As(err, &netErr):
+		return true
+	}
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,86 @@
+package toggle
+
+import "time"
+
+// Reasons for toggle to be stale.
+const (
+	StaleUntouched = "untouched"
+	StaleStuck     = "stuck"
+	StaleUnused    = "unused"
+	StaleExpired   = "expired"
+)
+
+type (
+	// Toggle describes single toggle of app segment.
+	Toggle struct {
+		ID        int64      `json:"id"`
+		App       string     `json:"app"`
+		Env       string     `json:"env"`
+		Version   string     `json:"version"`
+		Platform  string     `json:"platform"`
+		Key       string     `json:"key"`
+		Rate      float64    `json:"rate"`
+		UpdatedAt time.Time  `json:"updated_at"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+		StaleAt   *time.Time `json:"stale_at,omitempty"`
+	}
+
+	// Stale is a toggle, with reasons for it to be cleaned up.
+	Stale struct {
+		Toggle
+		Reasons []string `json:"reasons"`
+	}
+)
+
+// Segment returns segment for toggle, in given organization.
+func (t *Toggle) Segment(org int64) Segment {
+	return Segment{
+		Org:      org,
+		App:      t.App,
+		Env:      t.Env,
+		Version:  t.Version,
+		Platform: t.Platform,
+	}
+}
+
+// StaleReasons returns reasons, why toggle is stale at `now`, toggle is:
+//
+// - untouched, if it was not updated for `maxAge`
+// - stuck, if it is untouched and fully switched on or off
+// - unused, if it has no alive clients (`clients` is their count)
+// - expired, if its key expiration date has passed.
+func (t *Toggle) StaleReasons(now time.Time, maxAge time.Duration, clients int64) (rv []string) {
+	untouched := now.Sub(t.UpdatedAt) > maxAge
+
+	if untouched {
+		rv = append(rv, StaleUntouched)
+
+		if t.Rate == 0 || t.Rate == 1 {
+			rv = append(rv, StaleStuck)
+		}
+	}
+
+	if clients == 0 {
+		rv = append(rv, StaleUnused)
+	}
+
+	if t.ExpiresAt != nil && now.After(*t.ExpiresAt) {
+		rv = append(rv, StaleExpired)
+	}
+
+	return rv
+}
+
+// Archivable reports if stale toggle can be safely removed: it must have no alive
+// clients and at least one more reason to be stale.
+func (s *Stale) Archivable() bool {
+	var unused bool
+
+	for _, r := range s.Reasons {
+		if r == StaleUnused {
+			unused = true
+		}
+	}
+
+	return unused && len(s.Reasons) > 1
+}
@@ -0,0 +1,50 @@
+//nolint:testpackage
+package toggle
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStaleReasons(t *testing.T) {
+	const maxAge = time.Hour
+
+	var (
+		now     = time.Now()
+		fresh   = now.Add(-time.Minute)
+		old     = now.Add(-maxAge * 2)
+		expired = now.Add(-time.Second)
+	)
+
+	var table = []struct {
+		rate       float64
+		updated    time.Time
+		expires    *time.Time
+		clients    int64
+		reasons    []string
+		archivable bool
+	}{
+		{0.5, fresh, nil, 1, nil, false},
+		{0.5, fresh, nil, 0, []string{StaleUnused}, false},
+		{0.5, old, nil, 1, []string{StaleUntouched}, false},
+		{1.0, old, nil, 1, []string{StaleUntouched, StaleStuck}, false},
+		{1.0, old, nil, 0, []string{StaleUntouched, StaleStuck, StaleUnused}, true},
+		{0.5, fresh, &expired, 1, []string{StaleExpired}, false},
+		{0.5, fresh, &expired, 0, []string{StaleUnused, StaleExpired}, true},
+	}
+
+	for n, s := range table {
+		tg := Toggle{Rate: s.rate, UpdatedAt: s.updated, ExpiresAt: s.expires}
+
+		reasons := tg.StaleReasons(now, maxAge, s.clients)
+		if !reflect.DeepEqual(reasons, s.reasons) {
+			t.Fatalf("step %d: reasons = %v (want: %v)", n, reasons, s.reasons)
+		}
+
+		st := Stale{Toggle: tg, Reasons: reasons}
+		if st.Archivable() != s.archivable {
+			t.Fatalf("step %d: archivable = %t (want: %t)", n, st.Archivable(), s.archivable)
+		}
+	}
+}
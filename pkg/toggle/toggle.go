@@ -1,32 +1,66 @@
 package toggle
 
+import (
+	"math"
+	"time"
+)
+
+// Lifecycle stages of toggle keys.
+const (
+	StageExperiment = "experiment"
+	StageRelease    = "release"
+	StageOps        = "ops"
+	StagePermanent  = "permanent"
+)
+
 type (
 	// Key holds single toggle key params.
 	Key struct {
 		ID   int64   `json:"id"`
 		Rate float64 `json:"rate"`
 		Name string  `json:"key"`
+		// Meta is optional, it used only upon key creation.
+		Meta *Meta `json:"meta,omitempty"`
 	}
 
-	// Keys is a shorthand for []Key.
-	Keys []Key
-)
+	// Meta holds descriptive toggle key params.
+	Meta struct {
+		Description string     `json:"description"`
+		Owner       string     `json:"owner"`
+		Tags        []string   `json:"tags"`
+		Link        string     `json:"link"`
+		Stage       string     `json:"stage"`
+		CreatedBy   string     `json:"created_by"`
+		ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	}
 
-func toggleRate(total, curr int64) float64 {
-	// We need curr+1 cause total already counts us, but curr is not.
-	return float64(curr+1) / float64(total)
-}
+	// KeyInfo describes toggle key of app.
+	KeyInfo struct {
+		Meta
+		ID        int64     `json:"id"`
+		Name      string    `json:"key"`
+		CreatedAt time.Time `json:"created_at"`
+	}
 
-// DisableByRate updates toggles states, switching off currently over-used toggles.
-func (k Keys) DisableByRate(total int64, counts []int64) {
-	for i := 0; i < len(k); i++ {
-		pk, pc := &k[i], counts[i]
+	// KeyFilter selects keys by their meta, empty fields are ignored.
+	KeyFilter struct {
+		Owner string
+		Tag   string
+		Stage string
+	}
 
-		if pk.Rate < 1.0 && toggleRate(total, pc) > pk.Rate {
-			pk.Rate = 0
-		}
+	// Keys is a shorthand for []Key.
+	Keys []Key
+
+	// Segment identifies group of clients, that shares same toggles set.
+	Segment struct {
+		Org      int64
+		App      string
+		Env      string
+		Version  string
+		Platform string
 	}
-}
+)
 
 // Names returns slice of toggles keys names.
 func (k Keys) Names() (rv []string) {
@@ -56,3 +90,18 @@ func (k Keys) EnableByID(ids []int64) {
 		}
 	}
 }
+
+// ValidStage checks if given lifecycle stage is known.
+func ValidStage(stage string) bool {
+	switch stage {
+	case StageExperiment, StageRelease, StageOps, StagePermanent:
+		return true
+	}
+
+	return false
+}
+
+// RoundRate rounds rate to DECIMAL(3,2) precision of schema, as not every dialect does it.
+func RoundRate(rate float64) float64 {
+	return math.Round(rate*100) / 100
+}
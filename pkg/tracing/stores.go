@@ -0,0 +1,170 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/s0rg/toggle-svc/pkg/db"
+	"github.com/s0rg/toggle-svc/pkg/manifest"
+	"github.com/s0rg/toggle-svc/pkg/toggle"
+)
+
+type dbStore struct {
+	s db.Store
+}
+
+// DB traces store calls.
+func DB(s db.Store) db.Store {
+	return &dbStore{s: s}
+}
+
+func (d *dbStore) AddOrg(ctx context.Context, name, apiKey string, maxApps, maxKeys int) (err error) {
+	ctx, end := Start(ctx, "db.AddOrg")
+	defer end(&err)
+
+	return d.s.AddOrg(ctx, name, apiKey, maxApps, maxKeys)
+}
+
+func (d *dbStore) GetOrgID(ctx context.Context, apiKey string) (_ int64, err error) {
+	ctx, end := Start(ctx, "db.GetOrgID")
+	defer end(&err)
+
+	return d.s.GetOrgID(ctx, apiKey)
+}
+
+func (d *dbStore) GetOrgKeys(ctx context.Context) (_ map[int64]string, err error) {
+	ctx, end := Start(ctx, "db.GetOrgKeys")
+	defer end(&err)
+
+	return d.s.GetOrgKeys(ctx)
+}
+
+func (d *dbStore) GetApps(ctx context.Context, orgID int64) (_ []string, err error) {
+	ctx, end := Start(ctx, "db.GetApps")
+	defer end(&err)
+
+	return d.s.GetApps(ctx, orgID)
+}
+
+func (d *dbStore) GetAppID(ctx context.Context, orgID int64, app string) (_ int64, err error) {
+	ctx, end := Start(ctx, "db.GetAppID")
+	defer end(&err)
+
+	return d.s.GetAppID(ctx, orgID, app)
+}
+
+func (d *dbStore) GetEnvs(ctx context.Context) (_ []string, err error) {
+	ctx, end := Start(ctx, "db.GetEnvs")
+	defer end(&err)
+
+	return d.s.GetEnvs(ctx)
+}
+
+func (d *dbStore) GetAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform string,
+) (_ toggle.Keys, err error) {
+	ctx, end := Start(ctx, "db.GetAppFeatures")
+	defer end(&err)
+
+	return d.s.GetAppFeatures(ctx, orgID, appID, env, version, platform)
+}
+
+func (d *dbStore) GetAppKeys(
+	ctx context.Context,
+	orgID, appID int64,
+	filter toggle.KeyFilter,
+) (_ []toggle.KeyInfo, err error) {
+	ctx, end := Start(ctx, "db.GetAppKeys")
+	defer end(&err)
+
+	return d.s.GetAppKeys(ctx, orgID, appID, filter)
+}
+
+func (d *dbStore) EditAppKey(ctx context.Context, orgID, appID int64, key string, meta *toggle.Meta) (err error) {
+	ctx, end := Start(ctx, "db.EditAppKey")
+	defer end(&err)
+
+	return d.s.EditAppKey(ctx, orgID, appID, key, meta)
+}
+
+func (d *dbStore) AddApps(ctx context.Context, orgID int64, names []string) (err error) {
+	ctx, end := Start(ctx, "db.AddApps")
+	defer end(&err)
+
+	return d.s.AddApps(ctx, orgID, names)
+}
+
+func (d *dbStore) AddAppFeatures(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version string,
+	platforms []string,
+	features toggle.Keys,
+) (err error) {
+	ctx, end := Start(ctx, "db.AddAppFeatures")
+	defer end(&err)
+
+	return d.s.AddAppFeatures(ctx, orgID, appID, env, version, platforms, features)
+}
+
+func (d *dbStore) EditAppFeature(
+	ctx context.Context,
+	orgID, appID int64,
+	env, version, platform, key string,
+	rate float64,
+) (err error) {
+	ctx, end := Start(ctx, "db.EditAppFeature")
+	defer end(&err)
+
+	return d.s.EditAppFeature(ctx, orgID, appID, env, version, platform, key, rate)
+}
+
+func (d *dbStore) PromoteAppFeatures(ctx context.Context, orgID, appID int64, from, to string) (err error) {
+	ctx, end := Start(ctx, "db.PromoteAppFeatures")
+	defer end(&err)
+
+	return d.s.PromoteAppFeatures(ctx, orgID, appID, from, to)
+}
+
+func (d *dbStore) GetOrgs(ctx context.Context) (_ []int64, err error) {
+	ctx, end := Start(ctx, "db.GetOrgs")
+	defer end(&err)
+
+	return d.s.GetOrgs(ctx)
+}
+
+func (d *dbStore) GetToggles(ctx context.Context, orgID int64) (_ []toggle.Toggle, err error) {
+	ctx, end := Start(ctx, "db.GetToggles")
+	defer end(&err)
+
+	return d.s.GetToggles(ctx, orgID)
+}
+
+func (d *dbStore) GetSegments(ctx context.Context, orgID int64) (_ []toggle.Segment, err error) {
+	ctx, end := Start(ctx, "db.GetSegments")
+	defer end(&err)
+
+	return d.s.GetSegments(ctx, orgID)
+}
+
+func (d *dbStore) MarkStale(ctx context.Context, orgID int64, ids []int64) (err error) {
+	ctx, end := Start(ctx, "db.MarkStale")
+	defer end(&err)
+
+	return d.s.MarkStale(ctx, orgID, ids)
+}
+
+func (d *dbStore) ArchiveToggles(ctx context.Context, orgID int64, ids []int64) (_ int64, err error) {
+	ctx, end := Start(ctx, "db.ArchiveToggles")
+	defer end(&err)
+
+	return d.s.ArchiveToggles(ctx, orgID, ids)
+}
+
+func (d *dbStore) ApplyChanges(ctx context.Context, orgID int64, changes []manifest.Change) (err error) {
+	ctx, end := Start(ctx, "db.ApplyChanges")
+	defer end(&err)
+
+	return d.s.ApplyChanges(ctx, orgID, changes)
+}
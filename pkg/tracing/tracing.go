@@ -0,0 +1,121 @@
+// Package tracing sets up OpenTelemetry tracing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EnvExporter selects spans exporter: "otlp", "stdout" or "none" (default).
+const EnvExporter = "OTEL_TRACES_EXPORTER"
+
+const (
+	tracerName      = "github.com/s0rg/toggle-svc"
+	shutdownTimeout = 5 * time.Second
+)
+
+type provider struct {
+	p *sdktrace.TracerProvider
+}
+
+// Setup installs global tracer provider and propagator, exporter is chosen by EnvExporter, otlp
+// exporter is configured with standard OTEL_EXPORTER_OTLP_* variables. Returned closer flushes
+// pending spans, it is nil if tracing is off.
+func Setup(service string) (c io.Closer, err error) {
+	var exp sdktrace.SpanExporter
+
+	switch kind := strings.ToLower(os.Getenv(EnvExporter)); kind {
+	case "", "none":
+		return nil, nil
+	case "otlp":
+		exp, err = otlptracehttp.New(context.Background())
+	case "stdout":
+		exp, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter: %s", kind)
+	}
+
+	if err != nil {
+		return
+	}
+
+	var res *resource.Resource
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override given name.
+	if res, err = resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceNameKey.String(service)),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithTelemetrySDK(),
+	); err != nil {
+		return
+	}
+
+	p := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+
+	otel.SetTracerProvider(p)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return &provider{p: p}, nil
+}
+
+// Close flushes pending spans and stops exporter.
+func (p *provider) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return p.p.Shutdown(ctx)
+}
+
+// Server starts span `name` for incoming request, continuing trace, propagated in request headers,
+// returned function ends it with response code.
+func Server(name string, r *http.Request) (context.Context, func(code int)) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", r.URL.Path, r)...),
+	)
+
+	return ctx, func(code int) {
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(code)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(code))
+		span.End()
+	}
+}
+
+// Start starts client span, returned function ends it, recording error, if any.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(*error)) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+
+	return ctx, func(err *error) {
+		if e := *err; e != nil {
+			span.RecordError(e)
+			span.SetStatus(codes.Error, e.Error())
+		}
+
+		span.End()
+	}
+}
@@ -0,0 +1,70 @@
+package tracing //nolint:testpackage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpans(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parent  = "00f067aa0ba902b7"
+	)
+
+	rec := tracetest.NewSpanRecorder()
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	r := httptest.NewRequest(http.MethodPost, "/client/alive", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-"+parent+"-01")
+
+	ctx, end := Server("client-alive", r)
+
+	fail := errors.New("test fail")
+
+	_ = func(ctx context.Context) (err error) {
+		_, end := Start(ctx, "redis.GET")
+		defer end(&err)
+
+		return fail
+	}(ctx)
+
+	end(http.StatusInternalServerError)
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("spans = %d (want: 2)", len(spans))
+	}
+
+	child, srv := spans[0], spans[1]
+
+	if got := srv.Parent().SpanID().String(); got != parent {
+		t.Fatalf("server parent = %s (want: %s)", got, parent)
+	}
+
+	if got := child.SpanContext().TraceID().String(); got != traceID {
+		t.Fatalf("trace = %s (want: %s)", got, traceID)
+	}
+
+	if child.Parent().SpanID() != srv.SpanContext().SpanID() {
+		t.Fatal("client span is not a child of server one")
+	}
+
+	if child.Status().Code != codes.Error || child.Status().Description != fail.Error() {
+		t.Fatalf("client span status: %+v", child.Status())
+	}
+
+	if srv.Status().Code != codes.Error {
+		t.Fatalf("server span status: %+v", srv.Status())
+	}
+}